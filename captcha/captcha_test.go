@@ -1,9 +1,26 @@
 package captcha
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"svg-math-captcha/internal/randsource"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -443,48 +460,1766 @@ func TestCaptchaError(t *testing.T) {
 	}
 }
 
-// Benchmark tests
-func BenchmarkCaptchaGeneration(b *testing.B) {
-	generator := NewCaptchaGenerator(DefaultConfig())
+func TestFontGlyphs(t *testing.T) {
+	font, err := defaultFont()
+	if err != nil {
+		t.Fatalf("Failed to load embedded font: %v", err)
+	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := generator.CreateMathExpr()
+	for _, char := range "0123456789+-=" {
+		glyph, err := font.Glyph(char)
 		if err != nil {
-			b.Fatalf("Failed to generate captcha: %v", err)
+			t.Fatalf("Glyph(%q) failed: %v", char, err)
+		}
+		if len(glyph.Segments) == 0 {
+			t.Errorf("Glyph(%q) has no outline segments", char)
+		}
+		if glyph.Advance <= 0 {
+			t.Errorf("Glyph(%q) has non-positive advance %v", char, glyph.Advance)
 		}
+		if glyph.Segments[0].Op != 'M' || glyph.Segments[len(glyph.Segments)-1].Op != 'Z' {
+			t.Errorf("Glyph(%q) outline is not a closed contour", char)
+		}
+	}
+
+	if _, err := font.Glyph('\u4e2d'); err == nil {
+		t.Error("Expected error for glyph missing from font")
 	}
 }
 
-func BenchmarkMathExpressionGeneration(b *testing.B) {
+func TestDistortionConfigValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		distortion DistortionConfig
+		wantErr    bool
+	}{
+		{"disabled", DistortionConfig{}, false},
+		{"all filters", DistortionConfig{WaveAmplitude: 3, WavePeriod: 60, Skew: 20, Scale: 0.2, Overlap: 0.15, StrokeJitter: 1}, false},
+		{"negative amplitude", DistortionConfig{WaveAmplitude: -1}, true},
+		{"skew too large", DistortionConfig{Skew: 60}, true},
+		{"scale too large", DistortionConfig{Scale: 0.8}, true},
+		{"overlap too large", DistortionConfig{Overlap: 0.6}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Distortion = tt.distortion
+			err := config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDistortedRendering(t *testing.T) {
 	config := DefaultConfig()
-	generator := NewMathExpressionGenerator(config)
+	config.Noise = 0
+	config.Distortion = DistortionConfig{WaveAmplitude: 3, Skew: 20, Scale: 0.2, Overlap: 0.15, StrokeJitter: 1}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := generator.GenerateExpression()
-		if err != nil {
-			b.Fatalf("Failed to generate math expression: %v", err)
+	renderer := NewSVGRenderer(config)
+	expr := &MathExpression{Operand1: 3, Operand2: 5, Operator: "+", Answer: 8, Question: "3 + 5 = ?"}
+
+	svgData, err := renderer.RenderMathExpression(expr, config)
+	if err != nil {
+		t.Fatalf("Failed to render distorted SVG: %v", err)
+	}
+
+	if strings.Contains(svgData, "<text") {
+		t.Error("Distorted SVG should render glyph outlines instead of text elements")
+	}
+
+	// "3+5=" renders as four glyph paths
+	if count := strings.Count(svgData, "<path"); count != 4 {
+		t.Errorf("Expected 4 glyph paths, got %d", count)
+	}
+
+	// All outline points should stay near the canvas
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "3 + 5 = ", config); err != nil {
+		t.Fatalf("addTextToSVG failed: %v", err)
+	}
+	for _, path := range svg.Paths {
+		fields := strings.FieldsFunc(path.D, func(r rune) bool {
+			return r == 'M' || r == 'L' || r == 'Q' || r == 'Z' || r == ' ' || r == ','
+		})
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				t.Fatalf("Invalid path number %q: %v", field, err)
+			}
+			limit := float64(config.Width)
+			if i%2 == 1 {
+				limit = float64(config.Height)
+			}
+			if value < -limit*0.25 || value > limit*1.25 {
+				t.Errorf("Path coordinate %v far outside canvas", value)
+			}
 		}
 	}
 }
 
-func BenchmarkSVGRendering(b *testing.B) {
+func TestEffectsConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		effects EffectsConfig
+		wantErr bool
+	}{
+		{"disabled", EffectsConfig{}, false},
+		{"all effects", EffectsConfig{Displacement: 4, Blur: 0.5, ApplyTo: EffectsTargetBoth, Gradient: true, Pattern: PatternGrid}, false},
+		{"displacement too large", EffectsConfig{Displacement: 25}, true},
+		{"negative blur", EffectsConfig{Blur: -1}, true},
+		{"unknown target", EffectsConfig{ApplyTo: "noise"}, true},
+		{"unknown pattern", EffectsConfig{Pattern: "checkers"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Effects = tt.effects
+			err := config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEffectsRendering(t *testing.T) {
 	config := DefaultConfig()
+	config.Effects = EffectsConfig{Displacement: 4, Blur: 0.5, Gradient: true, Pattern: PatternStripes}
+
 	renderer := NewSVGRenderer(config)
-	expr := &MathExpression{
-		Operand1: 3,
-		Operand2: 5,
-		Operator: "+",
-		Answer:   8,
-		Question: "3 + 5 = ?",
+	expr := &MathExpression{Operand1: 3, Operand2: 5, Operator: "+", Answer: 8, Question: "3 + 5 = ?"}
+
+	svgData, err := renderer.RenderMathExpression(expr, config)
+	if err != nil {
+		t.Fatalf("Failed to render SVG with effects: %v", err)
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := renderer.RenderMathExpression(expr, config)
+	for _, want := range []string{"<defs>", "<feTurbulence", "<feDisplacementMap", "<feGaussianBlur", "<linearGradient", "<pattern", "<g filter=\"url(#"} {
+		if !strings.Contains(svgData, want) {
+			t.Errorf("SVG with effects does not contain %s", want)
+		}
+	}
+
+	// The default target filters text only, so the background group has no filter
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "3 + 5 = ", config); err != nil {
+		t.Fatalf("addTextToSVG failed: %v", err)
+	}
+	renderer.addEffectsToSVG(svg, config)
+
+	if len(svg.Groups) != 2 {
+		t.Fatalf("Expected background and text groups, got %d", len(svg.Groups))
+	}
+	if svg.Groups[0].Filter != "" {
+		t.Error("Background group should not be filtered when targeting text")
+	}
+	if svg.Groups[1].Filter == "" || len(svg.Groups[1].Texts) == 0 || len(svg.Texts) != 0 {
+		t.Error("Text elements should be moved into the filtered group")
+	}
+	if !strings.HasPrefix(svg.Groups[0].Rects[0].Fill, "url(#") {
+		t.Error("Background should be filled with the gradient")
+	}
+}
+
+func TestNoiseIntersectValidation(t *testing.T) {
+	config := DefaultConfig()
+
+	for _, value := range []float64{0, 0.5, 1} {
+		config.NoiseIntersect = value
+		if err := config.Validate(); err != nil {
+			t.Errorf("NoiseIntersect %v should be valid: %v", value, err)
+		}
+	}
+
+	for _, value := range []float64{-0.1, 1.5} {
+		config.NoiseIntersect = value
+		if err := config.Validate(); err == nil {
+			t.Errorf("NoiseIntersect %v should be invalid", value)
+		}
+	}
+}
+
+func TestNoiseTargetsText(t *testing.T) {
+	config := DefaultConfig()
+	config.Noise = 2
+	config.NoiseIntersect = 1
+
+	renderer := NewSVGRenderer(config)
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "3 + 5 = ", config); err != nil {
+		t.Fatalf("addTextToSVG failed: %v", err)
+	}
+	if svg.textBounds.Empty() {
+		t.Fatal("Expected text bounds to be recorded")
+	}
+
+	renderer.addNoiseToSVG(svg, config)
+
+	// All four lines are forced through the text: two strike-throughs and two occlusions
+	if len(svg.Paths) != 4 {
+		t.Fatalf("Expected 4 targeted noise paths, got %d", len(svg.Paths))
+	}
+
+	textColors := make(map[string]bool)
+	for _, color := range renderer.colorMgr.TextColors() {
+		textColors[color] = true
+	}
+
+	bounds := svg.textBounds
+	for i, path := range svg.Paths {
+		if !textColors[path.Stroke] {
+			t.Errorf("Path %d stroke %s is not a text color", i, path.Stroke)
+		}
+
+		var x1, y1, x2, y2 float64
+		if strings.Contains(path.D, "Q") {
+			var cx, cy float64
+			if _, err := fmt.Sscanf(path.D, "M%f,%f Q%f,%f %f,%f", &x1, &y1, &cx, &cy, &x2, &y2); err != nil {
+				t.Fatalf("Unexpected strike-through path %q: %v", path.D, err)
+			}
+			if x1 >= bounds.MinX || x2 <= bounds.MaxX {
+				t.Errorf("Strike-through %q does not span the text", path.D)
+			}
+			for _, y := range []float64{y1, cy, y2} {
+				if y < bounds.MinY-0.01 || y > bounds.MaxY+0.01 {
+					t.Errorf("Strike-through %q leaves the text band", path.D)
+				}
+			}
+		} else {
+			if _, err := fmt.Sscanf(path.D, "M%f,%f L%f,%f", &x1, &y1, &x2, &y2); err != nil {
+				t.Fatalf("Unexpected occlusion path %q: %v", path.D, err)
+			}
+			cx, cy := (x1+x2)/2, (y1+y2)/2
+			if cx < bounds.MinX-0.01 || cx > bounds.MaxX+0.01 || cy < bounds.MinY-0.01 || cy > bounds.MaxY+0.01 {
+				t.Errorf("Occlusion %q is not centered on the text", path.D)
+			}
+		}
+	}
+}
+
+func TestContrastRatio(t *testing.T) {
+	if ratio := ContrastRatio("#000000", "#ffffff"); ratio < 20.9 || ratio > 21.1 {
+		t.Errorf("Expected black on white contrast 21, got %.2f", ratio)
+	}
+	if ratio := ContrastRatio("#777", "#777777"); ratio != 1 {
+		t.Errorf("Expected identical colors to have contrast 1, got %.2f", ratio)
+	}
+	if blended := blendColors("#000000", "#ffffff", 0.5); blended != "#808080" {
+		t.Errorf("Expected 50%% blend of black and white to be #808080, got %s", blended)
+	}
+}
+
+func TestAccessiblePalettes(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		min    float64
+	}{
+		{"default", func(c *Config) {}, 3},
+		{"grayscale", func(c *Config) { c.Color = false }, 3},
+		{"colorblind", func(c *Config) { c.Palette = PaletteColorBlind }, 3},
+		{"dark background", func(c *Config) { c.Background = "#1e1e1e" }, 3},
+		{"strict", func(c *Config) { c.MinContrast = 10 }, 10},
+		{"high contrast", func(c *Config) { c.HighContrast = true }, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(config)
+			if err := config.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+
+			colorMgr := NewColorManager(config)
+			if len(colorMgr.TextColors()) == 0 {
+				t.Fatal("Expected at least one text color")
+			}
+			for _, color := range colorMgr.TextColors() {
+				if ratio := ContrastRatio(color, config.Background); ratio < tt.min {
+					t.Errorf("Text color %s has contrast %.2f, want >= %.1f", color, ratio, tt.min)
+				}
+			}
+		})
+	}
+
+	// Disabling the minimum keeps the full palette
+	config := DefaultConfig()
+	config.MinContrast = 0
+	if got := len(NewColorManager(config).TextColors()); got != 12 {
+		t.Errorf("Expected unfiltered palette of 12 colors, got %d", got)
+	}
+
+	config = DefaultConfig()
+	config.MinContrast = 0.5
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for MinContrast below 1")
+	}
+	config = DefaultConfig()
+	config.Palette = "rainbow"
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for unknown palette")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#ABC", "#aabbcc"},
+		{"#1e1e1e", "#1e1e1e"},
+		{"rgb(255, 128, 0)", "#ff8000"},
+		{"rgb(100% 0% 50%)", "#ff0080"},
+		{"hsl(120, 100%, 25%)", "#008000"},
+		{"hsl(0deg 0% 100%)", "#ffffff"},
+		{"RebeccaPurple", "#663399"},
+		{" white ", "#ffffff"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseColor(tt.input)
 		if err != nil {
-			b.Fatalf("Failed to render SVG: %v", err)
+			t.Errorf("ParseColor(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseColor(%q) = %s, want %s", tt.input, got, tt.expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "#12", "#ggg", "rgb(256, 0, 0)", "rgb(1, 2)", "hsl(10, 50, 50)", "notacolor"} {
+		if _, err := ParseColor(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestThemes(t *testing.T) {
+	config := DefaultConfig()
+	if err := config.ApplyTheme(ThemeDark); err != nil {
+		t.Fatalf("ApplyTheme failed: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Dark theme config is invalid: %v", err)
+	}
+
+	colorMgr := NewColorManager(config)
+	for _, color := range colorMgr.TextColors() {
+		if ContrastRatio(color, config.Background) < config.MinContrast {
+			t.Errorf("Dark theme text color %s is unreadable on %s", color, config.Background)
+		}
+	}
+
+	// Custom palettes replace the built-in ones and are normalized
+	config = DefaultConfig()
+	config.TextColors = []string{"navy", "rgb(128, 0, 0)"}
+	config.NoiseColors = []string{"hsl(0, 0%, 80%)"}
+	colorMgr = NewColorManager(config)
+	if got := colorMgr.TextColors(); len(got) != 2 || got[0] != "#000080" || got[1] != "#800000" {
+		t.Errorf("Unexpected custom text colors %v", got)
+	}
+	if got := colorMgr.GetRandomNoiseColor(); got != "#cccccc" {
+		t.Errorf("Expected custom noise color #cccccc, got %s", got)
+	}
+
+	config.TextColors = []string{"#zzzzzz"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for invalid text color")
+	}
+
+	// The registry is global: restore it for the tests that follow
+	themesMutex.RLock()
+	saved := maps.Clone(themes)
+	themesMutex.RUnlock()
+	t.Cleanup(func() {
+		themesMutex.Lock()
+		themes = saved
+		themesMutex.Unlock()
+	})
+
+	builtin, err := GetTheme(ThemeBrand)
+	if err != nil {
+		t.Fatalf("Expected a built-in brand theme: %v", err)
+	}
+	brand, err := NewBrandTheme(ThemeBrand, "#cc3300")
+	if err != nil {
+		t.Fatalf("NewBrandTheme failed: %v", err)
+	}
+	if brand.Background == builtin.Background {
+		t.Fatal("Expected brand colors to follow the brand color")
+	}
+	if err := RegisterTheme(*brand); err != nil {
+		t.Fatalf("RegisterTheme failed: %v", err)
+	}
+	generator := NewCaptchaGenerator(DefaultConfig())
+	result, err := generator.CreateMathExprWithTheme(ThemeBrand)
+	if err != nil {
+		t.Fatalf("CreateMathExprWithTheme failed: %v", err)
+	}
+	if !strings.Contains(result.Data, brand.Background) {
+		t.Errorf("Expected brand background %s in SVG", brand.Background)
+	}
+	if generator.GetConfig().Background != DefaultConfig().Background {
+		t.Error("Themed generation must not modify the generator configuration")
+	}
+	if _, err := generator.CreateMathExprWithTheme("missing"); err == nil {
+		t.Error("Expected error for unknown theme")
+	}
+
+	// An unknown CAPTCHA_THEME keeps the default colors and is reported by LoadConfigFromEnvE
+	t.Setenv("CAPTCHA_THEME", "bogus")
+	if config := LoadConfigFromEnv(); config.Background != DefaultConfig().Background {
+		t.Errorf("Expected default background for an unknown theme, got %s", config.Background)
+	}
+	if config, err := LoadConfigFromEnvE(); !errors.Is(err, ErrorInvalidConfig) || config.Background != DefaultConfig().Background {
+		t.Errorf("Expected an invalid config error and default colors, got %v %s", err, config.Background)
+	}
+
+	if ThemeForColorScheme(`"dark"`) != ThemeDark || ThemeForColorScheme("light") != ThemeLight || ThemeForColorScheme("") != ThemeLight {
+		t.Error("ThemeForColorScheme returned an unexpected theme")
+	}
+}
+
+func TestRGBA(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected RGBA
+	}{
+		{"#336699", RGBA{0x33, 0x66, 0x99, 1}},
+		{"#33669980", RGBA{0x33, 0x66, 0x99, 128.0 / 255}},
+		{"#0008", RGBA{0, 0, 0, 136.0 / 255}},
+		{"rgba(10, 20, 30, 0.5)", RGBA{10, 20, 30, 0.5}},
+		{"rgb(10 20 30 / 25%)", RGBA{10, 20, 30, 0.25}},
+		{"hsla(0, 100%, 50%, 0.3)", RGBA{255, 0, 0, 0.3}},
+		{"transparent", RGBA{}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRGBA(tt.input)
+		if err != nil {
+			t.Errorf("ParseRGBA(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseRGBA(%q) = %+v, want %+v", tt.input, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseRGBA("rgba(0, 0, 0, 1.5)"); err == nil {
+		t.Error("Expected error for alpha above 1")
+	}
+
+	half := RGBA{R: 0, G: 0, B: 0, A: 0.5}
+	if got := half.String(); got != "rgba(0, 0, 0, 0.5)" {
+		t.Errorf("Unexpected translucent string %s", got)
+	}
+	if got := half.Over(RGBA{R: 255, G: 255, B: 255, A: 1}).Hex(); got != "#808080" {
+		t.Errorf("Expected 50%% black over white to be #808080, got %s", got)
+	}
+
+	// The deprecated helper must now return a color that is valid inside a fill attribute
+	colorMgr := NewColorManager(DefaultConfig())
+	if color := colorMgr.GetRandomColorWithOpacity(0.5); strings.Contains(color, ";") {
+		t.Errorf("GetRandomColorWithOpacity returned invalid color %q", color)
+	} else if c, err := ParseRGBA(color); err != nil || c.A != 0.5 {
+		t.Errorf("Expected rgba color with alpha 0.5, got %q", color)
+	}
+}
+
+func TestNoiseOpacity(t *testing.T) {
+	config := DefaultConfig()
+	config.Noise = 3
+	config.LineOpacity = OpacityRange{Min: 0.2, Max: 0.6}
+	config.DotOpacity = OpacityRange{Min: 0.5, Max: 0.5}
+	config.TextColors = []string{"rgba(0, 0, 0, 0.9)"}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	renderer := NewSVGRenderer(config)
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "1 + 2 = ", config); err != nil {
+		t.Fatalf("addTextToSVG failed: %v", err)
+	}
+	renderer.addNoiseToSVG(svg, config)
+
+	for _, text := range svg.Texts {
+		if text.Fill != "#000000" || text.FillOpacity != "0.9" {
+			t.Errorf("Expected translucent black text, got fill %s opacity %q", text.Fill, text.FillOpacity)
+		}
+	}
+	for _, path := range svg.Paths {
+		opacity, err := strconv.ParseFloat(path.StrokeOpacity, 64)
+		if err != nil || opacity < 0.2 || opacity > 0.6 {
+			t.Errorf("Noise line stroke-opacity %q outside configured range", path.StrokeOpacity)
+		}
+		if strings.Contains(path.Stroke, ";") {
+			t.Errorf("Invalid stroke color %q", path.Stroke)
+		}
+	}
+	for _, circle := range svg.Circles {
+		if circle.FillOpacity != "0.5" {
+			t.Errorf("Expected dot fill-opacity 0.5, got %q", circle.FillOpacity)
 		}
 	}
+
+	// Opaque defaults emit no opacity attributes
+	result, err := NewCaptchaGenerator(DefaultConfig()).CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr failed: %v", err)
+	}
+	if strings.Contains(result.Data, "opacity") {
+		t.Error("Opaque captcha should not contain opacity attributes")
+	}
+
+	config = DefaultConfig()
+	config.LineOpacity = OpacityRange{Min: 0.8, Max: 0.4}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for inverted opacity range")
+	}
+}
+
+func TestDecoys(t *testing.T) {
+	for _, distorted := range []bool{false, true} {
+		config := DefaultConfig()
+		config.Noise = 0
+		config.Decoys = 12
+		if distorted {
+			config.Distortion = DistortionConfig{Skew: 10}
+		}
+
+		renderer := NewSVGRenderer(config)
+		svg := renderer.createSVGContainer(config)
+		if err := renderer.addDecoysToSVG(svg, config); err != nil {
+			t.Fatalf("addDecoysToSVG failed: %v", err)
+		}
+		if len(svg.Groups) != 1 {
+			t.Fatalf("Expected one decoy group, got %d", len(svg.Groups))
+		}
+
+		group := svg.Groups[0]
+		var fills []string
+		for _, text := range group.Texts {
+			fills = append(fills, text.Fill)
+			if text.FontSize >= config.FontSize {
+				t.Errorf("Decoy font size %d should be smaller than %d", text.FontSize, config.FontSize)
+			}
+		}
+		for _, path := range group.Paths {
+			fills = append(fills, path.Fill)
+		}
+		if len(fills) != config.Decoys {
+			t.Errorf("Expected %d decoys (distorted=%v), got %d", config.Decoys, distorted, len(fills))
+		}
+
+		// Every decoy must be fainter than the faintest real text color
+		minTextContrast := 21.0
+		for _, color := range renderer.colorMgr.TextColors() {
+			minTextContrast = min(minTextContrast, ContrastRatio(color, config.Background))
+		}
+		for _, fill := range fills {
+			if ContrastRatio(fill, config.Background) >= minTextContrast {
+				t.Errorf("Decoy color %s is not separated from the text colors", fill)
+			}
+		}
+	}
+
+	config := DefaultConfig()
+	config.Decoys = 31
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for too many decoys")
+	}
+}
+
+func TestRasterize(t *testing.T) {
+	config := DefaultConfig()
+	config.Noise = 0
+	generator := NewCaptchaGenerator(config)
+	result, err := generator.CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr failed: %v", err)
+	}
+
+	img, err := Rasterize(result.Data, 2)
+	if err != nil {
+		t.Fatalf("Rasterize failed: %v", err)
+	}
+	if img.Bounds().Dx() != config.Width*2 || img.Bounds().Dy() != config.Height*2 {
+		t.Errorf("Expected %dx%d image, got %v", config.Width*2, config.Height*2, img.Bounds())
+	}
+
+	// The corner shows the background and the text leaves ink somewhere else
+	if c := img.RGBAAt(0, 0); c.R != 0xf0 || c.G != 0xf0 || c.B != 0xf0 {
+		t.Errorf("Expected background color at origin, got %v", c)
+	}
+	ink := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i] < 0xc0 || img.Pix[i+1] < 0xc0 || img.Pix[i+2] < 0xc0 {
+			ink++
+		}
+	}
+	if ink == 0 {
+		t.Error("Expected rasterized text")
+	}
+
+	if _, err := Rasterize("<svg", 1); err == nil {
+		t.Error("Expected error for malformed SVG")
+	}
+	for _, scale := range []float64{0, -1, math.NaN(), math.Inf(1), 1e9, 100} {
+		if _, err := Rasterize(result.Data, scale); err == nil {
+			t.Errorf("Expected error for scale %v", scale)
+		}
+	}
+}
+
+func TestMinifiedSVG(t *testing.T) {
+	// Relative path data describes the same points as the original
+	d := "M10.5 20.25 L30 40 Q 35 45 50 50 T 70 40 C 75 35 80 30 90 30 H 60 V 10 Z M 5 5 l 1.004 -0.5 z"
+	minified := string(appendRelativePath(nil, d, 2))
+	if minified != "M10.5 20.25l19.5 19.75q5 5 20 10t20-10c5-5 10-10 20-10h-30v-20zm-5.5-15.25l1-.5z" {
+		t.Errorf("Unexpected minified path %q", minified)
+	}
+	original, _ := parsePathData(d)
+	relative, _ := parsePathData(minified)
+	if len(original) != len(relative) {
+		t.Fatalf("Expected %d subpaths, got %d", len(original), len(relative))
+	}
+	for i := range original {
+		for j := range original[i] {
+			dx := original[i][j].X - relative[i][j].X
+			dy := original[i][j].Y - relative[i][j].Y
+			if dx*dx+dy*dy > 0.0001 {
+				t.Errorf("Subpath %d point %d moved from %v to %v", i, j, original[i][j], relative[i][j])
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		value     float64
+		precision int
+		expected  string
+	}{
+		{0.5, 2, ".5"}, {-0.25, 2, "-.25"}, {12.345, 2, "12.35"}, {12.345, 0, "12"}, {-0.001, 2, "0"}, {3, 2, "3"},
+	} {
+		if got := string(appendNumber(nil, tc.value, tc.precision)); got != tc.expected {
+			t.Errorf("appendNumber(%v, %d) = %q, expected %q", tc.value, tc.precision, got, tc.expected)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Noise = 4
+	config.Distortion.WaveAmplitude = 2
+	config.Effects.Pattern = PatternGrid
+	indented, err := NewCaptchaGenerator(config).CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr failed: %v", err)
+	}
+
+	config.Minify = true
+	result, err := NewCaptchaGenerator(config).CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr with Minify failed: %v", err)
+	}
+	if strings.Contains(result.Data, "\n") || strings.HasPrefix(result.Data, "<?xml") {
+		t.Error("Minified SVG should have no indentation or XML declaration")
+	}
+	if len(result.Data) >= len(indented.Data) {
+		t.Errorf("Expected minified SVG smaller than %d bytes, got %d", len(indented.Data), len(result.Data))
+	}
+	if _, err := Rasterize(result.Data, 1); err != nil {
+		t.Errorf("Minified SVG does not parse: %v", err)
+	}
+
+	// A budget forces minification with fewer decimals and fails when it cannot be met
+	renderer := NewSVGRenderer(config)
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "3 + 5 = ", config); err != nil {
+		t.Fatalf("addTextToSVG failed: %v", err)
+	}
+	renderer.addNoiseToSVG(svg, config)
+
+	var buf bytes.Buffer
+	writeMinified(&buf, svg, 1)
+	config.Minify = false
+	config.MaxBytes = buf.Len()
+	buf.Reset()
+	if err := encodeSVG(&buf, svg, config); err != nil {
+		t.Errorf("Expected output within %d bytes: %v", config.MaxBytes, err)
+	} else if buf.Len() > config.MaxBytes {
+		t.Errorf("Output of %d bytes exceeds budget %d", buf.Len(), config.MaxBytes)
+	}
+
+	config.MaxBytes = 100
+	buf.Reset()
+	err = encodeSVG(&buf, svg, config)
+	if captchaErr, ok := err.(*CaptchaError); !ok || captchaErr.Type != ErrSizeBudgetExceeded {
+		t.Errorf("Expected %s error, got %v", ErrSizeBudgetExceeded, err)
+	}
+
+	config.MaxBytes = -1
+	if err := config.Validate(); err == nil {
+		t.Error("Expected error for negative MaxBytes")
+	}
+}
+
+func TestDataURIs(t *testing.T) {
+	config := DefaultConfig()
+	config.Noise = 2
+	result, err := NewCaptchaGenerator(config).CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr failed: %v", err)
+	}
+
+	base64URI := result.Base64DataURI()
+	payload, ok := strings.CutPrefix(base64URI, "data:image/svg+xml;base64,")
+	if !ok {
+		t.Fatalf("Unexpected base64 data URI prefix: %.40s", base64URI)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(payload); err != nil || string(decoded) != result.Data {
+		t.Errorf("Base64 data URI does not decode to the SVG: %v", err)
+	}
+
+	utf8URI := result.UTF8DataURI()
+	payload, ok = strings.CutPrefix(utf8URI, "data:image/svg+xml;charset=utf-8,")
+	if !ok {
+		t.Fatalf("Unexpected utf8 data URI prefix: %.40s", utf8URI)
+	}
+	if strings.ContainsAny(payload, "<>\"#\n ()") {
+		t.Error("UTF-8 data URI contains unescaped characters")
+	}
+	if len(utf8URI) >= len(base64URI) {
+		t.Errorf("Expected UTF-8 data URI (%d bytes) smaller than base64 (%d bytes)", len(utf8URI), len(base64URI))
+	}
+	unescaped, err := url.PathUnescape(payload)
+	if err != nil {
+		t.Fatalf("UTF-8 data URI does not unescape: %v", err)
+	}
+	if _, err := Rasterize(unescaped, 1); err != nil {
+		t.Errorf("UTF-8 data URI does not contain a valid SVG: %v", err)
+	}
+
+	pngURI, err := result.PNGDataURI(2)
+	if err != nil {
+		t.Fatalf("PNGDataURI failed: %v", err)
+	}
+	payload, ok = strings.CutPrefix(pngURI, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("Unexpected PNG data URI prefix: %.40s", pngURI)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(payload)
+	img, err := png.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatalf("PNG data URI does not decode: %v", err)
+	}
+	if img.Bounds().Dx() != config.Width*2 {
+		t.Errorf("Expected PNG width %d, got %d", config.Width*2, img.Bounds().Dx())
+	}
+
+	data, err := result.MarshalJSONWithOptions(JSONOptions{Encoding: EncodingUTF8, OmitAnswer: true})
+	if err != nil {
+		t.Fatalf("MarshalJSONWithOptions failed: %v", err)
+	}
+	var decodedJSON map[string]string
+	if err := json.Unmarshal(data, &decodedJSON); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if decodedJSON["data"] != utf8URI || decodedJSON["question"] != result.Question {
+		t.Errorf("Unexpected JSON fields: %v", decodedJSON)
+	}
+	if _, ok := decodedJSON["text"]; ok {
+		t.Error("Expected answer to be omitted")
+	}
+
+	data, _ = result.MarshalJSONWithOptions(JSONOptions{})
+	if !strings.Contains(string(data), `"text":"`+result.Text+`"`) {
+		t.Errorf("Expected answer in default JSON, got %s", data)
+	}
+	if _, err := result.Encode("gif", 0); err == nil {
+		t.Error("Expected error for unknown encoding")
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	generator := NewCaptchaGenerator(DefaultConfig())
+
+	var buf bytes.Buffer
+	result, err := generator.WriteMathExpr(&buf)
+	if err != nil {
+		t.Fatalf("WriteMathExpr failed: %v", err)
+	}
+	if result.Text == "" || result.Question == "" || result.Data != "" {
+		t.Errorf("Expected answer and question without data, got %+v", result)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") || !strings.HasSuffix(buf.String(), "</svg>") {
+		t.Error("Expected a complete SVG document")
+	}
+	if _, err := Rasterize(buf.String(), 1); err != nil {
+		t.Errorf("Written SVG does not parse: %v", err)
+	}
+
+	created, err := generator.CreateMathExpr()
+	if err != nil {
+		t.Fatalf("CreateMathExpr failed: %v", err)
+	}
+	buf.Reset()
+	n, err := created.WriteTo(&buf)
+	if err != nil || n != int64(len(created.Data)) || buf.String() != created.Data {
+		t.Errorf("WriteTo wrote %d bytes with error %v", n, err)
+	}
+
+	// Nothing is written when the document does not fit the budget
+	config := DefaultConfig()
+	config.MaxBytes = 100
+	renderer := NewSVGRenderer(config)
+	expr := &MathExpression{Operand1: 3, Operand2: 5, Operator: "+", Answer: 8, Question: "3 + 5 = ?"}
+	buf.Reset()
+	if _, err := renderer.WriteMathExpression(&buf, expr, config); err == nil {
+		t.Error("Expected size budget error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output on error, got %d bytes", buf.Len())
+	}
+}
+
+func TestIndentedWriterMatchesEncodingXML(t *testing.T) {
+	configs := []func(c *Config){
+		func(c *Config) {},
+		func(c *Config) {
+			c.Effects = EffectsConfig{Displacement: 4, Blur: 0.5, ApplyTo: EffectsTargetBoth, Gradient: true, Pattern: PatternStripes}
+			c.Decoys = 5
+			c.LineOpacity = OpacityRange{Min: 0.2, Max: 0.5}
+		},
+		func(c *Config) {
+			c.Distortion.WaveAmplitude = 2
+			c.Effects.Pattern = PatternDots
+			c.Effects.Blur = 1
+			c.TextColors = []string{"rgba(0, 0, 0, 0.5)"}
+		},
+		func(c *Config) {
+			c.Effects.Pattern = PatternGrid
+			c.Effects.Displacement = 3
+			c.Effects.ApplyTo = EffectsTargetBackground
+		},
+	}
+
+	for i, configure := range configs {
+		config := DefaultConfig()
+		config.Noise = 5
+		configure(config)
+
+		renderer := NewSVGRenderer(config)
+		svg, err := renderer.buildMathExpression(t.Context(), &MathExpression{Question: "3 + 5 = ?"}, config)
+		if err != nil {
+			t.Fatalf("Config %d: buildMathExpression failed: %v", i, err)
+		}
+		expected, err := xml.MarshalIndent(svg, "", "  ")
+		if err != nil {
+			t.Fatalf("Config %d: MarshalIndent failed: %v", i, err)
+		}
+
+		var buf bytes.Buffer
+		writeIndented(&buf, svg)
+		if buf.String() != string(expected) {
+			t.Errorf("Config %d: indented writer output differs from encoding/xml", i)
+		}
+	}
+}
+
+func TestRenderAllocations(t *testing.T) {
+	generator := NewCaptchaGenerator(DefaultConfig())
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := generator.CreateMathExpr(); err != nil {
+			t.Fatalf("CreateMathExpr failed: %v", err)
+		}
+	})
+	if allocs > 40 {
+		t.Errorf("Expected at most 40 allocations per captcha, got %.0f", allocs)
+	}
+}
+
+// chiSquareCritical approximates the chi-square value exceeded with probability 1e-4 for the
+// given degrees of freedom (Wilson-Hilferty), so a uniform source fails 1 run in 10,000
+func chiSquareCritical(df int) float64 {
+	const z = 3.719
+	k := float64(df)
+	h := 2 / (9 * k)
+	return k * math.Pow(1-h+z*math.Sqrt(h), 3)
+}
+
+// chiSquare returns the statistic of observed bucket counts against a uniform distribution
+func chiSquare(counts []int, samples int) float64 {
+	expected := float64(samples) / float64(len(counts))
+	stat := 0.0
+	for _, count := range counts {
+		diff := float64(count) - expected
+		stat += diff * diff / expected
+	}
+	return stat
+}
+
+func TestSecureRandomIntUniform(t *testing.T) {
+	for _, max := range []int{2, 6, 10, 1000, math.MaxInt / 3 * 2} {
+		const buckets = 10
+		const samples = 50000
+		counts := make([]int, buckets)
+		for i := 0; i < samples; i++ {
+			v, err := secureRandomInt(max)
+			if err != nil {
+				t.Fatalf("secureRandomInt(%d) failed: %v", max, err)
+			}
+			if v < 0 || v >= max {
+				t.Fatalf("secureRandomInt(%d) returned %d", max, v)
+			}
+			// Small ranges count each value, large ranges are split into equal buckets
+			if max <= buckets {
+				counts[v]++
+			} else {
+				counts[int(float64(v)/float64(max)*buckets)]++
+			}
+		}
+		if max < buckets {
+			counts = counts[:max]
+		}
+
+		stat := chiSquare(counts, samples)
+		if critical := chiSquareCritical(len(counts) - 1); stat > critical {
+			t.Errorf("secureRandomInt(%d) not uniform: chi-square %.1f > %.1f", max, stat, critical)
+		}
+	}
+
+	if _, err := secureRandomInt(0); err == nil {
+		t.Error("Expected error for max 0")
+	}
+}
+
+func TestSecureRandomFloatUniform(t *testing.T) {
+	const buckets = 100
+	const samples = 100000
+	counts := make([]int, buckets)
+	onGrid := 0
+	for i := 0; i < samples; i++ {
+		u, err := secureRandomFloat64()
+		if err != nil {
+			t.Fatalf("secureRandomFloat64 failed: %v", err)
+		}
+		if u < 0 || u >= 1 {
+			t.Fatalf("secureRandomFloat64 returned %v outside [0, 1)", u)
+		}
+		counts[int(u*buckets)]++
+
+		// Values must not fall on a coarse grid such as multiples of 1e-4
+		if scaled := u * 10000; scaled == math.Trunc(scaled) {
+			onGrid++
+		}
+	}
+
+	stat := chiSquare(counts, samples)
+	if critical := chiSquareCritical(buckets - 1); stat > critical {
+		t.Errorf("secureRandomFloat64 not uniform: chi-square %.1f > %.1f", stat, critical)
+	}
+	if onGrid > samples/1000 {
+		t.Errorf("%d of %d floats fall on a 1e-4 grid", onGrid, samples)
+	}
+
+	// Ranges stay half-open, including degenerate and wide ones
+	for _, r := range [][2]float64{{-15, 15}, {0, 1e-300}, {-math.MaxFloat64 / 2, math.MaxFloat64 / 2}} {
+		for i := 0; i < 1000; i++ {
+			v, err := secureRandomFloat(r[0], r[1])
+			if err != nil || v < r[0] || v >= r[1] {
+				t.Fatalf("secureRandomFloat(%g, %g) returned %v, %v", r[0], r[1], v, err)
+			}
+		}
+	}
+	if v, _ := secureRandomFloat(5, 5); v != 5 {
+		t.Errorf("Expected min for empty range, got %v", v)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.Set("a", StoreEntry{Answer: "8", ExpiresAt: now.Add(time.Minute)})
+	store.Set("b", StoreEntry{Answer: "3", ExpiresAt: now.Add(-time.Minute)})
+
+	if store.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", store.Len())
+	}
+	if removed := store.Cleanup(now); removed != 1 || store.Len() != 1 {
+		t.Errorf("Expected cleanup to remove 1 expired entry, removed %d, left %d", removed, store.Len())
+	}
+
+	entry, ok, err := store.Take("a")
+	if err != nil || !ok || entry.Answer != "8" {
+		t.Errorf("Expected to take entry a, got %+v %v %v", entry, ok, err)
+	}
+	if _, ok, _ := store.Take("a"); ok {
+		t.Error("Expected entry to be removed after Take")
+	}
+}
+
+func TestService(t *testing.T) {
+	registry := NewMetricsRegistry()
+	service := NewService(NewCaptchaGenerator(DefaultConfig()), nil)
+	service.SetMetrics(registry)
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if len(challenge.ID) != 32 || challenge.Result == nil || challenge.Result.Data == "" {
+		t.Fatalf("Unexpected challenge %+v", challenge)
+	}
+	var fields map[string]any
+	data, _ := json.Marshal(challenge)
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) != 2 || fields["id"] != challenge.ID {
+		t.Errorf("Challenge JSON must only contain id and expiresAt: %s", data)
+	}
+
+	if result, err := service.Verify(challenge.ID, " "+challenge.Result.Text+" "); err != nil || result != VerifySuccess {
+		t.Errorf("Expected success, got %s %v", result, err)
+	}
+	if result, _ := service.Verify(challenge.ID, challenge.Result.Text); result != VerifyNotFound {
+		t.Errorf("Expected a captcha to verify only once, got %s", result)
+	}
+
+	challenge, _ = service.Issue()
+	if result, _ := service.Verify(challenge.ID, "wrong"); result != VerifyFailure {
+		t.Errorf("Expected failure, got %s", result)
+	}
+
+	service.Store().Set("old", StoreEntry{Answer: "1", ExpiresAt: time.Now().Add(-time.Second)})
+	if result, _ := service.Verify("old", "1"); result != VerifyExpired {
+		t.Errorf("Expected expired, got %s", result)
+	}
+
+	if err := service.SetTTL(0); err == nil {
+		t.Error("Expected error for zero TTL")
+	}
+
+	service.Issue()
+	text := string(registry.WriteText())
+	for _, line := range []string{
+		`captcha_verifications_total{result="success"} 1`,
+		`captcha_verifications_total{result="failure"} 1`,
+		`captcha_verifications_total{result="expired"} 1`,
+		`captcha_verifications_total{result="not_found"} 1`,
+		`captcha_generation_duration_seconds_count 3`,
+		`captcha_generation_duration_seconds_bucket{le="+Inf"} 3`,
+		`captcha_store_size 1`,
+		"# TYPE captcha_generation_duration_seconds histogram",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, text)
+		}
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.ObserveGeneration(2*time.Millisecond, "")
+	registry.ObserveGeneration(20*time.Millisecond, ErrSVGGeneration)
+	registry.ObserveGeneration(time.Millisecond, `bad"type`)
+
+	text := string(registry.WriteText())
+	for _, line := range []string{
+		`captcha_generation_duration_seconds_bucket{le="0.001"} 1`,
+		`captcha_generation_duration_seconds_bucket{le="0.0025"} 2`,
+		`captcha_generation_duration_seconds_bucket{le="0.025"} 3`,
+		`captcha_generation_errors_total{type="SVG_GENERATION_FAILED"} 1`,
+		`captcha_generation_errors_total{type="bad\"type"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, text)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if recorder.Body.String() != text {
+		t.Error("Expected ServeHTTP to serve the text exposition")
+	}
+}
+
+func TestStructuredLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	config := DefaultConfig()
+	generator := NewCaptchaGenerator(config, WithLogger(logger))
+
+	if _, err := generator.CreateMathExpr(); err != nil {
+		t.Fatalf("Failed to generate captcha: %v", err)
+	}
+	invalid := DefaultConfig()
+	invalid.Width = -1
+	if _, err := generator.CreateMathExprWithOptions(invalid); err == nil {
+		t.Fatal("Expected invalid options to fail")
+	}
+
+	var records []map[string]any
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode log record: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 log records, got %d", len(records))
+	}
+
+	if records[0]["msg"] != "captcha generated" || records[0]["level"] != "DEBUG" {
+		t.Errorf("Unexpected success record: %v", records[0])
+	}
+	if records[0]["config_hash"] != config.Hash() {
+		t.Errorf("Expected config_hash %s, got %v", config.Hash(), records[0]["config_hash"])
+	}
+	if _, ok := records[0]["duration"]; !ok {
+		t.Error("Expected duration field")
+	}
+
+	if records[1]["level"] != "WARN" || records[1]["error_type"] != ErrInvalidConfig {
+		t.Errorf("Unexpected failure record: %v", records[1])
+	}
+	if records[1]["config_hash"] != invalid.Hash() {
+		t.Errorf("Expected config_hash of the invalid options, got %v", records[1]["config_hash"])
+	}
+
+	// An invalid constructor config is reported as a warning
+	logs.Reset()
+	NewCaptchaGenerator(invalid, WithLogger(logger))
+	if !strings.Contains(logs.String(), `"error_type":"INVALID_CONFIG"`) {
+		t.Errorf("Expected invalid config warning, got %q", logs.String())
+	}
+
+	if config.Hash() != DefaultConfig().Hash() || config.Hash() == invalid.Hash() {
+		t.Error("Expected Hash to depend only on the configuration values")
+	}
+}
+
+func TestDefaultLoggerIsSilent(t *testing.T) {
+	generator := NewCaptchaGenerator(nil)
+	if generator.logger.Enabled(t.Context(), slog.LevelError) {
+		t.Error("Expected the default logger to discard records")
+	}
+
+	generator = NewCaptchaGenerator(nil, WithLogger(nil))
+	if generator.logger.Enabled(t.Context(), slog.LevelError) {
+		t.Error("Expected WithLogger(nil) to discard records")
+	}
+}
+
+// panickingRenderer is a Renderer that panics, to exercise panic recovery
+type panickingRenderer struct{}
+
+func (panickingRenderer) RenderMathExpression(expr *MathExpression, config *Config) (string, error) {
+	panic("renderer exploded")
+}
+
+func (panickingRenderer) WriteMathExpression(w io.Writer, expr *MathExpression, config *Config) (int64, error) {
+	panic("renderer exploded")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	metrics := NewMetricsRegistry()
+	generator := NewCaptchaGenerator(DefaultConfig(), WithRenderer(panickingRenderer{}), WithMetrics(metrics))
+
+	checkPanicError := func(name string, result *CaptchaResult, err error) {
+		t.Helper()
+		if result != nil {
+			t.Errorf("%s: expected nil result after panic, got %+v", name, result)
+		}
+		captchaErr, ok := err.(*CaptchaError)
+		if !ok {
+			t.Fatalf("%s: expected *CaptchaError, got %T (%v)", name, err, err)
+		}
+		if captchaErr.Type != ErrRenderFailed || captchaErr.Code != 500 {
+			t.Errorf("%s: expected %s with code 500, got %s/%d", name, ErrRenderFailed, captchaErr.Type, captchaErr.Code)
+		}
+		if !strings.Contains(captchaErr.Message, "renderer exploded") {
+			t.Errorf("%s: expected panic value in message, got %q", name, captchaErr.Message)
+		}
+		if !strings.Contains(captchaErr.Debug, "panickingRenderer") {
+			t.Errorf("%s: expected stack in Debug, got %q", name, captchaErr.Debug)
+		}
+	}
+
+	result, err := generator.CreateMathExpr()
+	checkPanicError("CreateMathExpr", result, err)
+
+	var buf bytes.Buffer
+	result, err = generator.WriteMathExpr(&buf)
+	checkPanicError("WriteMathExpr", result, err)
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing written after panic, got %d bytes", buf.Len())
+	}
+
+	if _, err := generator.GenerateMultiple(3); err == nil {
+		t.Error("Expected GenerateMultiple to surface the panic")
+	}
+
+	// The stack is for logs only
+	data, _ := json.Marshal(err)
+	if strings.Contains(string(data), "goroutine") {
+		t.Errorf("Expected Debug to be omitted from JSON, got %s", data)
+	}
+
+	if !strings.Contains(string(metrics.WriteText()), `captcha_generation_errors_total{type="RENDER_FAILED"}`) {
+		t.Error("Expected recovered panics to be counted as render failures")
+	}
+}
+
+func TestErrorSentinelsAndWrapping(t *testing.T) {
+	cause := errors.New("disk full")
+	err := WrapError(ErrRenderFailed, "failed to store captcha", 500, cause)
+
+	if !errors.Is(err, ErrorRenderFailed) {
+		t.Error("Expected error to match its sentinel")
+	}
+	if errors.Is(err, ErrorInvalidConfig) {
+		t.Error("Expected error not to match another type's sentinel")
+	}
+	if !errors.Is(err, cause) || errors.Unwrap(err) != cause {
+		t.Error("Expected the cause to be preserved")
+	}
+	if err.Message != "failed to store captcha" {
+		t.Errorf("Expected the cause to stay out of Message, got %q", err.Message)
+	}
+	if got := err.Error(); got != "[RENDER_FAILED] failed to store captcha: disk full (code: 500)" {
+		t.Errorf("Unexpected error string %q", got)
+	}
+
+	// Matching works through further wrapping
+	wrapped := fmt.Errorf("issue: %w", err)
+	if !errors.Is(wrapped, ErrorRenderFailed) {
+		t.Error("Expected sentinel match through fmt.Errorf wrapping")
+	}
+	if captchaErr, ok := AsCaptchaError(wrapped); !ok || captchaErr != err {
+		t.Error("Expected AsCaptchaError to find the wrapped error")
+	}
+
+	config := DefaultConfig()
+	config.Width = -1
+	if err := config.Validate(); !errors.Is(err, ErrorInvalidConfig) {
+		t.Errorf("Expected validation errors to match ErrorInvalidConfig, got %v", err)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		status    int
+		problem   string
+		errorType string
+		detail    string
+	}{
+		{"client error", NewError(ErrInvalidConfig, "unknown theme: neon", 400), 400,
+			ProblemTypeBase + "invalid-config", ErrInvalidConfig, "unknown theme: neon"},
+		{"server error", WrapError(ErrRenderFailed, "failed to store captcha", 500, errors.New("secret dsn")), 500,
+			ProblemTypeBase + "render-failed", ErrRenderFailed, ""},
+		{"wrapped", fmt.Errorf("issue: %w", ErrorSizeBudgetExceeded), 500,
+			ProblemTypeBase + "size-budget-exceeded", ErrSizeBudgetExceeded, ""},
+		{"foreign error", errors.New("boom"), 500, "about:blank", "", ""},
+		{"invalid code", NewError(ErrRenderFailed, "odd", 200), 500,
+			ProblemTypeBase + "render-failed", ErrRenderFailed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := HTTPStatus(tt.err); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+
+			recorder := httptest.NewRecorder()
+			WriteProblem(recorder, httptest.NewRequest("GET", "/captcha", nil), tt.err)
+
+			if recorder.Code != tt.status {
+				t.Errorf("Expected response status %d, got %d", tt.status, recorder.Code)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != ProblemContentType {
+				t.Errorf("Expected %s, got %s", ProblemContentType, contentType)
+			}
+
+			var problem Problem
+			if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("Failed to decode problem: %v", err)
+			}
+			if problem.Type != tt.problem || problem.Status != tt.status || problem.ErrorType != tt.errorType {
+				t.Errorf("Unexpected problem %+v", problem)
+			}
+			if problem.Detail != tt.detail {
+				t.Errorf("Expected detail %q, got %q", tt.detail, problem.Detail)
+			}
+			if problem.Title == "" || problem.Instance != "/captcha" {
+				t.Errorf("Expected title and instance, got %+v", problem)
+			}
+			if strings.Contains(recorder.Body.String(), "secret") {
+				t.Error("Expected causes of server errors to stay hidden")
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := NewSpanRecorder()
+	generator := NewCaptchaGenerator(DefaultConfig(), WithTracer(recorder))
+	service := NewService(generator, nil)
+
+	// spanTree maps each span name to its parent's name
+	spanTree := func() map[string]string {
+		spans := recorder.Spans()
+		names := make(map[int]string)
+		for _, span := range spans {
+			names[span.ID] = span.Name
+		}
+		tree := make(map[string]string)
+		for _, span := range spans {
+			if !span.Ended {
+				t.Errorf("Span %s was not ended", span.Name)
+			}
+			tree[span.Name] = names[span.ParentID]
+		}
+		return tree
+	}
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatalf("Failed to issue captcha: %v", err)
+	}
+	expected := map[string]string{
+		SpanGenerate:   "",
+		SpanExpression: SpanGenerate,
+		SpanRender:     SpanGenerate,
+		SpanNoise:      SpanRender,
+		SpanStoreSet:   "",
+	}
+	if tree := spanTree(); fmt.Sprint(tree) != fmt.Sprint(expected) {
+		t.Errorf("Expected issue spans %v, got %v", expected, tree)
+	}
+	generate := recorder.Spans()[0]
+	if hash, _ := generate.Attribute("captcha.config_hash"); hash != generator.GetConfig().Hash() {
+		t.Errorf("Expected config hash attribute, got %v", hash)
+	}
+
+	recorder.Reset()
+	if _, err := service.Verify(challenge.ID, "wrong"); err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	expected = map[string]string{SpanVerify: "", SpanStoreTake: SpanVerify}
+	if tree := spanTree(); fmt.Sprint(tree) != fmt.Sprint(expected) {
+		t.Errorf("Expected verify spans %v, got %v", expected, tree)
+	}
+	if result, _ := recorder.Spans()[0].Attribute("captcha.verify.result"); result != string(VerifyFailure) {
+		t.Errorf("Expected verification result attribute, got %v", result)
+	}
+
+	// Spans nest under a caller's span and record errors
+	recorder.Reset()
+	ctx, parent := recorder.Start(t.Context(), "http.request")
+	invalid := DefaultConfig()
+	invalid.Width = -1
+	if _, err := generator.CreateMathExprContext(ctx, invalid); err == nil {
+		t.Fatal("Expected invalid options to fail")
+	}
+	parent.End()
+	spans := recorder.Spans()
+	if len(spans) != 2 || spans[1].Name != SpanGenerate || spans[1].ParentID != spans[0].ID {
+		t.Fatalf("Expected generate span under the request span, got %+v", spans)
+	}
+	if len(spans[1].Errors) != 1 || !errors.Is(spans[1].Errors[0], ErrorInvalidConfig) {
+		t.Errorf("Expected the validation error on the span, got %v", spans[1].Errors)
+	}
+
+	// Rasterization is traced through the context
+	recorder.Reset()
+	if _, err := challenge.Result.PNGContext(ContextWithTracer(t.Context(), recorder), 1); err != nil {
+		t.Fatalf("Failed to rasterize: %v", err)
+	}
+	spans = recorder.Spans()
+	if len(spans) != 1 || spans[0].Name != SpanRasterize {
+		t.Fatalf("Expected a rasterize span, got %+v", spans)
+	}
+	if size, _ := spans[0].Attribute("captcha.png.bytes"); size == nil || size.(int) <= 0 {
+		t.Errorf("Expected PNG size attribute, got %v", size)
+	}
+
+	recorder.Reset()
+	if _, err := generator.WriteMathExpr(io.Discard); err != nil {
+		t.Fatalf("Failed to write captcha: %v", err)
+	}
+	if len(recorder.Spans()) != 4 {
+		t.Errorf("Expected generate, expression, render and noise spans, got %+v", recorder.Spans())
+	}
+}
+
+func TestNoopTracerDisablesTracing(t *testing.T) {
+	generator := NewCaptchaGenerator(nil, WithTracer(NoopTracer{}))
+	if generator.tracer != nil {
+		t.Error("Expected NoopTracer to disable tracing")
+	}
+
+	allocs := testing.AllocsPerRun(50, func() {
+		_, span := startSpan(t.Context(), SpanRender)
+		if span.IsRecording() {
+			span.SetAttributes(IntAttribute("captcha.svg.bytes", 1))
+		}
+		endSpan(span, nil)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected untraced spans not to allocate, got %v allocs", allocs)
+	}
+}
+
+func TestSeededRandomSource(t *testing.T) {
+	generate := func(seed uint64) []*CaptchaResult {
+		restore := randsource.Set(randsource.NewSeeded(seed))
+		defer restore()
+
+		config := DefaultConfig()
+		config.Noise = 5
+		config.Decoys = 5
+		config.Distortion.WaveAmplitude = 3
+		config.Effects.Pattern = "dots"
+		results, err := NewCaptchaGenerator(config).GenerateMultiple(3)
+		if err != nil {
+			t.Fatalf("Failed to generate captchas: %v", err)
+		}
+		return results
+	}
+
+	first, second, other := generate(42), generate(42), generate(43)
+	for i := range first {
+		if first[i].Data != second[i].Data || first[i].Question != second[i].Question {
+			t.Errorf("Captcha %d differs between runs with the same seed", i)
+		}
+	}
+	if first[0].Data == other[0].Data {
+		t.Error("Expected different seeds to produce different captchas")
+	}
+
+	// Restoring returns to crypto/rand
+	if randsource.Source() != rand.Reader {
+		t.Error("Expected restore to reinstate crypto/rand")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	now := time.Now()
+	store.Set("live", StoreEntry{Answer: "7", ExpiresAt: now.Add(time.Minute)})
+	store.Set("stale", StoreEntry{Answer: "3", ExpiresAt: now.Add(-time.Minute)})
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 entries, got %d", store.Len())
+	}
+
+	entry, ok, err := store.Take("live")
+	if err != nil || !ok || entry.Answer != "7" || !entry.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected the stored entry, got %+v %v %v", entry, ok, err)
+	}
+	if _, ok, _ := store.Take("live"); ok {
+		t.Error("Expected Take to remove the entry")
+	}
+
+	if removed := store.Cleanup(now); removed != 1 || store.Len() != 0 {
+		t.Errorf("Expected cleanup to remove the stale entry, removed %d, %d left", removed, store.Len())
+	}
+
+	// Len counts without listing the directory: entries of other processes show up when a
+	// store is opened and after Cleanup
+	store.Set("mine", StoreEntry{Answer: "4", ExpiresAt: now.Add(time.Minute)})
+	other, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to open the store again: %v", err)
+	}
+	if other.Len() != 1 {
+		t.Errorf("Expected the reopened store to count 1 entry, got %d", other.Len())
+	}
+	other.Set("theirs", StoreEntry{Answer: "5", ExpiresAt: now.Add(time.Minute)})
+	store.Set("mine", StoreEntry{Answer: "6", ExpiresAt: now.Add(time.Minute)})
+	if store.Len() != 1 {
+		t.Errorf("Expected replacing an entry to keep the count, got %d", store.Len())
+	}
+	if store.Cleanup(now); store.Len() != 2 {
+		t.Errorf("Expected cleanup to recount 2 entries, got %d", store.Len())
+	}
+
+	// IDs never escape the directory
+	if err := store.Set("../escape", StoreEntry{Answer: "1"}); !errors.Is(err, ErrorInvalidRequest) {
+		t.Errorf("Expected a path ID to be rejected, got %v", err)
+	}
+	if _, ok, err := store.Take("../escape"); ok || err != nil {
+		t.Errorf("Expected a path ID to be unknown, got %v %v", ok, err)
+	}
+}
+
+func TestServiceRefresh(t *testing.T) {
+	service := NewService(nil, nil)
+
+	first, err := service.Issue()
+	if err != nil {
+		t.Fatalf("Failed to issue captcha: %v", err)
+	}
+	second, err := service.Refresh(first.ID)
+	if err != nil {
+		t.Fatalf("Failed to refresh captcha: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Error("Expected a new ID")
+	}
+	if service.Store().Len() != 1 {
+		t.Errorf("Expected only the new captcha to be stored, got %d", service.Store().Len())
+	}
+	if result, _ := service.Verify(first.ID, first.Result.Text); result != VerifyNotFound {
+		t.Errorf("Expected the old captcha to be invalidated, got %s", result)
+	}
+
+	// Refreshing an unknown or expired captcha still issues a new one
+	if _, err := service.Refresh("unknown"); err != nil {
+		t.Errorf("Expected refresh of an unknown ID to succeed, got %v", err)
+	}
+	if _, err := service.RefreshWithThemeContext(t.Context(), second.ID, "neon"); !errors.Is(err, ErrorInvalidConfig) {
+		t.Errorf("Expected an unknown theme to fail, got %v", err)
+	}
+	if result, _ := service.Verify(second.ID, second.Result.Text); result != VerifySuccess {
+		t.Errorf("Expected a failed refresh to keep the old captcha, got %s", result)
+	}
+}
+
+func TestSessionBoundCaptchas(t *testing.T) {
+	service := NewService(nil, nil)
+
+	// Only the session a captcha was issued to can verify it
+	for _, verify := range []func(id, answer string) (VerifyResult, error){
+		func(id, answer string) (VerifyResult, error) { return service.VerifyContext(t.Context(), id, answer) },
+		func(id, answer string) (VerifyResult, error) {
+			return service.VerifySessionContext(t.Context(), "session-b", id, answer)
+		},
+	} {
+		challenge, err := service.IssueWithOptionsContext(t.Context(), IssueOptions{Session: "session-a"})
+		if err != nil {
+			t.Fatalf("Failed to issue captcha: %v", err)
+		}
+		if result, _ := verify(challenge.ID, challenge.Result.Text); result != VerifyNotFound {
+			t.Errorf("Expected a captcha of another session to be not found, got %s", result)
+		}
+	}
+
+	challenge, err := service.IssueWithOptionsContext(t.Context(), IssueOptions{Session: "session-a"})
+	if err != nil {
+		t.Fatalf("Failed to issue captcha: %v", err)
+	}
+	if result, _ := service.VerifySessionContext(t.Context(), "session-a", challenge.ID, challenge.Result.Text); result != VerifySuccess {
+		t.Errorf("Expected the session to verify its captcha, got %s", result)
+	}
+
+	// Session-less captchas are not verified in a session either
+	challenge, _ = service.Issue()
+	if result, _ := service.VerifySessionContext(t.Context(), "session-a", challenge.ID, challenge.Result.Text); result != VerifyNotFound {
+		t.Errorf("Expected a session-less captcha to be not found in a session, got %s", result)
+	}
+}
+
+func TestRefreshRateLimit(t *testing.T) {
+	service := NewService(nil, nil)
+	if err := service.SetRefreshLimit(-1, time.Minute); !errors.Is(err, ErrorInvalidConfig) {
+		t.Errorf("Expected a negative limit to be rejected, got %v", err)
+	}
+	if err := service.SetRefreshLimit(2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if challenge, err = service.RefreshClientContext(t.Context(), "session-a", challenge.ID, ""); err != nil {
+			t.Fatalf("Expected refreshes within the limit to succeed, got %v", err)
+		}
+	}
+	_, err = service.RefreshClientContext(t.Context(), "session-a", challenge.ID, "")
+	captchaErr, ok := AsCaptchaError(err)
+	if !errors.Is(err, ErrorRateLimited) || !ok || captchaErr.RetryAfter <= 0 || captchaErr.RetryAfter > time.Hour {
+		t.Fatalf("Expected a rate limit error with a retry delay, got %v", err)
+	}
+	if _, err := service.RefreshClientContext(t.Context(), "session-b", "", ""); err != nil {
+		t.Errorf("Expected other clients to keep their own limit, got %v", err)
+	}
+	if result, _ := service.Verify(challenge.ID, challenge.Result.Text); result != VerifySuccess {
+		t.Errorf("Expected a limited refresh to keep the old captcha, got %s", result)
+	}
+
+	recorder := httptest.NewRecorder()
+	WriteProblem(recorder, httptest.NewRequest("POST", "/refresh", nil), err)
+	if recorder.Code != 429 || recorder.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	// Windows end, and a limit of 0 removes the cap
+	limiter, _ := NewRateLimiter(1, time.Minute)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limiter.Allow("key")
+	if ok, retryAfter := limiter.Allow("key"); ok || retryAfter != time.Minute {
+		t.Errorf("Expected the key to wait a minute, got %v %v", ok, retryAfter)
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := limiter.Allow("key"); !ok {
+		t.Error("Expected a new window to allow the key again")
+	}
+	if removed := limiter.Cleanup(now.Add(time.Minute)); removed != 1 {
+		t.Errorf("Expected the ended window to be removed, got %d", removed)
+	}
+	if err := service.SetRefreshLimit(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.RefreshClientContext(t.Context(), "session-a", "", ""); err != nil {
+		t.Errorf("Expected no cap with a limit of 0, got %v", err)
+	}
+}
+
+// Benchmark tests
+func BenchmarkCaptchaGeneration(b *testing.B) {
+	generator := NewCaptchaGenerator(DefaultConfig())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := generator.CreateMathExpr()
+		if err != nil {
+			b.Fatalf("Failed to generate captcha: %v", err)
+		}
+	}
+}
+
+func BenchmarkMathExpressionGeneration(b *testing.B) {
+	config := DefaultConfig()
+	generator := NewMathExpressionGenerator(config)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := generator.GenerateExpression()
+		if err != nil {
+			b.Fatalf("Failed to generate math expression: %v", err)
+		}
+	}
+}
+
+func BenchmarkSVGRendering(b *testing.B) {
+	config := DefaultConfig()
+	renderer := NewSVGRenderer(config)
+	expr := &MathExpression{
+		Operand1: 3,
+		Operand2: 5,
+		Operator: "+",
+		Answer:   8,
+		Question: "3 + 5 = ?",
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := renderer.RenderMathExpression(expr, config)
+		if err != nil {
+			b.Fatalf("Failed to render SVG: %v", err)
+		}
+	}
+}
+
+func BenchmarkSVGEncoding(b *testing.B) {
+	config := DefaultConfig()
+	config.Noise = 4
+	config.Distortion.WaveAmplitude = 2
+	renderer := NewSVGRenderer(config)
+	svg := renderer.createSVGContainer(config)
+	if err := renderer.addTextToSVG(svg, "3 + 5 = ", config); err != nil {
+		b.Fatalf("Failed to add text: %v", err)
+	}
+	renderer.addNoiseToSVG(svg, config)
+
+	for _, minify := range []bool{false, true} {
+		name := "indented"
+		if minify {
+			name = "minified"
+		}
+		b.Run(name, func(b *testing.B) {
+			config.Minify = minify
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := encodeSVG(&buf, svg, config); err != nil {
+					b.Fatalf("Failed to encode SVG: %v", err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len()), "bytes")
+		})
+	}
+}
+
+func BenchmarkSVGWriting(b *testing.B) {
+	config := DefaultConfig()
+	renderer := NewSVGRenderer(config)
+	expr := &MathExpression{
+		Operand1: 3,
+		Operand2: 5,
+		Operator: "+",
+		Answer:   8,
+		Question: "3 + 5 = ?",
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := renderer.WriteMathExpression(io.Discard, expr, config)
+		if err != nil {
+			b.Fatalf("Failed to write SVG: %v", err)
+		}
+	}
+}
+
+func BenchmarkCaptchaGenerationParallel(b *testing.B) {
+	generator := NewCaptchaGenerator(DefaultConfig())
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := generator.CreateMathExpr(); err != nil {
+				b.Fatalf("Failed to generate captcha: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkSecureRandom(b *testing.B) {
+	b.Run("int", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := secureRandomInt(1000); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("float", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := secureRandomFloat(-15, 15); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
@@ -1,73 +1,324 @@
 package captcha
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
 
-// ColorManager handles color selection for captcha elements
-type ColorManager struct {
-	enableColor bool
-	background  string
-	textColors  []string
-	noiseColors []string
-}
+// Text palettes for Config.Palette
+const (
+	PaletteDefault    = "default"
+	PaletteColorBlind = "colorblind"
+)
 
-// NewColorManager creates a new color manager
-func NewColorManager(config *Config) *ColorManager {
-	textColors := []string{
+// highContrastRatio is the minimum text contrast enforced in high-contrast mode (WCAG AAA)
+const highContrastRatio = 7.0
+
+// maxHexCacheSize bounds the number of interned Hex strings
+const maxHexCacheSize = 4096
+
+// hexCache interns Hex strings, since captchas draw the same palette colors over and over
+var hexCache = struct {
+	sync.RWMutex
+	colors map[uint32]string
+}{colors: make(map[uint32]string)}
+
+// highContrastNoiseRatio caps the contrast of noise colors in high-contrast mode
+const highContrastNoiseRatio = 1.5
+
+// Built-in palettes used when the configuration does not supply its own colors
+var (
+	defaultTextColors = []string{
 		"#1a1a1a", "#2c3e50", "#34495e", "#7f8c8d",
 		"#c0392b", "#e74c3c", "#d35400", "#e67e22",
 		"#16a085", "#27ae60", "#2980b9", "#8e44ad",
 	}
-
-	noiseColors := []string{
+	defaultNoiseColors = []string{
 		"#bdc3c7", "#95a5a6", "#ecf0f1", "#d5dbdb",
 		"#f8c471", "#f7dc6f", "#aed6f1", "#a9dfbf",
 	}
+	grayscaleTextColors = []string{
+		"#1a1a1a", "#2c2c2c", "#3f3f3f", "#525252",
+		"#666666", "#7a7a7a", "#8d8d8d", "#a0a0a0",
+	}
+	grayscaleNoiseColors = []string{
+		"#bdc3c7", "#d5d5d5", "#e8e8e8", "#f2f2f2",
+	}
+
+	// colorBlindTextColors is the Okabe-Ito palette, distinguishable under common color vision deficiencies
+	colorBlindTextColors = []string{
+		"#000000", "#e69f00", "#56b4e9", "#009e73",
+		"#f0e442", "#0072b2", "#d55e00", "#cc79a7",
+	}
+)
+
+// RGBA is a color with straight alpha; A is the opacity from 0 (transparent) to 1 (opaque)
+type RGBA struct {
+	R, G, B uint8
+	A       float64
+}
+
+// Hex returns the color channels as "#rrggbb", without alpha
+func (c RGBA) Hex() string {
+	key := uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+	hexCache.RLock()
+	hex, ok := hexCache.colors[key]
+	hexCache.RUnlock()
+	if ok {
+		return hex
+	}
+
+	const digits = "0123456789abcdef"
+	hex = string([]byte{'#',
+		digits[c.R>>4], digits[c.R&0x0f],
+		digits[c.G>>4], digits[c.G&0x0f],
+		digits[c.B>>4], digits[c.B&0x0f]})
+
+	hexCache.Lock()
+	if len(hexCache.colors) < maxHexCacheSize {
+		hexCache.colors[key] = hex
+	}
+	hexCache.Unlock()
+	return hex
+}
+
+// Opaque reports whether the color has no transparency
+func (c RGBA) Opaque() bool {
+	return c.A >= 1
+}
+
+// String returns "#rrggbb" for opaque colors and "rgba(r, g, b, a)" otherwise
+func (c RGBA) String() string {
+	if c.Opaque() {
+		return c.Hex()
+	}
+	return fmt.Sprintf("rgba(%d, %d, %d, %s)", c.R, c.G, c.B, formatOpacity(c.A))
+}
+
+// WithAlpha returns the color with its opacity replaced, clamped to 0-1
+func (c RGBA) WithAlpha(alpha float64) RGBA {
+	c.A = math.Max(0, math.Min(1, alpha))
+	return c
+}
+
+// Over composites the color over background and returns the opaque result
+func (c RGBA) Over(background RGBA) RGBA {
+	mix := func(fg, bg uint8) uint8 {
+		return uint8(math.Round(float64(fg)*c.A + float64(bg)*(1-c.A)))
+	}
+	return RGBA{R: mix(c.R, background.R), G: mix(c.G, background.G), B: mix(c.B, background.B), A: 1}
+}
+
+// opacityAttr formats the alpha for a fill-opacity or stroke-opacity attribute,
+// returning "" for opaque colors so the attribute is omitted
+func (c RGBA) opacityAttr() string {
+	if c.Opaque() {
+		return ""
+	}
+	return formatOpacity(c.A)
+}
+
+// formatOpacity formats an opacity with at most three decimals
+func formatOpacity(alpha float64) string {
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(alpha, 'f', 3, 64), "0"), ".")
+}
+
+// OpacityRange bounds the random opacity of a noise layer. The zero value keeps the layer opaque.
+type OpacityRange struct {
+	Min float64 `json:"min"` // Lowest opacity, 0-1
+	Max float64 `json:"max"` // Highest opacity, Min-1
+}
+
+// Validate checks that the range lies within 0-1 and Min does not exceed Max
+func (o OpacityRange) Validate(name string) error {
+	if o.Min < 0 || o.Max > 1 || o.Min > o.Max {
+		return NewError(ErrInvalidConfig, name+" opacity range must satisfy 0 <= min <= max <= 1", 400)
+	}
+	return nil
+}
+
+// random returns an opacity within the range, or 1 for the zero value
+func (o OpacityRange) random() float64 {
+	if o.Max == 0 {
+		return 1
+	}
+	alpha, err := secureRandomFloat(o.Min, o.Max)
+	if err != nil {
+		return o.Max
+	}
+	return alpha
+}
+
+// ColorManager handles color selection for captcha elements
+type ColorManager struct {
+	enableColor bool
+	background  string
+	backdrop    RGBA // Parsed background, used for contrast and blending
+	textColors  []RGBA
+	noiseColors []RGBA
+	lineOpacity OpacityRange
+	dotOpacity  OpacityRange
+}
+
+// NewColorManager creates a new color manager. Custom TextColors and NoiseColors
+// replace the built-in palettes selected by Color and Palette.
+func NewColorManager(config *Config) *ColorManager {
+	textColors, noiseColors := defaultTextColors, defaultNoiseColors
+	if config.Palette == PaletteColorBlind {
+		textColors = colorBlindTextColors
+	}
 
 	if !config.Color {
 		// Use grayscale colors only
-		textColors = []string{
-			"#1a1a1a", "#2c2c2c", "#3f3f3f", "#525252",
-			"#666666", "#7a7a7a", "#8d8d8d", "#a0a0a0",
-		}
-		noiseColors = []string{
-			"#bdc3c7", "#d5d5d5", "#e8e8e8", "#f2f2f2",
-		}
+		textColors, noiseColors = grayscaleTextColors, grayscaleNoiseColors
 	}
 
-	return &ColorManager{
+	if len(config.TextColors) > 0 {
+		textColors = config.TextColors
+	}
+	if len(config.NoiseColors) > 0 {
+		noiseColors = config.NoiseColors
+	}
+
+	cm := &ColorManager{
 		enableColor: config.Color,
 		background:  config.Background,
-		textColors:  textColors,
-		noiseColors: noiseColors,
+		textColors:  parsePalette(textColors),
+		noiseColors: parsePalette(noiseColors),
+		lineOpacity: config.LineOpacity,
+		dotOpacity:  config.DotOpacity,
 	}
+
+	// Contrast rules only apply when the background can be parsed
+	backdrop, err := ParseRGBA(config.Background)
+	if err != nil {
+		return cm
+	}
+	cm.backdrop = backdrop.WithAlpha(1)
+	cm.background = cm.backdrop.Hex()
+
+	minContrast := config.MinContrast
+	if config.HighContrast {
+		minContrast = max(minContrast, highContrastRatio)
+		for i, color := range cm.noiseColors {
+			cm.noiseColors[i] = fadeToContrast(color, cm.backdrop, highContrastNoiseRatio, 1)
+		}
+	}
+	cm.textColors = accessibleColors(cm.textColors, cm.backdrop, minContrast, config.HighContrast)
+
+	return cm
 }
 
-// GetRandomTextColor returns a random color suitable for text
-func (cm *ColorManager) GetRandomTextColor() string {
-	if len(cm.textColors) == 0 {
-		return "#000000" // fallback
+// parsePalette parses a list of CSS colors, skipping invalid entries
+func parsePalette(colors []string) []RGBA {
+	palette := make([]RGBA, 0, len(colors))
+	for _, value := range colors {
+		if color, err := ParseRGBA(value); err == nil {
+			palette = append(palette, color)
+		}
 	}
+	return palette
+}
 
-	index, err := secureRandomInt(len(cm.textColors))
-	if err != nil {
-		return cm.textColors[0] // fallback to first color
+// accessibleColors returns the colors whose contrast against background reaches minRatio.
+// Failing colors are darkened or lightened instead of dropped when adjust is set or when
+// no color passes.
+func accessibleColors(colors []RGBA, background RGBA, minRatio float64, adjust bool) []RGBA {
+	if minRatio <= 1 {
+		return colors
 	}
 
-	return cm.textColors[index]
+	var passing []RGBA
+	for _, color := range colors {
+		if contrast(color, background) >= minRatio {
+			passing = append(passing, color)
+		}
+	}
+	if len(passing) == len(colors) || (len(passing) > 0 && !adjust) {
+		return passing
+	}
+
+	adjusted := make([]RGBA, len(colors))
+	for i, color := range colors {
+		adjusted[i] = adjustContrast(color, background, minRatio)
+	}
+	return adjusted
 }
 
-// GetRandomNoiseColor returns a random color suitable for noise elements
-func (cm *ColorManager) GetRandomNoiseColor() string {
-	if len(cm.noiseColors) == 0 {
-		return "#cccccc" // fallback
+// adjustContrast mixes color toward black or white, whichever contrasts more with the
+// background, until its contrast reaches minRatio. Adjusted colors are opaque.
+func adjustContrast(color, background RGBA, minRatio float64) RGBA {
+	if contrast(color, background) >= minRatio {
+		return color
+	}
+
+	target := RGBA{A: 1}
+	white := RGBA{R: 0xff, G: 0xff, B: 0xff, A: 1}
+	if contrast(white, background) > contrast(target, background) {
+		target = white
+	}
+
+	// Contrast grows monotonically as the color approaches the target
+	visible := color.Over(background)
+	low, high := 0.0, 1.0
+	for i := 0; i < 16; i++ {
+		amount := (low + high) / 2
+		if contrast(target.WithAlpha(amount).Over(visible), background) >= minRatio {
+			high = amount
+		} else {
+			low = amount
+		}
+	}
+	return target.WithAlpha(high).Over(visible)
+}
+
+// pick returns a random palette entry, or fallback for an empty palette
+func pick(palette []RGBA, fallback RGBA) RGBA {
+	if len(palette) == 0 {
+		return fallback
 	}
 
-	index, err := secureRandomInt(len(cm.noiseColors))
+	index, err := secureRandomInt(len(palette))
 	if err != nil {
-		return cm.noiseColors[0] // fallback to first color
+		return palette[0] // fallback to first color
 	}
 
-	return cm.noiseColors[index]
+	return palette[index]
+}
+
+// GetRandomTextRGBA returns a random color suitable for text
+func (cm *ColorManager) GetRandomTextRGBA() RGBA {
+	return pick(cm.textColors, RGBA{A: 1})
+}
+
+// GetRandomNoiseRGBA returns a random color suitable for noise elements
+func (cm *ColorManager) GetRandomNoiseRGBA() RGBA {
+	return pick(cm.noiseColors, RGBA{R: 0xcc, G: 0xcc, B: 0xcc, A: 1})
+}
+
+// GetNoiseLineColor returns a random noise color with an opacity from the line opacity range
+func (cm *ColorManager) GetNoiseLineColor() RGBA {
+	color := cm.GetRandomNoiseRGBA()
+	return color.WithAlpha(color.A * cm.lineOpacity.random())
+}
+
+// GetNoiseDotColor returns a random noise color with an opacity from the dot opacity range
+func (cm *ColorManager) GetNoiseDotColor() RGBA {
+	color := cm.GetRandomNoiseRGBA()
+	return color.WithAlpha(color.A * cm.dotOpacity.random())
+}
+
+// GetRandomTextColor returns a random color suitable for text
+func (cm *ColorManager) GetRandomTextColor() string {
+	return cm.GetRandomTextRGBA().String()
+}
+
+// GetRandomNoiseColor returns a random color suitable for noise elements
+func (cm *ColorManager) GetRandomNoiseColor() string {
+	return cm.GetRandomNoiseRGBA().String()
 }
 
 // GetBackgroundColor returns the configured background color
@@ -75,12 +326,132 @@ func (cm *ColorManager) GetBackgroundColor() string {
 	return cm.background
 }
 
-// GetRandomColorWithOpacity returns a random color with specified opacity
+// GetRandomColorWithOpacity returns a random noise color with the given opacity as an rgba() color.
+//
+// Deprecated: Use GetRandomNoiseRGBA and emit its alpha as a fill-opacity or stroke-opacity attribute.
 func (cm *ColorManager) GetRandomColorWithOpacity(opacity float64) string {
-	color := cm.GetRandomNoiseColor()
-	// Convert hex to rgba if needed (simplified implementation)
-	if opacity < 1.0 {
-		return fmt.Sprintf("%s;opacity:%.2f", color, opacity)
+	color := cm.GetRandomNoiseRGBA()
+	return color.WithAlpha(color.A * opacity).String()
+}
+
+// parseHexRGBA parses "#rgb", "#rgba", "#rrggbb" or "#rrggbbaa"
+func parseHexRGBA(hex string) (RGBA, bool) {
+	if len(hex) == 0 || hex[0] != '#' {
+		return RGBA{}, false
+	}
+	hex = hex[1:]
+	if len(hex) == 3 || len(hex) == 4 {
+		expanded := make([]byte, 0, 8)
+		for i := 0; i < len(hex); i++ {
+			expanded = append(expanded, hex[i], hex[i])
+		}
+		hex = string(expanded)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return RGBA{}, false
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return RGBA{}, false
+	}
+	return RGBA{R: uint8(value >> 24), G: uint8(value >> 16), B: uint8(value >> 8), A: float64(uint8(value)) / 255}, true
+}
+
+// relativeLuminance computes the WCAG relative luminance of an sRGB color
+func relativeLuminance(r, g, b uint8) float64 {
+	linear := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+// contrast returns the WCAG contrast ratio of color, composited over background, against background
+func contrast(color, background RGBA) float64 {
+	fg := color.Over(background)
+	la := relativeLuminance(fg.R, fg.G, fg.B)
+	lb := relativeLuminance(background.R, background.G, background.B)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// ContrastRatio returns the WCAG contrast ratio (1-21) of color a against background b, in
+// any format accepted by ParseRGBA, or 1 if either color cannot be parsed. A translucent a
+// is composited over b first.
+func ContrastRatio(a, b string) float64 {
+	fg, ok1 := parseRGBA(a)
+	bg, ok2 := parseRGBA(b)
+	if !ok1 || !ok2 {
+		return 1
+	}
+	return contrast(fg, bg.WithAlpha(1))
+}
+
+// blendColors mixes color over background with the given alpha and returns a hex color
+func blendColors(color, background string, alpha float64) string {
+	fg, ok1 := parseRGBA(color)
+	bg, ok2 := parseRGBA(background)
+	if !ok1 || !ok2 {
+		return color
+	}
+	return fg.WithAlpha(alpha).Over(bg.WithAlpha(1)).Hex()
+}
+
+// TextColors returns the text palette after contrast filtering
+func (cm *ColorManager) TextColors() []string {
+	colors := make([]string, len(cm.textColors))
+	for i, color := range cm.textColors {
+		colors[i] = color.String()
+	}
+	return colors
+}
+
+// MinTextContrast returns the lowest contrast between any text color and the background
+func (cm *ColorManager) MinTextContrast() float64 {
+	minContrast := math.Inf(1)
+	for _, color := range cm.textColors {
+		minContrast = min(minContrast, contrast(color, cm.backdrop))
+	}
+	return minContrast
+}
+
+// GetDecoyColor returns a random text color faded toward the background so that its contrast
+// stays well below the weakest text color, keeping decoys visually separable from the answer.
+// maxAlpha caps how strongly the text color shows through.
+func (cm *ColorManager) GetDecoyColor(maxAlpha float64) string {
+	// Decoys may use at most 40% of the contrast headroom of the faintest text color
+	limit := 1 + (cm.MinTextContrast()-1)*0.4
+	return fadeToContrast(cm.GetRandomTextRGBA(), cm.backdrop, limit, maxAlpha).Hex()
+}
+
+// fadeToContrast blends color toward background with at most maxAlpha so that its contrast
+// does not exceed limit. The result is opaque.
+func fadeToContrast(color, background RGBA, limit, maxAlpha float64) RGBA {
+	fade := func(alpha float64) RGBA {
+		return color.WithAlpha(color.A * alpha).Over(background)
+	}
+	if contrast(fade(maxAlpha), background) <= limit {
+		return fade(maxAlpha)
+	}
+
+	// Contrast grows monotonically with alpha, so binary search the strongest allowed fade
+	low, high := 0.0, maxAlpha
+	for i := 0; i < 16; i++ {
+		alpha := (low + high) / 2
+		if contrast(fade(alpha), background) <= limit {
+			low = alpha
+		} else {
+			high = alpha
+		}
 	}
-	return color
+	return fade(low)
 }
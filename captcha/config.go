@@ -1,8 +1,11 @@
 package captcha
 
 import (
+	"encoding/json"
+	"hash/fnv"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config defines the configuration options for SVG math captcha generation
@@ -20,8 +23,38 @@ type Config struct {
 	Color      bool   `json:"color"`      // Use random colors (default: true)
 	Background string `json:"background"` // Background color (default: "#f0f0f0")
 
+	// Palette settings
+	TextColors  []string `json:"textColors"`  // Custom text palette in any CSS color format, replaces the built-in one (default: none)
+	NoiseColors []string `json:"noiseColors"` // Custom noise palette in any CSS color format, replaces the built-in one (default: none)
+
+	// Accessibility settings
+	MinContrast  float64 `json:"minContrast"`  // Minimum WCAG contrast of text colors against Background, 1-21, 0 disables (default: 3)
+	Palette      string  `json:"palette"`      // Text palette: "default" or "colorblind" (default: "default")
+	HighContrast bool    `json:"highContrast"` // Adjust text to a contrast of at least 7 and fade noise colors (default: false)
+
+	// Noise targeting settings
+	NoiseIntersect float64 `json:"noiseIntersect"` // Fraction of noise lines forced through the text, 0-1 (default: 0)
+
+	// Noise opacity settings (default: opaque)
+	LineOpacity OpacityRange `json:"lineOpacity"` // Random opacity range of noise curves
+	DotOpacity  OpacityRange `json:"dotOpacity"`  // Random opacity range of noise dots
+
+	// Decoy settings
+	Decoys       int     `json:"decoys"`       // Faint decoy digits and operators drawn behind the text, 0-30 (default: 0)
+	DecoyOpacity float64 `json:"decoyOpacity"` // Maximum decoy strength relative to the text color, 0-1, 0 uses 0.35 (default: 0)
+
 	// Text settings
 	IgnoreChars string `json:"ignoreChars"` // Characters to avoid (default: "0o1i")
+
+	// Distortion settings applied to glyph outlines (default: all disabled)
+	Distortion DistortionConfig `json:"distortion"`
+
+	// SVG filter effects and background decoration (default: all disabled)
+	Effects EffectsConfig `json:"effects"`
+
+	// Output settings
+	Minify   bool `json:"minify"`   // Write compact SVG without indentation, with shortest numbers and merged relative paths (default: false)
+	MaxBytes int  `json:"maxBytes"` // Size budget of the SVG in bytes, output is minified and rounded further to fit, 0 disables (default: 0)
 }
 
 // DefaultConfig returns a configuration with sensible default values
@@ -36,14 +69,26 @@ func DefaultConfig() *Config {
 		Noise:        1,
 		Color:        true,
 		Background:   "#f0f0f0",
+		MinContrast:  3,
+		Palette:      PaletteDefault,
 		IgnoreChars:  "0o1i",
 	}
 }
 
 // LoadConfigFromEnv loads configuration from environment variables
 // Falls back to default values if environment variables are not set
+// An unknown CAPTCHA_THEME is ignored; use LoadConfigFromEnvE to report it
 func LoadConfigFromEnv() *Config {
+	config, _ := LoadConfigFromEnvE()
+	return config
+}
+
+// LoadConfigFromEnvE is LoadConfigFromEnv returning an ErrInvalidConfig error for an unknown
+// CAPTCHA_THEME. The configuration is returned either way, with the default colors if the
+// theme is unknown.
+func LoadConfigFromEnvE() (*Config, error) {
 	config := DefaultConfig()
+	var themeErr error
 
 	if val := os.Getenv("CAPTCHA_MATH_MIN"); val != "" {
 		if parsed, err := strconv.Atoi(val); err == nil {
@@ -85,21 +130,128 @@ func LoadConfigFromEnv() *Config {
 		}
 	}
 
+	loadFloatFromEnv("CAPTCHA_NOISE_INTERSECT", &config.NoiseIntersect)
+
+	loadFloatFromEnv("CAPTCHA_LINE_OPACITY_MIN", &config.LineOpacity.Min)
+	loadFloatFromEnv("CAPTCHA_LINE_OPACITY_MAX", &config.LineOpacity.Max)
+	loadFloatFromEnv("CAPTCHA_DOT_OPACITY_MIN", &config.DotOpacity.Min)
+	loadFloatFromEnv("CAPTCHA_DOT_OPACITY_MAX", &config.DotOpacity.Max)
+
+	if val := os.Getenv("CAPTCHA_DECOYS"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			config.Decoys = parsed
+		}
+	}
+
+	loadFloatFromEnv("CAPTCHA_DECOY_OPACITY", &config.DecoyOpacity)
+
 	if val := os.Getenv("CAPTCHA_COLOR"); val != "" {
 		if parsed, err := strconv.ParseBool(val); err == nil {
 			config.Color = parsed
 		}
 	}
 
+	// A theme sets the background and palettes, which the variables below may override
+	if val := os.Getenv("CAPTCHA_THEME"); val != "" {
+		if err := config.ApplyTheme(val); err != nil {
+			themeErr = WrapError(ErrInvalidConfig, "invalid CAPTCHA_THEME", 400, err)
+		}
+	}
+
 	if val := os.Getenv("CAPTCHA_BACKGROUND"); val != "" {
 		config.Background = val
 	}
 
+	loadFloatFromEnv("CAPTCHA_MIN_CONTRAST", &config.MinContrast)
+
+	if val := os.Getenv("CAPTCHA_PALETTE"); val != "" {
+		config.Palette = val
+	}
+
+	if val := os.Getenv("CAPTCHA_HIGH_CONTRAST"); val != "" {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			config.HighContrast = parsed
+		}
+	}
+
+	if val := os.Getenv("CAPTCHA_TEXT_COLORS"); val != "" {
+		config.TextColors = splitColorList(val)
+	}
+
+	if val := os.Getenv("CAPTCHA_NOISE_COLORS"); val != "" {
+		config.NoiseColors = splitColorList(val)
+	}
+
 	if val := os.Getenv("CAPTCHA_IGNORE_CHARS"); val != "" {
 		config.IgnoreChars = val
 	}
 
-	return config
+	loadFloatFromEnv("CAPTCHA_DISTORT_WAVE_AMPLITUDE", &config.Distortion.WaveAmplitude)
+	loadFloatFromEnv("CAPTCHA_DISTORT_WAVE_PERIOD", &config.Distortion.WavePeriod)
+	loadFloatFromEnv("CAPTCHA_DISTORT_SKEW", &config.Distortion.Skew)
+	loadFloatFromEnv("CAPTCHA_DISTORT_SCALE", &config.Distortion.Scale)
+	loadFloatFromEnv("CAPTCHA_DISTORT_OVERLAP", &config.Distortion.Overlap)
+	loadFloatFromEnv("CAPTCHA_DISTORT_STROKE_JITTER", &config.Distortion.StrokeJitter)
+
+	loadFloatFromEnv("CAPTCHA_EFFECT_DISPLACEMENT", &config.Effects.Displacement)
+	loadFloatFromEnv("CAPTCHA_EFFECT_BASE_FREQUENCY", &config.Effects.BaseFrequency)
+	loadFloatFromEnv("CAPTCHA_EFFECT_BLUR", &config.Effects.Blur)
+
+	if val := os.Getenv("CAPTCHA_EFFECT_TARGET"); val != "" {
+		config.Effects.ApplyTo = val
+	}
+
+	if val := os.Getenv("CAPTCHA_EFFECT_GRADIENT"); val != "" {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			config.Effects.Gradient = parsed
+		}
+	}
+
+	if val := os.Getenv("CAPTCHA_EFFECT_PATTERN"); val != "" {
+		config.Effects.Pattern = val
+	}
+
+	if val := os.Getenv("CAPTCHA_MINIFY"); val != "" {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			config.Minify = parsed
+		}
+	}
+
+	if val := os.Getenv("CAPTCHA_MAX_BYTES"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			config.MaxBytes = parsed
+		}
+	}
+
+	return config, themeErr
+}
+
+// loadFloatFromEnv overwrites target with the named environment variable if it parses as a float
+func loadFloatFromEnv(name string, target *float64) {
+	if val := os.Getenv(name); val != "" {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			*target = parsed
+		}
+	}
+}
+
+// splitColorList splits a semicolon-separated list, since rgb() and hsl() colors contain commas
+func splitColorList(val string) []string {
+	var colors []string
+	for _, color := range strings.Split(val, ";") {
+		if color = strings.TrimSpace(color); color != "" {
+			colors = append(colors, color)
+		}
+	}
+	return colors
+}
+
+// Hash returns a short fingerprint of the configuration, used to correlate log records
+func (c *Config) Hash() string {
+	data, _ := json.Marshal(c)
+	sum := fnv.New64a()
+	sum.Write(data)
+	return strconv.FormatUint(sum.Sum64(), 16)
 }
 
 // Validate checks if the configuration values are valid
@@ -119,5 +271,44 @@ func (c *Config) Validate() error {
 	if c.Noise < 0 || c.Noise > 10 {
 		return &CaptchaError{Type: ErrInvalidConfig, Message: "Noise must be between 0 and 10", Code: 400}
 	}
+	if _, err := ParseColor(c.Background); err != nil {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "Background must be a valid color", Code: 400}
+	}
+	if err := validateColors(c.TextColors); err != nil {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "TextColors must be valid colors", Code: 400}
+	}
+	if err := validateColors(c.NoiseColors); err != nil {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "NoiseColors must be valid colors", Code: 400}
+	}
+	if c.MinContrast != 0 && (c.MinContrast < 1 || c.MinContrast > 21) {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "MinContrast must be 0 or between 1 and 21", Code: 400}
+	}
+	if c.Palette != "" && c.Palette != PaletteDefault && c.Palette != PaletteColorBlind {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "Palette must be default or colorblind", Code: 400}
+	}
+	if c.NoiseIntersect < 0 || c.NoiseIntersect > 1 {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "NoiseIntersect must be between 0 and 1", Code: 400}
+	}
+	if err := c.LineOpacity.Validate("LineOpacity"); err != nil {
+		return err
+	}
+	if err := c.DotOpacity.Validate("DotOpacity"); err != nil {
+		return err
+	}
+	if c.Decoys < 0 || c.Decoys > 30 {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "Decoys must be between 0 and 30", Code: 400}
+	}
+	if c.DecoyOpacity < 0 || c.DecoyOpacity > 1 {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "DecoyOpacity must be between 0 and 1", Code: 400}
+	}
+	if err := c.Distortion.Validate(); err != nil {
+		return err
+	}
+	if err := c.Effects.Validate(); err != nil {
+		return err
+	}
+	if c.MaxBytes < 0 {
+		return &CaptchaError{Type: ErrInvalidConfig, Message: "MaxBytes must be >= 0", Code: 400}
+	}
 	return nil
 }
@@ -0,0 +1,149 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image/png"
+	"strings"
+)
+
+// Output encodings for CaptchaResult.Encode and JSONOptions
+const (
+	EncodingSVG    = "svg"    // Raw SVG markup
+	EncodingBase64 = "base64" // data:image/svg+xml;base64,... URI
+	EncodingUTF8   = "utf8"   // data:image/svg+xml;charset=utf-8,... URI, usually smaller than base64
+	EncodingPNG    = "png"    // data:image/png;base64,... URI of the rasterized captcha
+)
+
+const (
+	svgMimeType = "image/svg+xml"
+	pngMimeType = "image/png"
+)
+
+// JSONOptions controls how CaptchaResult.MarshalJSONWithOptions encodes a captcha
+type JSONOptions struct {
+	Encoding   string  `json:"encoding"`   // Encoding of the data field (default: "svg")
+	PNGScale   float64 `json:"pngScale"`   // Raster scale for the "png" encoding, 0 uses 1 (default: 0)
+	OmitAnswer bool    `json:"omitAnswer"` // Leave out the text field when sending captchas to clients (default: false)
+}
+
+// Base64DataURI returns the SVG as a base64 data URI
+func (r *CaptchaResult) Base64DataURI() string {
+	return "data:" + svgMimeType + ";base64," + base64.StdEncoding.EncodeToString([]byte(r.Data))
+}
+
+// UTF8DataURI returns the SVG as a percent-encoded data URI. Whitespace between tags is dropped and
+// only characters unsafe in URLs, HTML attributes or CSS url() values, quoted or not, are escaped
+// (including spaces and parentheses), so the result is usually much smaller than base64.
+func (r *CaptchaResult) UTF8DataURI() string {
+	const prefix = "data:" + svgMimeType + ";charset=utf-8,"
+	const hex = "0123456789ABCDEF"
+	data := r.Data
+
+	var sb strings.Builder
+	sb.Grow(len(prefix) + len(data) + len(data)/8)
+	sb.WriteString(prefix)
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if isXMLSpace(c) && i > 0 && data[i-1] == '>' {
+			j := i
+			for j < len(data) && isXMLSpace(data[j]) {
+				j++
+			}
+			if j < len(data) && data[j] == '<' {
+				i = j - 1
+				continue
+			}
+		}
+		if c < 0x20 || c >= 0x7f || strings.IndexByte(` "%#'()<>{}|\^`+"`", c) >= 0 {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0x0f])
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// isXMLSpace reports whether c is XML whitespace
+func isXMLSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t'
+}
+
+// PNG rasterizes the captcha and encodes it as a PNG image
+func (r *CaptchaResult) PNG(scale float64) ([]byte, error) {
+	return r.PNGContext(context.Background(), scale)
+}
+
+// PNGContext is PNG reporting a rasterization span to the tracer in ctx
+func (r *CaptchaResult) PNGContext(ctx context.Context, scale float64) (data []byte, err error) {
+	_, span := startSpan(ctx, SpanRasterize)
+	if span.IsRecording() {
+		span.SetAttributes(Float64Attribute("captcha.png.scale", scale))
+	}
+	defer func() {
+		if err == nil && span.IsRecording() {
+			span.SetAttributes(IntAttribute("captcha.png.bytes", len(data)))
+		}
+		endSpan(span, err)
+	}()
+
+	img, err := Rasterize(r.Data, scale)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, WrapError(ErrRenderFailed, "failed to encode PNG", 500, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// PNGDataURI returns the rasterized captcha as a base64 PNG data URI
+func (r *CaptchaResult) PNGDataURI(scale float64) (string, error) {
+	data, err := r.PNG(scale)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + pngMimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Encode returns the captcha image in the given encoding; scale only applies to "png"
+func (r *CaptchaResult) Encode(encoding string, scale float64) (string, error) {
+	switch encoding {
+	case EncodingSVG, "":
+		return r.Data, nil
+	case EncodingBase64:
+		return r.Base64DataURI(), nil
+	case EncodingUTF8:
+		return r.UTF8DataURI(), nil
+	case EncodingPNG:
+		if scale == 0 {
+			scale = 1
+		}
+		return r.PNGDataURI(scale)
+	}
+	return "", NewError(ErrInvalidConfig, "unknown encoding: "+encoding, 400)
+}
+
+// MarshalJSONWithOptions encodes the result as JSON with the data field in the requested encoding
+func (r *CaptchaResult) MarshalJSONWithOptions(opts JSONOptions) ([]byte, error) {
+	data, err := r.Encode(opts.Encoding, opts.PNGScale)
+	if err != nil {
+		return nil, err
+	}
+
+	out := struct {
+		Data     string  `json:"data"`
+		Text     *string `json:"text,omitempty"`
+		Question string  `json:"question"`
+	}{Data: data, Question: r.Question}
+	if !opts.OmitAnswer {
+		out.Text = &r.Text
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,83 @@
+package captcha
+
+import "math"
+
+// decoyChars are the glyphs scattered behind the expression
+const decoyChars = "0123456789+-="
+
+// defaultDecoyOpacity is used when Config.DecoyOpacity is zero
+const defaultDecoyOpacity = 0.35
+
+// addDecoysToSVG scatters faint, smaller, rotated digits and operators behind the expression.
+// Decoys use the same font and palette as the text, faded toward the background so their
+// contrast stays well below the real characters.
+func (sr *SVGRenderer) addDecoysToSVG(svg *SVGElement, config *Config) error {
+	if config.Decoys <= 0 {
+		return nil
+	}
+
+	maxAlpha := config.DecoyOpacity
+	if maxAlpha == 0 {
+		maxAlpha = defaultDecoyOpacity
+	}
+
+	var font *glyphFont
+	if config.Distortion.Enabled() {
+		var err error
+		if font, err = defaultFont(); err != nil {
+			return err
+		}
+	}
+
+	decoys := &GroupElement{}
+	for i := 0; i < config.Decoys; i++ {
+		index, err1 := secureRandomInt(len(decoyChars))
+		x, err2 := secureRandomFloat(0, float64(sr.width))
+		y, err3 := secureRandomFloat(float64(sr.fontSize)*0.5, float64(sr.height))
+		scale, err4 := secureRandomFloat(0.5, 0.8)
+		rotation, err5 := secureRandomFloat(-45, 45)
+
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+			continue // skip this decoy if random generation fails
+		}
+
+		char := rune(decoyChars[index])
+		size := float64(sr.fontSize) * scale
+		color := sr.colorMgr.GetDecoyColor(maxAlpha)
+
+		// Match the rendering mode of the expression so decoys share its font
+		if font == nil {
+			decoys.Texts = append(decoys.Texts, &TextElement{
+				X:          x,
+				Y:          y,
+				Fill:       color,
+				FontSize:   int(math.Round(size)),
+				FontFamily: "Arial, sans-serif",
+				Transform:  formatRotate(rotation, x, y),
+				Content:    string(char),
+			})
+			continue
+		}
+
+		glyph, err := font.Glyph(char)
+		if err != nil {
+			return err
+		}
+
+		unit := size / font.unitsPerEm
+		theta := rotation * math.Pi / 180
+		transform := affine{a: unit, d: -unit}.then(affine{
+			a: math.Cos(theta), b: math.Sin(theta), c: -math.Sin(theta), d: math.Cos(theta), e: x, f: y,
+		})
+
+		var bounds Bounds
+		decoys.Paths = append(decoys.Paths, &PathElement{
+			D:    outlineToPath(glyph.Segments, transform, waveWarp{}, &bounds),
+			Fill: color,
+		})
+	}
+
+	// Decoys are drawn before the expression so they stay behind it
+	svg.Groups = append(svg.Groups, decoys)
+	return nil
+}
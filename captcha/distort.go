@@ -0,0 +1,220 @@
+package captcha
+
+import (
+	"math"
+	"strconv"
+)
+
+// DistortionConfig selects and parameterizes the OCR-resistance filters applied to glyph outlines.
+// Each filter is disabled while its value is zero; when any filter is enabled the text is
+// rendered as outline paths from the embedded font instead of SVG text elements.
+type DistortionConfig struct {
+	WaveAmplitude float64 `json:"waveAmplitude"` // Sine-wave warp amplitude along the baseline in pixels (default: 0)
+	WavePeriod    float64 `json:"wavePeriod"`    // Sine-wave period in pixels, 0 uses half the width (default: 0)
+	Skew          float64 `json:"skew"`          // Maximum per-glyph skew angle in degrees, 0-45 (default: 0)
+	Scale         float64 `json:"scale"`         // Maximum per-glyph scale deviation, 0-0.5 (default: 0)
+	Overlap       float64 `json:"overlap"`       // Fraction of each glyph advance overlapped by the next glyph, 0-0.5 (default: 0)
+	StrokeJitter  float64 `json:"strokeJitter"`  // Maximum extra stroke width added to each glyph in pixels (default: 0)
+}
+
+// Enabled reports whether any distortion filter is active
+func (d DistortionConfig) Enabled() bool {
+	return d.WaveAmplitude > 0 || d.Skew > 0 || d.Scale > 0 || d.Overlap > 0 || d.StrokeJitter > 0
+}
+
+// Validate checks if the distortion parameters are within their supported ranges
+func (d DistortionConfig) Validate() error {
+	if d.WaveAmplitude < 0 || d.WavePeriod < 0 || d.StrokeJitter < 0 {
+		return NewError(ErrInvalidConfig, "Distortion wave and stroke values must be >= 0", 400)
+	}
+	if d.Skew < 0 || d.Skew > 45 {
+		return NewError(ErrInvalidConfig, "Distortion skew must be between 0 and 45 degrees", 400)
+	}
+	if d.Scale < 0 || d.Scale > 0.5 {
+		return NewError(ErrInvalidConfig, "Distortion scale must be between 0 and 0.5", 400)
+	}
+	if d.Overlap < 0 || d.Overlap > 0.5 {
+		return NewError(ErrInvalidConfig, "Distortion overlap must be between 0 and 0.5", 400)
+	}
+	return nil
+}
+
+// affine is a 2D affine transform mapping (x, y) to (a*x + c*y + e, b*x + d*y + f)
+type affine struct {
+	a, b, c, d, e, f float64
+}
+
+// apply transforms a point
+func (m affine) apply(p point) point {
+	return point{m.a*p.X + m.c*p.Y + m.e, m.b*p.X + m.d*p.Y + m.f}
+}
+
+// then returns the transform that applies m followed by n
+func (m affine) then(n affine) affine {
+	return affine{
+		a: n.a*m.a + n.c*m.b,
+		b: n.b*m.a + n.d*m.b,
+		c: n.a*m.c + n.c*m.d,
+		d: n.b*m.c + n.d*m.d,
+		e: n.a*m.e + n.c*m.f + n.e,
+		f: n.b*m.e + n.d*m.f + n.f,
+	}
+}
+
+// translate returns a translation transform
+func translate(x, y float64) affine {
+	return affine{a: 1, d: 1, e: x, f: y}
+}
+
+// waveWarp displaces points vertically along a sine wave that follows the baseline
+type waveWarp struct {
+	amplitude float64
+	period    float64
+	phase     float64
+}
+
+// apply warps a point
+func (w waveWarp) apply(p point) point {
+	if w.amplitude == 0 || w.period == 0 {
+		return p
+	}
+	p.Y += w.amplitude * math.Sin(2*math.Pi*p.X/w.period+w.phase)
+	return p
+}
+
+// Distorter renders text as glyph outlines with the configured distortion filters applied
+type Distorter struct {
+	config DistortionConfig
+	font   *glyphFont
+}
+
+// NewDistorter creates a distorter backed by the embedded captcha font
+func NewDistorter(config DistortionConfig) (*Distorter, error) {
+	font, err := defaultFont()
+	if err != nil {
+		return nil, err
+	}
+	return &Distorter{config: config, font: font}, nil
+}
+
+// RenderText lays out text centered in a width x height canvas and returns one filled path per glyph
+// along with the bounds of the distorted outlines
+func (d *Distorter) RenderText(text string, width, height, fontSize int, colorMgr *ColorManager) ([]*PathElement, Bounds, error) {
+	scale := float64(fontSize) / d.font.unitsPerEm
+
+	// Resolve glyphs and measure the overlapped advance width
+	glyphs := make([]*glyphOutline, 0, len(text))
+	totalWidth := 0.0
+	for _, char := range text {
+		glyph, err := d.font.Glyph(char)
+		if err != nil {
+			return nil, Bounds{}, err
+		}
+		glyphs = append(glyphs, glyph)
+		totalWidth += glyph.Advance * scale * (1 - d.config.Overlap)
+	}
+
+	startX := (float64(width) - totalWidth) / 2
+	baseY := float64(height)/2 + float64(fontSize)/3 // Adjust for text baseline
+
+	yOffset, err := secureRandomFloat(-5, 5)
+	if err != nil {
+		yOffset = 0
+	}
+
+	warp := waveWarp{amplitude: d.config.WaveAmplitude, period: d.config.WavePeriod}
+	if warp.period == 0 {
+		warp.period = float64(width) / 2
+	}
+	warp.phase, _ = secureRandomFloat(0, 2*math.Pi)
+
+	var bounds Bounds
+	paths := make([]*PathElement, 0, len(glyphs))
+	penX := startX
+	for _, glyph := range glyphs {
+		advance := glyph.Advance * scale
+		if len(glyph.Segments) == 0 {
+			penX += advance * (1 - d.config.Overlap)
+			continue // Skip spaces
+		}
+
+		// Font units have Y pointing up; flip and place the glyph origin on the baseline
+		originY := baseY + yOffset
+		transform := affine{a: scale, d: -scale, e: penX, f: originY}
+		transform = transform.then(d.glyphTransform(penX+advance/2, originY-float64(fontSize)/3))
+
+		color := colorMgr.GetRandomTextRGBA()
+		path := &PathElement{
+			D:           outlineToPath(glyph.Segments, transform, warp, &bounds),
+			Fill:        color.Hex(),
+			FillOpacity: color.opacityAttr(),
+		}
+
+		if d.config.StrokeJitter > 0 {
+			strokeWidth, err := secureRandomFloat(0, d.config.StrokeJitter)
+			if err == nil && strokeWidth > 0 {
+				path.Stroke = color.Hex()
+				path.StrokeWidth = strconv.FormatFloat(strokeWidth, 'f', 2, 64)
+				path.StrokeOpacity = color.opacityAttr()
+			}
+		}
+
+		paths = append(paths, path)
+		penX += advance * (1 - d.config.Overlap)
+	}
+
+	return paths, bounds, nil
+}
+
+// glyphTransform builds the random per-glyph rotation, skew, scale and jitter around a center point
+func (d *Distorter) glyphTransform(cx, cy float64) affine {
+	rotation, _ := secureRandomFloat(-15, 15)
+	xJitter, _ := secureRandomFloat(-3, 3)
+	yJitter, _ := secureRandomFloat(-3, 3)
+
+	skew := 0.0
+	if d.config.Skew > 0 {
+		skew, _ = secureRandomFloat(-d.config.Skew, d.config.Skew)
+	}
+
+	scaleX, scaleY := 1.0, 1.0
+	if d.config.Scale > 0 {
+		scaleX, _ = secureRandomFloat(1-d.config.Scale, 1+d.config.Scale)
+		scaleY, _ = secureRandomFloat(1-d.config.Scale, 1+d.config.Scale)
+	}
+
+	theta := rotation * math.Pi / 180
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	shear := math.Tan(skew * math.Pi / 180)
+
+	m := translate(-cx, -cy)
+	m = m.then(affine{a: scaleX, d: scaleY})
+	m = m.then(affine{a: 1, c: shear, d: 1})
+	m = m.then(affine{a: cos, b: sin, c: -sin, d: cos})
+	return m.then(translate(cx+xJitter, cy+yJitter))
+}
+
+// outlineToPath converts glyph segments into SVG path data after transforming and warping each point,
+// extending bounds with every emitted point
+func outlineToPath(segments []segment, transform affine, warp waveWarp, bounds *Bounds) string {
+	d := make([]byte, 0, len(segments)*16)
+
+	for _, seg := range segments {
+		switch seg.Op {
+		case 'M', 'L':
+			p := warp.apply(transform.apply(seg.Pts[0]))
+			bounds.Extend(p.X, p.Y)
+			d = appendPoint(append(d, seg.Op), p.X, p.Y)
+		case 'Q':
+			c := warp.apply(transform.apply(seg.Pts[0]))
+			p := warp.apply(transform.apply(seg.Pts[1]))
+			bounds.Extend(p.X, p.Y)
+			d = appendPoint(append(d, 'Q'), c.X, c.Y)
+			d = appendPoint(append(d, ' '), p.X, p.Y)
+		case 'Z':
+			d = append(d, 'Z')
+		}
+	}
+
+	return string(d)
+}
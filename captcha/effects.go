@@ -0,0 +1,228 @@
+package captcha
+
+import (
+	"fmt"
+)
+
+// Filter targets for EffectsConfig.ApplyTo
+const (
+	EffectsTargetText       = "text"
+	EffectsTargetBackground = "background"
+	EffectsTargetBoth       = "both"
+)
+
+// Background patterns for EffectsConfig.Pattern
+const (
+	PatternNone    = ""
+	PatternDots    = "dots"
+	PatternStripes = "stripes"
+	PatternGrid    = "grid"
+)
+
+// EffectsConfig controls SVG filter effects and decorated backgrounds.
+// Filters are disabled while their strength is zero.
+type EffectsConfig struct {
+	Displacement  float64 `json:"displacement"`  // Turbulence displacement scale in pixels, 0-20 (default: 0)
+	BaseFrequency float64 `json:"baseFrequency"` // Turbulence base frequency, 0 uses 0.05 (default: 0)
+	Blur          float64 `json:"blur"`          // Gaussian blur standard deviation, 0-5 (default: 0)
+	ApplyTo       string  `json:"applyTo"`       // Filter target: "text", "background" or "both" (default: "text")
+	Gradient      bool    `json:"gradient"`      // Fill the background with a random linear gradient (default: false)
+	Pattern       string  `json:"pattern"`       // Background pattern: "", "dots", "stripes" or "grid" (default: "")
+}
+
+// HasFilter reports whether any filter primitive is enabled
+func (e EffectsConfig) HasFilter() bool {
+	return e.Displacement > 0 || e.Blur > 0
+}
+
+// filtersText reports whether filters apply to the text layer
+func (e EffectsConfig) filtersText() bool {
+	return e.HasFilter() && (e.ApplyTo == "" || e.ApplyTo == EffectsTargetText || e.ApplyTo == EffectsTargetBoth)
+}
+
+// filtersBackground reports whether filters apply to the background layer
+func (e EffectsConfig) filtersBackground() bool {
+	return e.HasFilter() && (e.ApplyTo == EffectsTargetBackground || e.ApplyTo == EffectsTargetBoth)
+}
+
+// Validate checks if the effect parameters are within their supported ranges
+func (e EffectsConfig) Validate() error {
+	if e.Displacement < 0 || e.Displacement > 20 {
+		return NewError(ErrInvalidConfig, "Effects displacement must be between 0 and 20", 400)
+	}
+	if e.BaseFrequency < 0 || e.BaseFrequency > 1 {
+		return NewError(ErrInvalidConfig, "Effects base frequency must be between 0 and 1", 400)
+	}
+	if e.Blur < 0 || e.Blur > 5 {
+		return NewError(ErrInvalidConfig, "Effects blur must be between 0 and 5", 400)
+	}
+	switch e.ApplyTo {
+	case "", EffectsTargetText, EffectsTargetBackground, EffectsTargetBoth:
+	default:
+		return NewError(ErrInvalidConfig, "Effects target must be text, background or both", 400)
+	}
+	switch e.Pattern {
+	case PatternNone, PatternDots, PatternStripes, PatternGrid:
+	default:
+		return NewError(ErrInvalidConfig, "Effects pattern must be dots, stripes or grid", 400)
+	}
+	return nil
+}
+
+// addEffectsToSVG decorates the background and wraps filtered layers in groups
+func (sr *SVGRenderer) addEffectsToSVG(svg *SVGElement, config *Config) {
+	effects := config.Effects
+	if !effects.HasFilter() && !effects.Gradient && effects.Pattern == PatternNone {
+		return
+	}
+
+	// IDs are randomized so several captchas can be inlined in one HTML document
+	prefix := randomElementID()
+	defs := &DefsElement{}
+	background := &GroupElement{Rects: []*RectElement{svg.Background}}
+	svg.Background = nil
+
+	if effects.Gradient {
+		gradient := sr.newBackgroundGradient(prefix+"-bg", config.Background)
+		defs.LinearGradients = append(defs.LinearGradients, gradient)
+		background.Rects[0].Fill = "url(#" + gradient.ID + ")"
+	}
+
+	if effects.Pattern != PatternNone {
+		pattern := sr.newBackgroundPattern(prefix+"-pattern", effects.Pattern)
+		defs.Patterns = append(defs.Patterns, pattern)
+		background.Rects = append(background.Rects, &RectElement{
+			Width:  sr.width,
+			Height: sr.height,
+			Fill:   "url(#" + pattern.ID + ")",
+		})
+	}
+
+	if effects.HasFilter() {
+		filter := newNoiseFilter(prefix+"-filter", effects)
+		defs.Filters = append(defs.Filters, filter)
+
+		if effects.filtersBackground() {
+			background.Filter = "url(#" + filter.ID + ")"
+		}
+	}
+
+	svg.Defs = defs
+	svg.Groups = append([]*GroupElement{background}, svg.Groups...)
+
+	if effects.filtersText() {
+		svg.Groups = append(svg.Groups, &GroupElement{
+			Filter: "url(#" + prefix + "-filter)",
+			Texts:  svg.Texts,
+			Paths:  svg.Paths,
+		})
+		svg.Texts = nil
+		svg.Paths = nil
+	}
+}
+
+// newNoiseFilter builds a turbulence displacement and blur filter chain
+func newNoiseFilter(id string, effects EffectsConfig) *FilterElement {
+	// Enlarge the filter region so displaced glyphs are not clipped
+	filter := &FilterElement{ID: id, X: "-10%", Y: "-10%", Width: "120%", Height: "120%"}
+	input := "SourceGraphic"
+
+	if effects.Displacement > 0 {
+		frequency := effects.BaseFrequency
+		if frequency == 0 {
+			frequency = 0.05
+		}
+		seed, err := secureRandomInt(10000)
+		if err != nil {
+			seed = 0
+		}
+		filter.Turbulence = &TurbulenceElement{
+			Type:          "turbulence",
+			BaseFrequency: fmt.Sprintf("%.3g", frequency),
+			NumOctaves:    2,
+			Seed:          seed,
+			Result:        "turbulence",
+		}
+		filter.Displacement = &DisplacementMapElement{
+			In:               "SourceGraphic",
+			In2:              "turbulence",
+			Scale:            fmt.Sprintf("%.3g", effects.Displacement),
+			XChannelSelector: "R",
+			YChannelSelector: "G",
+			Result:           "displaced",
+		}
+		input = "displaced"
+	}
+
+	if effects.Blur > 0 {
+		filter.Blur = &GaussianBlurElement{
+			In:           input,
+			StdDeviation: fmt.Sprintf("%.3g", effects.Blur),
+		}
+	}
+
+	return filter
+}
+
+// newBackgroundGradient builds a linear gradient from the background color to a random noise color
+func (sr *SVGRenderer) newBackgroundGradient(id, background string) *LinearGradientElement {
+	x1, _ := secureRandomInt(101)
+	y1, _ := secureRandomInt(101)
+
+	return &LinearGradientElement{
+		ID: id,
+		X1: fmt.Sprintf("%d%%", x1),
+		Y1: fmt.Sprintf("%d%%", y1),
+		X2: fmt.Sprintf("%d%%", 100-x1),
+		Y2: fmt.Sprintf("%d%%", 100-y1),
+		Stops: []*StopElement{
+			{Offset: "0%", StopColor: background},
+			{Offset: "100%", StopColor: sr.colorMgr.GetRandomNoiseColor()},
+		},
+	}
+}
+
+// newBackgroundPattern builds a repeating tile of noise-colored dots, stripes or grid lines
+func (sr *SVGRenderer) newBackgroundPattern(id, kind string) *PatternElement {
+	size, err := secureRandomFloat(6, 12)
+	if err != nil {
+		size = 8
+	}
+	angle, _ := secureRandomFloat(-45, 45)
+
+	pattern := &PatternElement{
+		ID:               id,
+		Width:            size,
+		Height:           size,
+		PatternUnits:     "userSpaceOnUse",
+		PatternTransform: fmt.Sprintf("rotate(%.1f)", angle),
+	}
+
+	color := sr.colorMgr.GetRandomNoiseColor()
+	switch kind {
+	case PatternDots:
+		pattern.Circles = append(pattern.Circles, &CircleElement{
+			CX: size / 2, CY: size / 2, R: size / 6, Fill: color,
+		})
+	case PatternStripes:
+		pattern.Paths = append(pattern.Paths, &PathElement{
+			D: fmt.Sprintf("M0,%.2f H%.2f", size/2, size), Fill: "none", Stroke: color, StrokeWidth: "1",
+		})
+	case PatternGrid:
+		pattern.Paths = append(pattern.Paths, &PathElement{
+			D:    fmt.Sprintf("M0,0 H%.2f M0,0 V%.2f", size, size),
+			Fill: "none", Stroke: color, StrokeWidth: "0.5",
+		})
+	}
+
+	return pattern
+}
+
+// randomElementID returns a short random identifier for SVG definitions
+func randomElementID() string {
+	n, err := secureRandomInt(1 << 24)
+	if err != nil {
+		n = 0
+	}
+	return fmt.Sprintf("c%06x", n)
+}
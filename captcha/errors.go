@@ -1,6 +1,11 @@
 package captcha
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
 
 // Error type constants
 const (
@@ -9,6 +14,25 @@ const (
 	ErrSVGGeneration  = "SVG_GENERATION_FAILED"
 	ErrFontLoadFailed = "FONT_LOAD_FAILED"
 	ErrRenderFailed   = "RENDER_FAILED"
+
+	ErrSizeBudgetExceeded = "SIZE_BUDGET_EXCEEDED"
+	ErrInvalidRequest     = "INVALID_REQUEST"
+	ErrVerificationFailed = "VERIFICATION_FAILED"
+	ErrRateLimited        = "RATE_LIMITED"
+)
+
+// Sentinel errors, one per error type. Every CaptchaError matches the sentinel of its type with
+// errors.Is, for example errors.Is(err, ErrorInvalidConfig).
+var (
+	ErrorInvalidConfig      = NewError(ErrInvalidConfig, "invalid configuration", 400)
+	ErrorMathGeneration     = NewError(ErrMathGeneration, "math generation failed", 500)
+	ErrorSVGGeneration      = NewError(ErrSVGGeneration, "SVG generation failed", 500)
+	ErrorFontLoadFailed     = NewError(ErrFontLoadFailed, "font loading failed", 500)
+	ErrorRenderFailed       = NewError(ErrRenderFailed, "rendering failed", 500)
+	ErrorSizeBudgetExceeded = NewError(ErrSizeBudgetExceeded, "size budget exceeded", 500)
+	ErrorInvalidRequest     = NewError(ErrInvalidRequest, "invalid request", 400)
+	ErrorVerificationFailed = NewError(ErrVerificationFailed, "captcha verification failed", 403)
+	ErrorRateLimited        = NewError(ErrRateLimited, "rate limit exceeded", 429)
 )
 
 // CaptchaError represents an error that occurred during captcha generation
@@ -16,13 +40,35 @@ type CaptchaError struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 	Code    int    `json:"code"`
+	// Cause is the underlying error, if any, available through errors.Unwrap
+	Cause error `json:"-"`
+	// Debug holds diagnostics such as the stack of a recovered panic. It is meant for logs
+	// and never serialized.
+	Debug string `json:"-"`
+	// RetryAfter is how long a rate-limited client should wait, sent as the Retry-After header
+	// of problem responses
+	RetryAfter time.Duration `json:"-"`
 }
 
 // Error implements the error interface
 func (e *CaptchaError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("[%s] %s: %v (code: %d)", e.Type, e.Message, e.Cause, e.Code)
+	}
 	return fmt.Sprintf("[%s] %s (code: %d)", e.Type, e.Message, e.Code)
 }
 
+// Unwrap returns the underlying error
+func (e *CaptchaError) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is a CaptchaError of the same type, so errors match their sentinel
+func (e *CaptchaError) Is(target error) bool {
+	t, ok := target.(*CaptchaError)
+	return ok && t.Type == e.Type
+}
+
 // NewError creates a new CaptchaError
 func NewError(errorType, message string, code int) *CaptchaError {
 	return &CaptchaError{
@@ -31,3 +77,25 @@ func NewError(errorType, message string, code int) *CaptchaError {
 		Code:    code,
 	}
 }
+
+// WrapError creates a new CaptchaError caused by cause
+func WrapError(errorType, message string, code int, cause error) *CaptchaError {
+	err := NewError(errorType, message, code)
+	err.Cause = cause
+	return err
+}
+
+// AsCaptchaError finds the first CaptchaError in err's chain
+func AsCaptchaError(err error) (*CaptchaError, bool) {
+	var captchaErr *CaptchaError
+	ok := errors.As(err, &captchaErr)
+	return captchaErr, ok
+}
+
+// newPanicError converts a value recovered from a panic into an ErrRenderFailed error carrying
+// the stack of the panicking goroutine
+func newPanicError(recovered any) *CaptchaError {
+	err := NewError(ErrRenderFailed, fmt.Sprintf("captcha generation panicked: %v", recovered), 500)
+	err.Debug = string(debug.Stack())
+	return err
+}
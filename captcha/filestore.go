@@ -0,0 +1,149 @@
+package captcha
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileStore is a Store keeping one JSON file per captcha in a directory, so several processes on
+// one host can share issued captchas
+type FileStore struct {
+	dir   string
+	count atomic.Int64 // Approximate number of entries, recounted by Cleanup
+}
+
+// NewFileStore creates a store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	fs := &FileStore{dir: dir}
+	fs.walk(func(string) { fs.count.Add(1) })
+	return fs, nil
+}
+
+// Set writes the entry under id, replacing any previous entry
+func (fs *FileStore) Set(id string, entry StoreEntry) error {
+	if !validStoreID(id) {
+		return NewError(ErrInvalidRequest, "invalid captcha ID", 400)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so readers never see a partial entry
+	tmp, err := os.CreateTemp(fs.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	_, statErr := os.Stat(fs.path(id))
+	if err := os.Rename(tmp.Name(), fs.path(id)); err != nil {
+		return err
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		fs.count.Add(1)
+	}
+	return nil
+}
+
+// Take returns and removes the entry stored under id. The file is renamed before it is read, so
+// of several concurrent takers only one gets the entry.
+func (fs *FileStore) Take(id string) (StoreEntry, bool, error) {
+	if !validStoreID(id) {
+		return StoreEntry{}, false, nil
+	}
+
+	taken := fs.path(id) + ".taken"
+	if err := os.Rename(fs.path(id), taken); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return StoreEntry{}, false, nil
+		}
+		return StoreEntry{}, false, err
+	}
+	defer os.Remove(taken)
+	fs.count.Add(-1)
+
+	data, err := os.ReadFile(taken)
+	if err != nil {
+		return StoreEntry{}, false, err
+	}
+	var entry StoreEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return StoreEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet cleaned up. It is
+// counted in memory rather than by listing the directory, so it misses entries set or taken by
+// other processes until the next Cleanup recounts them.
+func (fs *FileStore) Len() int {
+	return int(max(fs.count.Load(), 0))
+}
+
+// Cleanup removes entries that expired before now and returns how many were removed
+func (fs *FileStore) Cleanup(now time.Time) int {
+	removed, kept := 0, 0
+	fs.walk(func(path string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		var entry StoreEntry
+		if json.Unmarshal(data, &entry) == nil && entry.Expired(now) && os.Remove(path) == nil {
+			removed++
+			return
+		}
+		kept++
+	})
+	fs.count.Store(int64(kept))
+	return removed
+}
+
+// path returns the file of the entry stored under id
+func (fs *FileStore) path(id string) string {
+	return filepath.Join(fs.dir, id+".json")
+}
+
+// walk calls fn with the path of every entry file
+func (fs *FileStore) walk(fn func(path string)) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") && !strings.HasPrefix(entry.Name(), ".") {
+			fn(filepath.Join(fs.dir, entry.Name()))
+		}
+	}
+}
+
+// validStoreID reports whether id is safe to use as a file name: 1-128 letters, digits, '-'
+// or '_'
+func validStoreID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
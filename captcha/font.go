@@ -0,0 +1,489 @@
+package captcha
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+//go:embed fonts/Comismsh.ttf
+var comismshTTF []byte
+
+// point is a 2D coordinate in SVG user space
+type point struct {
+	X, Y float64
+}
+
+// segment is a single outline command: 'M' (move), 'L' (line), 'Q' (quadratic curve) or 'Z' (close)
+type segment struct {
+	Op  byte
+	Pts [2]point // Pts[0] is the control point for 'Q', otherwise the end point
+}
+
+// glyphOutline is a glyph's contours in font units with the Y axis pointing up
+type glyphOutline struct {
+	Segments []segment
+	Advance  float64
+}
+
+// glyphFont is a minimal TrueType reader that extracts glyph outlines
+type glyphFont struct {
+	unitsPerEm  float64
+	ascent      float64
+	descent     float64
+	longLoca    bool
+	numHMetrics int
+	tables      map[string][]byte
+	cmap        map[rune]uint16
+	cache       sync.Map // rune -> *glyphOutline
+}
+
+// defaultFont lazily parses the embedded captcha font
+var defaultFont = sync.OnceValues(func() (*glyphFont, error) {
+	return parseFont(comismshTTF)
+})
+
+// parseFont reads the tables needed for outline extraction from a TrueType file
+func parseFont(data []byte) (*glyphFont, error) {
+	if len(data) < 12 {
+		return nil, NewError(ErrFontLoadFailed, "font data too short", 500)
+	}
+
+	f := &glyphFont{tables: make(map[string][]byte)}
+	numTables := int(binary.BigEndian.Uint16(data[4:6]))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, NewError(ErrFontLoadFailed, "truncated table directory", 500)
+		}
+		tag := string(data[rec : rec+4])
+		offset := int(binary.BigEndian.Uint32(data[rec+8 : rec+12]))
+		length := int(binary.BigEndian.Uint32(data[rec+12 : rec+16]))
+		if offset+length > len(data) {
+			return nil, NewError(ErrFontLoadFailed, fmt.Sprintf("table %q out of bounds", tag), 500)
+		}
+		f.tables[tag] = data[offset : offset+length]
+	}
+
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap", "loca", "glyf"} {
+		if _, ok := f.tables[tag]; !ok {
+			return nil, NewError(ErrFontLoadFailed, fmt.Sprintf("missing %q table", tag), 500)
+		}
+	}
+
+	head := f.tables["head"]
+	hhea := f.tables["hhea"]
+	if len(head) < 54 || len(hhea) < 36 {
+		return nil, NewError(ErrFontLoadFailed, "malformed head or hhea table", 500)
+	}
+	f.unitsPerEm = float64(binary.BigEndian.Uint16(head[18:20]))
+	f.longLoca = int16(binary.BigEndian.Uint16(head[50:52])) == 1
+	f.ascent = float64(int16(binary.BigEndian.Uint16(hhea[4:6])))
+	f.descent = float64(int16(binary.BigEndian.Uint16(hhea[6:8])))
+	f.numHMetrics = int(binary.BigEndian.Uint16(hhea[34:36]))
+
+	cmap, err := parseCmap(f.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	f.cmap = cmap
+
+	return f, nil
+}
+
+// parseCmap reads the first Unicode format 4 subtable into a rune -> glyph index map
+func parseCmap(table []byte) (map[rune]uint16, error) {
+	if len(table) < 4 {
+		return nil, NewError(ErrFontLoadFailed, "malformed cmap table", 500)
+	}
+
+	numSubtables := int(binary.BigEndian.Uint16(table[2:4]))
+	for i := 0; i < numSubtables; i++ {
+		rec := 4 + 8*i
+		if rec+8 > len(table) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(table[rec : rec+2])
+		encoding := binary.BigEndian.Uint16(table[rec+2 : rec+4])
+		offset := int(binary.BigEndian.Uint32(table[rec+4 : rec+8]))
+		if !(platform == 0 || (platform == 3 && encoding == 1)) || offset+4 > len(table) {
+			continue
+		}
+		if binary.BigEndian.Uint16(table[offset:offset+2]) == 4 {
+			return parseCmapFormat4(table[offset:])
+		}
+	}
+
+	return nil, NewError(ErrFontLoadFailed, "no supported cmap subtable", 500)
+}
+
+// parseCmapFormat4 decodes a segment mapping to delta values subtable
+func parseCmapFormat4(sub []byte) (map[rune]uint16, error) {
+	if len(sub) < 14 {
+		return nil, NewError(ErrFontLoadFailed, "malformed cmap format 4", 500)
+	}
+
+	segCount := int(binary.BigEndian.Uint16(sub[6:8])) / 2
+	endOff := 14
+	startOff := endOff + 2*segCount + 2
+	deltaOff := startOff + 2*segCount
+	rangeOff := deltaOff + 2*segCount
+	if rangeOff+2*segCount > len(sub) {
+		return nil, NewError(ErrFontLoadFailed, "truncated cmap format 4", 500)
+	}
+
+	u16 := func(off int) uint16 { return binary.BigEndian.Uint16(sub[off : off+2]) }
+	result := make(map[rune]uint16)
+	for s := 0; s < segCount; s++ {
+		end := u16(endOff + 2*s)
+		start := u16(startOff + 2*s)
+		delta := u16(deltaOff + 2*s)
+		idRangeOffset := int(u16(rangeOff + 2*s))
+
+		for c := uint32(start); c <= uint32(end) && c != 0xFFFF; c++ {
+			var glyph uint16
+			if idRangeOffset == 0 {
+				glyph = uint16(c) + delta
+			} else {
+				off := rangeOff + 2*s + idRangeOffset + 2*int(c-uint32(start))
+				if off+2 > len(sub) {
+					continue
+				}
+				glyph = u16(off)
+				if glyph != 0 {
+					glyph += delta
+				}
+			}
+			if glyph != 0 {
+				result[rune(c)] = glyph
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Glyph returns the outline for a rune, or an error if the font does not contain it
+func (f *glyphFont) Glyph(r rune) (*glyphOutline, error) {
+	if cached, ok := f.cache.Load(r); ok {
+		return cached.(*glyphOutline), nil
+	}
+
+	index, ok := f.cmap[r]
+	if !ok {
+		return nil, NewError(ErrFontLoadFailed, fmt.Sprintf("glyph not found for %q", r), 500)
+	}
+
+	segments, err := f.loadGlyph(index, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	outline := &glyphOutline{Segments: segments, Advance: f.advance(index)}
+	f.cache.Store(r, outline)
+	return outline, nil
+}
+
+// advance returns the horizontal advance width of a glyph in font units
+func (f *glyphFont) advance(index uint16) float64 {
+	hmtx := f.tables["hmtx"]
+	i := int(index)
+	if i >= f.numHMetrics {
+		i = f.numHMetrics - 1
+	}
+	if 4*i+2 > len(hmtx) || i < 0 {
+		return f.unitsPerEm / 2
+	}
+	return float64(binary.BigEndian.Uint16(hmtx[4*i : 4*i+2]))
+}
+
+// glyphData returns the raw glyf entry for a glyph index
+func (f *glyphFont) glyphData(index uint16) ([]byte, error) {
+	loca := f.tables["loca"]
+	glyf := f.tables["glyf"]
+	i := int(index)
+
+	var start, end int
+	if f.longLoca {
+		if 4*i+8 > len(loca) {
+			return nil, NewError(ErrFontLoadFailed, "glyph index out of range", 500)
+		}
+		start = int(binary.BigEndian.Uint32(loca[4*i:]))
+		end = int(binary.BigEndian.Uint32(loca[4*i+4:]))
+	} else {
+		if 2*i+4 > len(loca) {
+			return nil, NewError(ErrFontLoadFailed, "glyph index out of range", 500)
+		}
+		start = 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+		end = 2 * int(binary.BigEndian.Uint16(loca[2*i+2:]))
+	}
+
+	if start > end || end > len(glyf) {
+		return nil, NewError(ErrFontLoadFailed, "glyph data out of bounds", 500)
+	}
+	return glyf[start:end], nil
+}
+
+// loadGlyph decodes a simple or composite glyph into outline segments
+func (f *glyphFont) loadGlyph(index uint16, depth int) ([]segment, error) {
+	if depth > 8 {
+		return nil, NewError(ErrFontLoadFailed, "composite glyph nesting too deep", 500)
+	}
+
+	data, err := f.glyphData(index)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil // empty glyph such as space
+	}
+	if len(data) < 10 {
+		return nil, NewError(ErrFontLoadFailed, "malformed glyph header", 500)
+	}
+
+	numContours := int16(binary.BigEndian.Uint16(data[0:2]))
+	if numContours >= 0 {
+		return parseSimpleGlyph(data, int(numContours))
+	}
+	return f.parseCompositeGlyph(data, depth)
+}
+
+// TrueType simple glyph flags
+const (
+	flagOnCurve  = 0x01
+	flagXShort   = 0x02
+	flagYShort   = 0x04
+	flagRepeat   = 0x08
+	flagXSamePos = 0x10
+	flagYSamePos = 0x20
+)
+
+// parseSimpleGlyph decodes contour points and converts them into line and quadratic segments
+func parseSimpleGlyph(data []byte, numContours int) ([]segment, error) {
+	errTruncated := NewError(ErrFontLoadFailed, "truncated simple glyph", 500)
+
+	pos := 10
+	if pos+2*numContours+2 > len(data) {
+		return nil, errTruncated
+	}
+	endPts := make([]int, numContours)
+	for i := range endPts {
+		endPts[i] = int(binary.BigEndian.Uint16(data[pos:]))
+		pos += 2
+	}
+	numPoints := 0
+	if numContours > 0 {
+		numPoints = endPts[numContours-1] + 1
+	}
+
+	instructionLength := int(binary.BigEndian.Uint16(data[pos:]))
+	pos += 2 + instructionLength
+
+	flags := make([]byte, 0, numPoints)
+	for len(flags) < numPoints {
+		if pos >= len(data) {
+			return nil, errTruncated
+		}
+		flag := data[pos]
+		pos++
+		flags = append(flags, flag)
+		if flag&flagRepeat != 0 {
+			if pos >= len(data) {
+				return nil, errTruncated
+			}
+			repeat := int(data[pos])
+			pos++
+			for r := 0; r < repeat && len(flags) < numPoints; r++ {
+				flags = append(flags, flag)
+			}
+		}
+	}
+
+	readCoords := func(short, same byte) ([]float64, error) {
+		coords := make([]float64, numPoints)
+		value := 0
+		for i, flag := range flags {
+			switch {
+			case flag&short != 0:
+				if pos >= len(data) {
+					return nil, errTruncated
+				}
+				delta := int(data[pos])
+				pos++
+				if flag&same == 0 {
+					delta = -delta
+				}
+				value += delta
+			case flag&same == 0:
+				if pos+2 > len(data) {
+					return nil, errTruncated
+				}
+				value += int(int16(binary.BigEndian.Uint16(data[pos:])))
+				pos += 2
+			}
+			coords[i] = float64(value)
+		}
+		return coords, nil
+	}
+
+	xs, err := readCoords(flagXShort, flagXSamePos)
+	if err != nil {
+		return nil, err
+	}
+	ys, err := readCoords(flagYShort, flagYSamePos)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	start := 0
+	for _, end := range endPts {
+		if end < start || end >= numPoints {
+			return nil, errTruncated
+		}
+		segments = appendContour(segments, xs[start:end+1], ys[start:end+1], flags[start:end+1])
+		start = end + 1
+	}
+
+	return segments, nil
+}
+
+// appendContour converts one TrueType contour with implied on-curve points into segments
+func appendContour(segments []segment, xs, ys []float64, flags []byte) []segment {
+	n := len(xs)
+	if n == 0 {
+		return segments
+	}
+
+	onCurve := func(i int) bool { return flags[i%n]&flagOnCurve != 0 }
+	pt := func(i int) point { return point{xs[i%n], ys[i%n]} }
+	mid := func(a, b point) point { return point{(a.X + b.X) / 2, (a.Y + b.Y) / 2} }
+
+	// Start at an on-curve point, synthesizing one if every point is off-curve
+	startPt := mid(pt(n-1), pt(0))
+	offset := 0
+	for i := 0; i < n; i++ {
+		if onCurve(i) {
+			startPt = pt(i)
+			offset = i + 1
+			break
+		}
+	}
+
+	segments = append(segments, segment{Op: 'M', Pts: [2]point{startPt}})
+
+	var control *point
+	for k := 0; k < n; k++ {
+		i := offset + k
+		p := pt(i)
+		if onCurve(i) {
+			if control != nil {
+				segments = append(segments, segment{Op: 'Q', Pts: [2]point{*control, p}})
+				control = nil
+			} else {
+				segments = append(segments, segment{Op: 'L', Pts: [2]point{p}})
+			}
+			continue
+		}
+		if control != nil {
+			m := mid(*control, p)
+			segments = append(segments, segment{Op: 'Q', Pts: [2]point{*control, m}})
+		}
+		c := p
+		control = &c
+	}
+	if control != nil {
+		segments = append(segments, segment{Op: 'Q', Pts: [2]point{*control, startPt}})
+	}
+
+	return append(segments, segment{Op: 'Z'})
+}
+
+// TrueType composite glyph flags
+const (
+	compositeArgWords     = 0x0001
+	compositeArgsXY       = 0x0002
+	compositeHaveScale    = 0x0008
+	compositeMore         = 0x0020
+	compositeHaveXYScale  = 0x0040
+	compositeHaveTwoByTwo = 0x0080
+)
+
+// parseCompositeGlyph combines component glyphs, applying their offsets and scales
+func (f *glyphFont) parseCompositeGlyph(data []byte, depth int) ([]segment, error) {
+	errTruncated := NewError(ErrFontLoadFailed, "truncated composite glyph", 500)
+	f2dot14 := func(off int) float64 { return float64(int16(binary.BigEndian.Uint16(data[off:]))) / 16384 }
+
+	var segments []segment
+	pos := 10
+	for {
+		if pos+4 > len(data) {
+			return nil, errTruncated
+		}
+		flags := binary.BigEndian.Uint16(data[pos:])
+		component := binary.BigEndian.Uint16(data[pos+2:])
+		pos += 4
+
+		var dx, dy float64
+		if flags&compositeArgWords != 0 {
+			if pos+4 > len(data) {
+				return nil, errTruncated
+			}
+			dx = float64(int16(binary.BigEndian.Uint16(data[pos:])))
+			dy = float64(int16(binary.BigEndian.Uint16(data[pos+2:])))
+			pos += 4
+		} else {
+			if pos+2 > len(data) {
+				return nil, errTruncated
+			}
+			dx = float64(int8(data[pos]))
+			dy = float64(int8(data[pos+1]))
+			pos += 2
+		}
+		if flags&compositeArgsXY == 0 {
+			dx, dy = 0, 0 // point matching is not supported
+		}
+
+		a, b, c, d := 1.0, 0.0, 0.0, 1.0
+		switch {
+		case flags&compositeHaveScale != 0:
+			if pos+2 > len(data) {
+				return nil, errTruncated
+			}
+			a = f2dot14(pos)
+			d = a
+			pos += 2
+		case flags&compositeHaveXYScale != 0:
+			if pos+4 > len(data) {
+				return nil, errTruncated
+			}
+			a, d = f2dot14(pos), f2dot14(pos+2)
+			pos += 4
+		case flags&compositeHaveTwoByTwo != 0:
+			if pos+8 > len(data) {
+				return nil, errTruncated
+			}
+			a, b, c, d = f2dot14(pos), f2dot14(pos+2), f2dot14(pos+4), f2dot14(pos+6)
+			pos += 8
+		}
+
+		componentSegments, err := f.loadGlyph(component, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, seg := range componentSegments {
+			for i := range seg.Pts {
+				p := seg.Pts[i]
+				seg.Pts[i] = point{a*p.X + c*p.Y + dx, b*p.X + d*p.Y + dy}
+			}
+			segments = append(segments, seg)
+		}
+
+		if flags&compositeMore == 0 {
+			break
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,28 @@
+package captcha
+
+import "strconv"
+
+// appendPoint appends "x,y" with two decimals, the coordinate format of all generated path data
+func appendPoint(dst []byte, x, y float64) []byte {
+	dst = strconv.AppendFloat(dst, x, 'f', 2, 64)
+	dst = append(dst, ',')
+	return strconv.AppendFloat(dst, y, 'f', 2, 64)
+}
+
+// formatRotate returns "rotate(angle cx cy)" with one decimal for the angle and two for the center
+func formatRotate(angle, cx, cy float64) string {
+	var buf [48]byte
+	b := append(buf[:0], "rotate("...)
+	b = strconv.AppendFloat(b, angle, 'f', 1, 64)
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, cx, 'f', 2, 64)
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, cy, 'f', 2, 64)
+	b = append(b, ')')
+	return string(b)
+}
+
+// formatStrokeWidth formats a stroke width with up to five significant digits
+func formatStrokeWidth(width float64) string {
+	return strconv.FormatFloat(width, 'g', 5, 64)
+}
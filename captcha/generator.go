@@ -1,9 +1,12 @@
 package captcha
 
 import (
-	"log"
+	"context"
+	"io"
+	"log/slog"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // CaptchaResult represents the result of captcha generation
@@ -15,31 +18,43 @@ type CaptchaResult struct {
 
 // CaptchaGenerator is the main engine for generating captchas
 type CaptchaGenerator struct {
-	config      *Config
-	mathGen     *MathExpressionGenerator
-	svgRenderer *SVGRenderer
-	noiseGen    *NoiseGenerator
-	mutex       sync.RWMutex
+	config   *Config
+	mathGen  *MathExpressionGenerator
+	renderer Renderer
+	noiseGen *NoiseGenerator
+	metrics  Metrics
+	logger   *slog.Logger
+	tracer   Tracer
+	mutex    sync.RWMutex
 }
 
-// NewCaptchaGenerator creates a new captcha generator with the given configuration
-func NewCaptchaGenerator(config *Config) *CaptchaGenerator {
+// NewCaptchaGenerator creates a new captcha generator with the given configuration and options
+func NewCaptchaGenerator(config *Config, opts ...Option) *CaptchaGenerator {
+	cg := &CaptchaGenerator{
+		noiseGen: NewNoiseGenerator(),
+		logger:   discardLogger,
+	}
+	for _, opt := range opts {
+		opt(cg)
+	}
+
 	if config == nil {
 		config = DefaultConfig()
 	}
 
 	// Validate configuration
 	if err := config.Validate(); err != nil {
-		log.Printf("Warning: Invalid configuration, using defaults: %v", err)
+		cg.logger.LogAttrs(context.Background(), slog.LevelWarn, "invalid captcha configuration, using defaults",
+			slog.String("error_type", errorType(err)), slog.String("error", err.Error()))
 		config = DefaultConfig()
 	}
 
-	return &CaptchaGenerator{
-		config:      config,
-		mathGen:     NewMathExpressionGenerator(config),
-		svgRenderer: NewSVGRenderer(config),
-		noiseGen:    NewNoiseGenerator(),
+	cg.config = config
+	cg.mathGen = NewMathExpressionGenerator(config)
+	if cg.renderer == nil {
+		cg.renderer = NewSVGRenderer(config)
 	}
+	return cg
 }
 
 // CreateMathExpr generates a math expression captcha with default settings
@@ -49,9 +64,26 @@ func (cg *CaptchaGenerator) CreateMathExpr() (*CaptchaResult, error) {
 
 // CreateMathExprWithOptions generates a math expression captcha with custom configuration
 func (cg *CaptchaGenerator) CreateMathExprWithOptions(opts *Config) (*CaptchaResult, error) {
+	return cg.CreateMathExprContext(context.Background(), opts)
+}
+
+// CreateMathExprContext generates a captcha with opts, or the generator's configuration if opts
+// is nil, reporting spans as children of any span in ctx
+func (cg *CaptchaGenerator) CreateMathExprContext(ctx context.Context, opts *Config) (*CaptchaResult, error) {
+	start := time.Now()
+	ctx, span := cg.startGenerationSpan(ctx, opts)
+	result, err := cg.createMathExpr(ctx, opts)
+	endSpan(span, err)
+	cg.finishGeneration(start, opts, err)
+	return result, err
+}
+
+// createMathExpr generates and renders a captcha with opts. A panic while generating is
+// returned as an ErrRenderFailed error rather than crashing the caller.
+func (cg *CaptchaGenerator) createMathExpr(ctx context.Context, opts *Config) (result *CaptchaResult, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Captcha generation panic recovered: %v", r)
+			result, err = nil, newPanicError(r)
 		}
 	}()
 
@@ -65,19 +97,29 @@ func (cg *CaptchaGenerator) CreateMathExprWithOptions(opts *Config) (*CaptchaRes
 	}
 
 	// Generate math expression
-	expr, err := cg.mathGen.GenerateExpression()
+	expr, err := cg.generateExpression(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create temporary renderer with new options if different
-	renderer := cg.svgRenderer
-	if opts != cg.config {
+	renderer := cg.renderer
+	if _, ok := renderer.(*SVGRenderer); ok && opts != cg.config {
 		renderer = NewSVGRenderer(opts)
 	}
 
 	// Render SVG
-	svgData, err := renderer.RenderMathExpression(expr, opts)
+	renderCtx, span := startSpan(ctx, SpanRender)
+	var svgData string
+	if contextRenderer, ok := renderer.(ContextRenderer); ok {
+		svgData, err = contextRenderer.RenderMathExpressionContext(renderCtx, expr, opts)
+	} else {
+		svgData, err = renderer.RenderMathExpression(expr, opts)
+	}
+	if err == nil && span.IsRecording() {
+		span.SetAttributes(IntAttribute("captcha.svg.bytes", len(svgData)))
+	}
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +131,156 @@ func (cg *CaptchaGenerator) CreateMathExprWithOptions(opts *Config) (*CaptchaRes
 	}, nil
 }
 
+// WriteMathExpr generates a captcha with the generator's configuration and streams its SVG to w,
+// for example an http.ResponseWriter. The returned result carries the answer and question but no
+// Data, since the document was written to w.
+func (cg *CaptchaGenerator) WriteMathExpr(w io.Writer) (*CaptchaResult, error) {
+	return cg.WriteMathExprContext(context.Background(), w)
+}
+
+// WriteMathExprContext is WriteMathExpr reporting spans as children of any span in ctx
+func (cg *CaptchaGenerator) WriteMathExprContext(ctx context.Context, w io.Writer) (result *CaptchaResult, err error) {
+	start := time.Now()
+	ctx, generateSpan := cg.startGenerationSpan(ctx, cg.config)
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, newPanicError(r)
+		}
+		endSpan(generateSpan, err)
+		cg.finishGeneration(start, cg.config, err)
+	}()
+
+	opts := cg.config
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	expr, err := cg.generateExpression(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	renderCtx, span := startSpan(ctx, SpanRender)
+	if contextRenderer, ok := cg.renderer.(ContextRenderer); ok {
+		_, err = contextRenderer.WriteMathExpressionContext(renderCtx, w, expr, opts)
+	} else {
+		_, err = cg.renderer.WriteMathExpression(w, expr, opts)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptchaResult{
+		Text:     strconv.Itoa(expr.Answer),
+		Question: expr.Question,
+	}, nil
+}
+
+// CreateMathExprWithTheme generates a captcha with the generator's configuration and the
+// colors of the named theme, for example ThemeForColorScheme of the client's preference
+func (cg *CaptchaGenerator) CreateMathExprWithTheme(theme string) (*CaptchaResult, error) {
+	opts := cg.GetConfig()
+	if err := opts.ApplyTheme(theme); err != nil {
+		return nil, err
+	}
+	return cg.CreateMathExprWithOptions(opts)
+}
+
+// traceContext adds the generator's tracer to ctx
+func (cg *CaptchaGenerator) traceContext(ctx context.Context) context.Context {
+	if cg.tracer == nil {
+		return ctx
+	}
+	return ContextWithTracer(ctx, cg.tracer)
+}
+
+// startGenerationSpan starts the root span of a generation with opts
+func (cg *CaptchaGenerator) startGenerationSpan(ctx context.Context, opts *Config) (context.Context, Span) {
+	ctx, span := startSpan(cg.traceContext(ctx), SpanGenerate)
+	if span.IsRecording() {
+		if opts == nil {
+			opts = cg.config
+		}
+		span.SetAttributes(
+			StringAttribute("captcha.config_hash", opts.Hash()),
+			StringAttribute("captcha.operator", opts.MathOperator),
+			IntAttribute("captcha.width", opts.Width),
+			IntAttribute("captcha.height", opts.Height),
+		)
+	}
+	return ctx, span
+}
+
+// generateExpression generates a math expression inside an expression span
+func (cg *CaptchaGenerator) generateExpression(ctx context.Context) (*MathExpression, error) {
+	_, span := startSpan(ctx, SpanExpression)
+	expr, err := cg.mathGen.GenerateExpression()
+	if err == nil && span.IsRecording() {
+		span.SetAttributes(StringAttribute("captcha.expression.operator", expr.Operator))
+	}
+	endSpan(span, err)
+	return expr, err
+}
+
+// SetMetrics reports generation latency and errors to metrics; nil disables reporting
+func (cg *CaptchaGenerator) SetMetrics(metrics Metrics) {
+	cg.mutex.Lock()
+	defer cg.mutex.Unlock()
+
+	cg.metrics = metrics
+}
+
+// finishGeneration reports a generation that started at start to the metrics hook and logs it
+// with its duration, configuration hash and error type
+func (cg *CaptchaGenerator) finishGeneration(start time.Time, opts *Config, err error) {
+	duration := time.Since(start)
+
+	cg.mutex.RLock()
+	metrics := cg.metrics
+	cg.mutex.RUnlock()
+	if metrics != nil {
+		metrics.ObserveGeneration(duration, errorType(err))
+	}
+
+	level, msg := slog.LevelDebug, "captcha generated"
+	if err != nil {
+		// Invalid options are the caller's mistake, anything else is a failure of the library
+		level, msg = slog.LevelError, "captcha generation failed"
+		if captchaErr, ok := AsCaptchaError(err); ok && captchaErr.Code < 500 {
+			level = slog.LevelWarn
+		}
+	}
+	ctx := context.Background()
+	if !cg.logger.Enabled(ctx, level) {
+		return
+	}
+
+	if opts == nil {
+		opts = cg.config
+	}
+	attrs := []slog.Attr{slog.Duration("duration", duration), slog.String("config_hash", opts.Hash())}
+	if err != nil {
+		attrs = append(attrs, slog.String("error_type", errorType(err)), slog.String("error", err.Error()))
+		if captchaErr, ok := AsCaptchaError(err); ok && captchaErr.Debug != "" {
+			attrs = append(attrs, slog.String("debug", captchaErr.Debug))
+		}
+	}
+	cg.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// errorType returns the CaptchaError type of err, ErrRenderFailed for other errors and an empty
+// string for nil
+func errorType(err error) string {
+	if err == nil {
+		return ""
+	}
+	if captchaErr, ok := AsCaptchaError(err); ok {
+		return captchaErr.Type
+	}
+	return ErrRenderFailed
+}
+
 // UpdateConfig updates the generator's configuration
 func (cg *CaptchaGenerator) UpdateConfig(config *Config) error {
 	if config == nil {
@@ -104,7 +296,9 @@ func (cg *CaptchaGenerator) UpdateConfig(config *Config) error {
 
 	cg.config = config
 	cg.mathGen = NewMathExpressionGenerator(config)
-	cg.svgRenderer = NewSVGRenderer(config)
+	if _, ok := cg.renderer.(*SVGRenderer); ok {
+		cg.renderer = NewSVGRenderer(config)
+	}
 
 	return nil
 }
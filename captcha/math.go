@@ -1,9 +1,7 @@
 package captcha
 
 import (
-	"crypto/rand"
-	"fmt"
-	"math/big"
+	"strconv"
 	"strings"
 )
 
@@ -81,7 +79,7 @@ func (meg *MathExpressionGenerator) GenerateAddition() (*MathExpression, error)
 	}
 
 	answer := operand1 + operand2
-	question := fmt.Sprintf("%d + %d = ?", operand1, operand2)
+	question := strconv.Itoa(operand1) + " + " + strconv.Itoa(operand2) + " = ?"
 
 	return &MathExpression{
 		Operand1: operand1,
@@ -110,7 +108,7 @@ func (meg *MathExpressionGenerator) GenerateSubtraction() (*MathExpression, erro
 	}
 
 	answer := operand1 - operand2
-	question := fmt.Sprintf("%d - %d = ?", operand1, operand2)
+	question := strconv.Itoa(operand1) + " - " + strconv.Itoa(operand2) + " = ?"
 
 	return &MathExpression{
 		Operand1: operand1,
@@ -130,18 +128,3 @@ func (meg *MathExpressionGenerator) generateOperand() (int, error) {
 	}
 	return meg.minValue + randomValue, nil
 }
-
-// secureRandomInt generates a cryptographically secure random integer in range [0, max)
-func secureRandomInt(max int) (int, error) {
-	if max <= 0 {
-		return 0, fmt.Errorf("max must be positive")
-	}
-
-	maxBig := big.NewInt(int64(max))
-	n, err := rand.Int(rand.Reader, maxBig)
-	if err != nil {
-		return 0, err
-	}
-
-	return int(n.Int64()), nil
-}
@@ -0,0 +1,172 @@
+package captcha
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements from a CaptchaGenerator and a Service. Implementations must be
+// safe for concurrent use; MetricsRegistry is a dependency-free implementation.
+type Metrics interface {
+	// ObserveGeneration records how long generating one captcha took; errorType is the
+	// CaptchaError type of a failed generation and empty on success
+	ObserveGeneration(duration time.Duration, errorType string)
+	// ObserveVerification records the outcome of one verification
+	ObserveVerification(result VerifyResult)
+	// SetStoreSize records the number of captchas awaiting verification
+	SetStoreSize(size int)
+}
+
+// DefaultLatencyBuckets are the generation latency histogram bounds in seconds
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// MetricsRegistry collects captcha metrics and serves them in the Prometheus text exposition
+// format, so it can be scraped without a client library
+type MetricsRegistry struct {
+	mutex         sync.Mutex
+	buckets       []float64
+	bucketCounts  []uint64
+	latencySum    float64
+	latencyCount  uint64
+	errors        map[string]uint64
+	verifications map[VerifyResult]uint64
+	storeSize     int
+}
+
+// NewMetricsRegistry creates a registry with DefaultLatencyBuckets
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		buckets:       DefaultLatencyBuckets,
+		bucketCounts:  make([]uint64, len(DefaultLatencyBuckets)),
+		errors:        make(map[string]uint64),
+		verifications: make(map[VerifyResult]uint64),
+	}
+}
+
+// ObserveGeneration records a generation latency and counts failures by error type
+func (mr *MetricsRegistry) ObserveGeneration(duration time.Duration, errorType string) {
+	seconds := duration.Seconds()
+
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	for i, bound := range mr.buckets {
+		if seconds <= bound {
+			mr.bucketCounts[i]++
+		}
+	}
+	mr.latencySum += seconds
+	mr.latencyCount++
+	if errorType != "" {
+		mr.errors[errorType]++
+	}
+}
+
+// ObserveVerification counts a verification outcome
+func (mr *MetricsRegistry) ObserveVerification(result VerifyResult) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mr.verifications[result]++
+}
+
+// SetStoreSize records the current store size
+func (mr *MetricsRegistry) SetStoreSize(size int) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mr.storeSize = size
+}
+
+// WriteText renders all metrics in the Prometheus text exposition format (version 0.0.4)
+func (mr *MetricsRegistry) WriteText() []byte {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	var buf bytes.Buffer
+
+	buf.WriteString("# HELP captcha_generation_duration_seconds Time spent generating a captcha.\n")
+	buf.WriteString("# TYPE captcha_generation_duration_seconds histogram\n")
+	for i, bound := range mr.buckets {
+		writeSample(&buf, "captcha_generation_duration_seconds_bucket", "le", formatMetricValue(bound), float64(mr.bucketCounts[i]))
+	}
+	writeSample(&buf, "captcha_generation_duration_seconds_bucket", "le", "+Inf", float64(mr.latencyCount))
+	writeSample(&buf, "captcha_generation_duration_seconds_sum", "", "", mr.latencySum)
+	writeSample(&buf, "captcha_generation_duration_seconds_count", "", "", float64(mr.latencyCount))
+
+	buf.WriteString("# HELP captcha_generation_errors_total Failed captcha generations by error type.\n")
+	buf.WriteString("# TYPE captcha_generation_errors_total counter\n")
+	for _, errorType := range sortedKeys(mr.errors) {
+		writeSample(&buf, "captcha_generation_errors_total", "type", errorType, float64(mr.errors[errorType]))
+	}
+
+	buf.WriteString("# HELP captcha_verifications_total Captcha verifications by result.\n")
+	buf.WriteString("# TYPE captcha_verifications_total counter\n")
+	for _, result := range []VerifyResult{VerifySuccess, VerifyFailure, VerifyExpired, VerifyNotFound} {
+		writeSample(&buf, "captcha_verifications_total", "result", string(result), float64(mr.verifications[result]))
+	}
+
+	buf.WriteString("# HELP captcha_store_size Captchas awaiting verification.\n")
+	buf.WriteString("# TYPE captcha_store_size gauge\n")
+	writeSample(&buf, "captcha_store_size", "", "", float64(mr.storeSize))
+
+	return buf.Bytes()
+}
+
+// ServeHTTP serves the metrics for a Prometheus scrape
+func (mr *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(mr.WriteText())
+}
+
+// writeSample writes one sample line with an optional label
+func writeSample(buf *bytes.Buffer, name, label, labelValue string, value float64) {
+	buf.WriteString(name)
+	if label != "" {
+		buf.WriteByte('{')
+		buf.WriteString(label)
+		buf.WriteString(`="`)
+		buf.WriteString(escapeLabelValue(labelValue))
+		buf.WriteString(`"}`)
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatMetricValue(value))
+	buf.WriteByte('\n')
+}
+
+// formatMetricValue formats a sample value in its shortest form
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines as the exposition format requires
+func escapeLabelValue(value string) string {
+	var buf bytes.Buffer
+	for _, r := range value {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// sortedKeys returns the keys of counts in order, for stable output
+func sortedKeys(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,205 @@
+package captcha
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// defaultPrecision is the number of decimals kept in minified output before a size budget applies
+const defaultPrecision = 2
+
+// numberPattern matches decimal numbers inside transform and other numeric attribute strings
+var numberPattern = regexp.MustCompile(`[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`)
+
+// canMergePaths reports whether b can be appended to a without changing the rendering.
+// Translucent paths are never merged because overlaps would no longer blend twice.
+func canMergePaths(a, b *PathElement) bool {
+	return a.Fill == b.Fill && a.Stroke == b.Stroke && a.StrokeWidth == b.StrokeWidth &&
+		a.FillOpacity == "" && b.FillOpacity == "" && a.StrokeOpacity == "" && b.StrokeOpacity == ""
+}
+
+// roundTo rounds v to the given number of decimals
+func roundTo(v float64, precision int) float64 {
+	scale := math.Pow10(precision)
+	return math.Round(v*scale) / scale
+}
+
+// appendNumber appends v rounded to precision in its shortest form, dropping the leading
+// zero of fractions ("0.5" becomes ".5")
+func appendNumber(dst []byte, v float64, precision int) []byte {
+	v = roundTo(v, precision)
+	if v == 0 {
+		return append(dst, '0') // also normalizes -0
+	}
+
+	start := len(dst)
+	dst = strconv.AppendFloat(dst, v, 'f', -1, 64)
+	if dst[start] == '0' && len(dst) > start+1 {
+		dst = append(dst[:start], dst[start+1:]...)
+	} else if dst[start] == '-' && dst[start+1] == '0' && len(dst) > start+2 {
+		dst = append(dst[:start+1], dst[start+2:]...)
+	}
+	return dst
+}
+
+// minifyNumbers rewrites every number in s in its shortest form
+func minifyNumbers(s string, precision int) string {
+	return numberPattern.ReplaceAllStringFunc(s, func(number string) string {
+		v, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return number
+		}
+		return string(appendNumber(nil, v, precision))
+	})
+}
+
+// appendRelativePath rewrites path data with relative commands after the initial moveto.
+// Offsets are computed between rounded absolute positions so rounding never accumulates.
+func appendRelativePath(dst []byte, d string, precision int) []byte {
+	tokens := tokenizePath(d)
+
+	var pos, start point      // exact current point and subpath start
+	var out, outStart point   // rounded current point and subpath start as emitted
+	var cmd, lastEmitted byte // current input command and last emitted command letter
+	needSeparator := false    // whether the next number must be separated from the previous one
+	lastHadDot := false
+
+	round := func(p point) point {
+		return point{roundTo(p.X, precision), roundTo(p.Y, precision)}
+	}
+	emitCommand := func(letter byte) {
+		// Repeated commands may omit their letter, except after moveto where pairs mean lineto
+		if letter != lastEmitted || letter == 'm' || letter == 'M' {
+			dst = append(dst, letter)
+			needSeparator = false
+		}
+		lastEmitted = letter
+	}
+	emitNumber := func(v float64) {
+		mark := len(dst)
+		dst = appendNumber(dst, v, precision)
+		first := dst[mark]
+		if needSeparator && first != '-' && !(first == '.' && lastHadDot) {
+			dst = append(dst[:mark+1], dst[mark:]...)
+			dst[mark] = ' '
+			mark++
+		}
+		lastHadDot = bytes.IndexByte(dst[mark:], '.') >= 0
+		needSeparator = true
+	}
+	emitPoint := func(p, origin point) {
+		emitNumber(p.X - origin.X)
+		emitNumber(p.Y - origin.Y)
+	}
+
+	first := true
+	for i := 0; i < len(tokens); {
+		if isPathCommand(tokens[i]) {
+			cmd = tokens[i][0]
+			i++
+		} else if cmd == 'Z' || cmd == 'z' || cmd == 0 {
+			break // numbers without a command
+		}
+		relative := cmd >= 'a' && cmd <= 'z'
+		upper := cmd &^ 0x20
+
+		count := pathArgCount(upper)
+		if count < 0 || i+count > len(tokens) {
+			break
+		}
+		var argBuf [6]float64
+		args := argBuf[:count]
+		for k := range args {
+			args[k], _ = strconv.ParseFloat(tokens[i+k], 64)
+		}
+		i += count
+
+		abs := func(x, y float64) point {
+			if relative {
+				return point{pos.X + x, pos.Y + y}
+			}
+			return point{x, y}
+		}
+
+		switch upper {
+		case 'M':
+			pos = abs(args[0], args[1])
+			start = pos
+			rounded := round(pos)
+			if first {
+				emitCommand('M')
+				emitPoint(rounded, point{})
+				first = false
+			} else {
+				emitCommand('m')
+				emitPoint(rounded, out)
+			}
+			out, outStart = rounded, rounded
+			if relative {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			pos = abs(args[0], args[1])
+			rounded := round(pos)
+			emitCommand('l')
+			emitPoint(rounded, out)
+			out = rounded
+		case 'H':
+			if relative {
+				pos.X += args[0]
+			} else {
+				pos.X = args[0]
+			}
+			x := roundTo(pos.X, precision)
+			emitCommand('h')
+			emitNumber(x - out.X)
+			out.X = x
+		case 'V':
+			if relative {
+				pos.Y += args[0]
+			} else {
+				pos.Y = args[0]
+			}
+			y := roundTo(pos.Y, precision)
+			emitCommand('v')
+			emitNumber(y - out.Y)
+			out.Y = y
+		case 'Q', 'C', 'T':
+			letter := upper | 0x20
+			emitCommand(letter)
+			origin := out
+			for k := 0; k < count; k += 2 {
+				p := round(abs(args[k], args[k+1]))
+				emitPoint(p, origin)
+				out = p
+			}
+			pos = abs(args[count-2], args[count-1])
+		case 'Z':
+			emitCommand('z')
+			pos, out = start, outStart
+		}
+	}
+
+	return dst
+}
+
+// pathArgCount returns the number of arguments of an uppercase path command, or -1 if unsupported
+func pathArgCount(cmd byte) int {
+	switch cmd {
+	case 'Z':
+		return 0
+	case 'H', 'V':
+		return 1
+	case 'M', 'L', 'T':
+		return 2
+	case 'Q':
+		return 4
+	case 'C':
+		return 6
+	}
+	return -1
+}
@@ -1,6 +1,6 @@
 package captcha
 
-import "fmt"
+import "math"
 
 // NoiseGenerator generates visual noise elements for captchas
 type NoiseGenerator struct{}
@@ -13,6 +13,7 @@ func NewNoiseGenerator() *NoiseGenerator {
 // GenerateLines creates random curved lines for visual noise (now returns PathElements instead of LineElements)
 func (ng *NoiseGenerator) GenerateLines(count, width, height int, colorMgr *ColorManager) []*PathElement {
 	curves := make([]*PathElement, 0, count)
+	elements := make([]PathElement, count) // Backing array for all elements in one allocation
 
 	for i := 0; i < count; i++ {
 		// Generate start and end points
@@ -34,11 +35,14 @@ func (ng *NoiseGenerator) GenerateLines(count, width, height int, colorMgr *Colo
 		// Generate curve path with random control points
 		pathData := ng.generateCurvePath(startX, startY, endX, endY, float64(width), float64(height))
 
-		curve := &PathElement{
-			D:           pathData,
-			Fill:        "none",
-			Stroke:      colorMgr.GetRandomNoiseColor(),
-			StrokeWidth: fmt.Sprintf("%.5g", strokeWidth),
+		color := colorMgr.GetNoiseLineColor()
+		curve := &elements[i]
+		*curve = PathElement{
+			D:             pathData,
+			Fill:          "none",
+			Stroke:        color.Hex(),
+			StrokeWidth:   formatStrokeWidth(strokeWidth),
+			StrokeOpacity: color.opacityAttr(),
 		}
 
 		curves = append(curves, curve)
@@ -50,6 +54,7 @@ func (ng *NoiseGenerator) GenerateLines(count, width, height int, colorMgr *Colo
 // GenerateDots creates random circles for visual noise
 func (ng *NoiseGenerator) GenerateDots(count, width, height int, colorMgr *ColorManager) []*CircleElement {
 	circles := make([]*CircleElement, 0, count)
+	elements := make([]CircleElement, count) // Backing array for all elements in one allocation
 
 	for i := 0; i < count; i++ {
 		cx, err1 := secureRandomFloat(0, float64(width))
@@ -65,11 +70,14 @@ func (ng *NoiseGenerator) GenerateDots(count, width, height int, colorMgr *Color
 			radius = 2.0
 		}
 
-		circle := &CircleElement{
-			CX:   cx,
-			CY:   cy,
-			R:    radius,
-			Fill: colorMgr.GetRandomNoiseColor(),
+		color := colorMgr.GetNoiseDotColor()
+		circle := &elements[i]
+		*circle = CircleElement{
+			CX:          cx,
+			CY:          cy,
+			R:           radius,
+			Fill:        color.Hex(),
+			FillOpacity: color.opacityAttr(),
 		}
 
 		circles = append(circles, circle)
@@ -95,8 +103,11 @@ func (ng *NoiseGenerator) generateCurvePath(startX, startY, endX, endY, width, h
 		controlX += offsetX
 		controlY += offsetY
 
-		return fmt.Sprintf("M%.2f,%.2f Q%.2f,%.2f %.2f,%.2f",
-			startX, startY, controlX, controlY, endX, endY)
+		var buf [64]byte
+		b := appendPoint(append(buf[:0], 'M'), startX, startY)
+		b = appendPoint(append(b, " Q"...), controlX, controlY)
+		b = appendPoint(append(b, ' '), endX, endY)
+		return string(b)
 
 	case 1:
 		// Cubic Bezier curve with two control points
@@ -116,13 +127,18 @@ func (ng *NoiseGenerator) generateCurvePath(startX, startY, endX, endY, width, h
 		control2X += offset2X
 		control2Y += offset2Y
 
-		return fmt.Sprintf("M%.2f,%.2f C%.2f,%.2f %.2f,%.2f %.2f,%.2f",
-			startX, startY, control1X, control1Y, control2X, control2Y, endX, endY)
+		var buf [80]byte
+		b := appendPoint(append(buf[:0], 'M'), startX, startY)
+		b = appendPoint(append(b, " C"...), control1X, control1Y)
+		b = appendPoint(append(b, ' '), control2X, control2Y)
+		b = appendPoint(append(b, ' '), endX, endY)
+		return string(b)
 
 	default:
 		// Sinusoidal curve using multiple quadratic segments
 		numSegments := 3
-		path := fmt.Sprintf("M%.2f,%.2f", startX, startY)
+		var buf [112]byte
+		path := appendPoint(append(buf[:0], 'M'), startX, startY)
 
 		for i := 1; i <= numSegments; i++ {
 			t := float64(i) / float64(numSegments)
@@ -146,20 +162,21 @@ func (ng *NoiseGenerator) generateCurvePath(startX, startY, endX, endY, width, h
 			}
 
 			if i == 1 {
-				path += fmt.Sprintf(" Q%.2f,%.2f %.2f,%.2f",
-					segmentX, segmentY, startX+(endX-startX)*0.5, startY+(endY-startY)*0.5)
+				path = appendPoint(append(path, " Q"...), segmentX, segmentY)
+				path = appendPoint(append(path, ' '), startX+(endX-startX)*0.5, startY+(endY-startY)*0.5)
 			} else {
-				path += fmt.Sprintf(" T%.2f,%.2f", segmentX, segmentY)
+				path = appendPoint(append(path, " T"...), segmentX, segmentY)
 			}
 		}
 
-		return path
+		return string(path)
 	}
 }
 
 // GenerateArcs creates random arc segments for more sophisticated noise
 func (ng *NoiseGenerator) GenerateArcs(count, width, height int, colorMgr *ColorManager) []*PathElement {
 	arcs := make([]*PathElement, 0, count)
+	elements := make([]PathElement, count) // Backing array for all elements in one allocation
 
 	for i := 0; i < count; i++ {
 		startX, err1 := secureRandomFloat(0, float64(width))
@@ -180,11 +197,14 @@ func (ng *NoiseGenerator) GenerateArcs(count, width, height int, colorMgr *Color
 			strokeWidth = 0.8
 		}
 
-		arc := &PathElement{
-			D:           pathData,
-			Fill:        "none",
-			Stroke:      colorMgr.GetRandomNoiseColor(),
-			StrokeWidth: fmt.Sprintf("%.5g", strokeWidth),
+		color := colorMgr.GetNoiseLineColor()
+		arc := &elements[i]
+		*arc = PathElement{
+			D:             pathData,
+			Fill:          "none",
+			Stroke:        color.Hex(),
+			StrokeWidth:   formatStrokeWidth(strokeWidth),
+			StrokeOpacity: color.opacityAttr(),
 		}
 
 		arcs = append(arcs, arc)
@@ -192,3 +212,102 @@ func (ng *NoiseGenerator) GenerateArcs(count, width, height int, colorMgr *Color
 
 	return arcs
 }
+
+// GenerateStrikeThroughs creates text-colored curves that cross the full width of the text area.
+// Every point of each quadratic curve stays within the vertical span of area, so the curve
+// is guaranteed to pass through the glyphs rather than around them.
+func (ng *NoiseGenerator) GenerateStrikeThroughs(count int, area Bounds, stemWidth float64, colorMgr *ColorManager) []*PathElement {
+	if count <= 0 || area.Empty() {
+		return nil
+	}
+
+	strikes := make([]*PathElement, 0, count)
+	elements := make([]PathElement, count) // Backing array for all elements in one allocation
+	margin := (area.MaxX - area.MinX) * 0.1
+
+	for i := 0; i < count; i++ {
+		startY, err1 := secureRandomFloat(area.MinY, area.MaxY)
+		controlX, err2 := secureRandomFloat(area.MinX, area.MaxX)
+		controlY, err3 := secureRandomFloat(area.MinY, area.MaxY)
+		endY, err4 := secureRandomFloat(area.MinY, area.MaxY)
+
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue // skip this curve if random generation fails
+		}
+
+		// Stroke width comparable to the glyph stems
+		strokeWidth, err := secureRandomFloat(stemWidth*0.5, stemWidth)
+		if err != nil {
+			strokeWidth = stemWidth
+		}
+
+		var buf [64]byte
+		d := appendPoint(append(buf[:0], 'M'), area.MinX-margin, startY)
+		d = appendPoint(append(d, " Q"...), controlX, controlY)
+		d = appendPoint(append(d, ' '), area.MaxX+margin, endY)
+
+		// Text colors keep their own alpha so strikes match the glyphs
+		color := colorMgr.GetRandomTextRGBA()
+		strike := &elements[i]
+		*strike = PathElement{
+			D:             string(d),
+			Fill:          "none",
+			Stroke:        color.Hex(),
+			StrokeWidth:   formatStrokeWidth(strokeWidth),
+			StrokeOpacity: color.opacityAttr(),
+		}
+
+		strikes = append(strikes, strike)
+	}
+
+	return strikes
+}
+
+// GenerateOcclusions creates short text-colored strokes as wide as the glyph stems, centered
+// inside the text area so they merge with the characters
+func (ng *NoiseGenerator) GenerateOcclusions(count int, area Bounds, stemWidth float64, colorMgr *ColorManager) []*PathElement {
+	if count <= 0 || area.Empty() {
+		return nil
+	}
+
+	strokes := make([]*PathElement, 0, count)
+	elements := make([]PathElement, count) // Backing array for all elements in one allocation
+	textHeight := area.MaxY - area.MinY
+
+	for i := 0; i < count; i++ {
+		cx, err1 := secureRandomFloat(area.MinX, area.MaxX)
+		cy, err2 := secureRandomFloat(area.MinY, area.MaxY)
+		length, err3 := secureRandomFloat(textHeight*0.4, textHeight*0.8)
+		angle, err4 := secureRandomFloat(0, math.Pi)
+
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue // skip this stroke if random generation fails
+		}
+
+		strokeWidth, err := secureRandomFloat(stemWidth*0.8, stemWidth*1.2)
+		if err != nil {
+			strokeWidth = stemWidth
+		}
+
+		dx := math.Cos(angle) * length / 2
+		dy := math.Sin(angle) * length / 2
+
+		var buf [48]byte
+		d := appendPoint(append(buf[:0], 'M'), cx-dx, cy-dy)
+		d = appendPoint(append(d, " L"...), cx+dx, cy+dy)
+
+		color := colorMgr.GetRandomTextRGBA()
+		stroke := &elements[i]
+		*stroke = PathElement{
+			D:             string(d),
+			Fill:          "none",
+			Stroke:        color.Hex(),
+			StrokeWidth:   formatStrokeWidth(strokeWidth),
+			StrokeOpacity: color.opacityAttr(),
+		}
+
+		strokes = append(strokes, stroke)
+	}
+
+	return strokes
+}
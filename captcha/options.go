@@ -0,0 +1,44 @@
+package captcha
+
+import "log/slog"
+
+// Option configures a CaptchaGenerator at construction
+type Option func(*CaptchaGenerator)
+
+// discardLogger is the default logger, so the library stays silent unless a logger is injected
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// WithLogger sends the generator's structured log records to logger; nil discards them (default)
+func WithLogger(logger *slog.Logger) Option {
+	return func(cg *CaptchaGenerator) {
+		if logger == nil {
+			logger = discardLogger
+		}
+		cg.logger = logger
+	}
+}
+
+// WithMetrics reports generation latency and errors to metrics
+func WithMetrics(metrics Metrics) Option {
+	return func(cg *CaptchaGenerator) {
+		cg.metrics = metrics
+	}
+}
+
+// WithRenderer renders captchas with renderer instead of the built-in SVGRenderer
+func WithRenderer(renderer Renderer) Option {
+	return func(cg *CaptchaGenerator) {
+		cg.renderer = renderer
+	}
+}
+
+// WithTracer reports spans around generation, rendering, noise and store operations to tracer;
+// nil or NoopTracer disables tracing (default)
+func WithTracer(tracer Tracer) Option {
+	return func(cg *CaptchaGenerator) {
+		if _, ok := tracer.(NoopTracer); ok {
+			tracer = nil
+		}
+		cg.tracer = tracer
+	}
+}
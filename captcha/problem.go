@@ -0,0 +1,73 @@
+package captcha
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypeBase prefixes the problem type URI of each error type
+const ProblemTypeBase = "urn:svg-math-captcha:error:"
+
+// Problem is an RFC 9457 problem details object. ErrorType is an extension member carrying the
+// CaptchaError type.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+}
+
+// HTTPStatus maps err to an HTTP status code: the Code of a CaptchaError in its chain if it is a
+// valid error status, 500 otherwise
+func HTTPStatus(err error) int {
+	if captchaErr, ok := AsCaptchaError(err); ok && captchaErr.Code >= 400 && captchaErr.Code <= 599 {
+		return captchaErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// NewProblem describes err as problem details. Messages of server errors are replaced by the
+// status text, so causes and internals are never exposed to clients.
+func NewProblem(err error) *Problem {
+	status := HTTPStatus(err)
+	problem := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+
+	captchaErr, ok := AsCaptchaError(err)
+	if !ok {
+		return problem
+	}
+	problem.Type = ProblemTypeBase + strings.ToLower(strings.ReplaceAll(captchaErr.Type, "_", "-"))
+	problem.ErrorType = captchaErr.Type
+	if status < 500 {
+		problem.Detail = captchaErr.Message
+	}
+	return problem
+}
+
+// WriteProblem writes err as an application/problem+json response for the request r, with a
+// Retry-After header for rate-limited errors
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	problem := NewProblem(err)
+	if r != nil {
+		problem.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	if captchaErr, ok := AsCaptchaError(err); ok && captchaErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(captchaErr.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,56 @@
+package captcha
+
+import (
+	"errors"
+	"math"
+
+	"svg-math-captcha/internal/randsource"
+)
+
+// secureRandomInt generates a cryptographically secure random integer in range [0, max)
+func secureRandomInt(max int) (int, error) {
+	if max <= 0 {
+		return 0, errors.New("max must be positive")
+	}
+
+	// Reject the top values that would make the modulo biased
+	n := uint64(max)
+	limit := math.MaxUint64 - (math.MaxUint64%n+1)%n
+	for {
+		v, err := randsource.Uint64()
+		if err != nil {
+			return 0, err
+		}
+		if v <= limit {
+			return int(v % n), nil
+		}
+	}
+}
+
+// secureRandomFloat64 returns a uniformly distributed float in [0, 1) using 53 random bits,
+// the full precision of a float64 mantissa
+func secureRandomFloat64() (float64, error) {
+	v, err := randsource.Uint64()
+	if err != nil {
+		return 0, err
+	}
+	return float64(v>>11) / (1 << 53), nil
+}
+
+// secureRandomFloat generates a secure random float in [min, max)
+func secureRandomFloat(min, max float64) (float64, error) {
+	if min >= max {
+		return min, nil
+	}
+
+	for {
+		u, err := secureRandomFloat64()
+		if err != nil {
+			return min, err
+		}
+		// Rounding can land exactly on max for wide ranges; draw again to keep it exclusive
+		if v := min + u*(max-min); v < max {
+			return v, nil
+		}
+	}
+}
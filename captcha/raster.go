@@ -0,0 +1,655 @@
+package captcha
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rasterSubsamples is the number of sub-scanlines per pixel row used for anti-aliasing
+const rasterSubsamples = 4
+
+// MaxRasterPixels is the largest image Rasterize renders, 4 megapixels: a 150x50 captcha can be
+// scaled up to about 23 times. Each pixel takes 8 bytes while rendering.
+const MaxRasterPixels = 1 << 22
+
+// Rasterize renders SVG produced by this package into an RGBA image, scaling the
+// canvas by scale. Text is drawn with the embedded font and filter effects and
+// patterns are ignored, so the output approximates what a browser displays. Images larger than
+// MaxRasterPixels are rejected.
+func Rasterize(svgData string, scale float64) (*image.RGBA, error) {
+	if !(scale > 0) || math.IsInf(scale, 1) {
+		return nil, NewError(ErrRenderFailed, "raster scale must be a finite number > 0", 400)
+	}
+
+	var svg SVGElement
+	if err := xml.Unmarshal([]byte(svgData), &svg); err != nil {
+		return nil, WrapError(ErrRenderFailed, "failed to parse SVG", 500, err)
+	}
+	if svg.Width <= 0 || svg.Height <= 0 {
+		return nil, NewError(ErrRenderFailed, "SVG has no dimensions", 500)
+	}
+	if math.Ceil(float64(svg.Width)*scale)*math.Ceil(float64(svg.Height)*scale) > MaxRasterPixels {
+		return nil, NewError(ErrRenderFailed, "raster image exceeds "+strconv.Itoa(MaxRasterPixels)+" pixels", 400)
+	}
+
+	font, err := defaultFont()
+	if err != nil {
+		return nil, err
+	}
+
+	width := int(math.Ceil(float64(svg.Width) * scale))
+	height := int(math.Ceil(float64(svg.Height) * scale))
+	r := &rasterizer{
+		img:       image.NewRGBA(image.Rect(0, 0, width, height)),
+		cover:     make([]float32, width*height),
+		width:     width,
+		height:    height,
+		base:      affine{a: scale, d: scale},
+		font:      font,
+		gradients: make(map[string]string),
+	}
+
+	// Start from opaque white like a browser page
+	for i := range r.img.Pix {
+		r.img.Pix[i] = 0xff
+	}
+
+	if svg.Defs != nil {
+		for _, gradient := range svg.Defs.LinearGradients {
+			r.gradients[gradient.ID] = averageGradientColor(gradient)
+		}
+	}
+
+	if svg.Background != nil {
+		r.drawRect(svg.Background)
+	}
+	for _, group := range svg.Groups {
+		r.drawGroup(group)
+	}
+	for _, text := range svg.Texts {
+		r.drawText(text)
+	}
+	for _, path := range svg.Paths {
+		r.drawPath(path)
+	}
+	for _, line := range svg.Lines {
+		r.strokePolylines([][]point{{{line.X1, line.Y1}, {line.X2, line.Y2}}}, r.base, line.Stroke, line.Width, parseOpacity(line.StrokeOpacity))
+	}
+	for _, circle := range svg.Circles {
+		r.drawCircle(circle)
+	}
+
+	return r.img, nil
+}
+
+// rasterizer accumulates anti-aliased coverage for one shape at a time and composites it onto img
+type rasterizer struct {
+	img       *image.RGBA
+	cover     []float32
+	width     int
+	height    int
+	base      affine
+	font      *glyphFont
+	gradients map[string]string // gradient ID -> representative color
+}
+
+// drawGroup draws a group's children in document order
+func (r *rasterizer) drawGroup(group *GroupElement) {
+	for _, rect := range group.Rects {
+		r.drawRect(rect)
+	}
+	for _, text := range group.Texts {
+		r.drawText(text)
+	}
+	for _, path := range group.Paths {
+		r.drawPath(path)
+	}
+	for _, circle := range group.Circles {
+		r.drawCircle(circle)
+	}
+}
+
+// drawRect fills a rectangle
+func (r *rasterizer) drawRect(rect *RectElement) {
+	x0, y0 := float64(rect.X), float64(rect.Y)
+	x1, y1 := x0+float64(rect.Width), y0+float64(rect.Height)
+	r.fillPolygons([][]point{{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}}, r.base, rect.Fill, 1)
+}
+
+// drawCircle fills a circle approximated by a polygon
+func (r *rasterizer) drawCircle(circle *CircleElement) {
+	r.fillPolygons([][]point{circlePolygon(point{circle.CX, circle.CY}, circle.R, false)}, r.base, circle.Fill, parseOpacity(circle.FillOpacity))
+}
+
+// drawText fills a text element using the embedded font outlines
+func (r *rasterizer) drawText(text *TextElement) {
+	transform := r.base
+	if text.Transform != "" {
+		transform = parseTransform(text.Transform).then(r.base)
+	}
+
+	unit := float64(text.FontSize) / r.font.unitsPerEm
+	penX := text.X
+	var polygons [][]point
+	for _, char := range text.Content {
+		glyph, err := r.font.Glyph(char)
+		if err != nil {
+			continue
+		}
+		glyphTransform := affine{a: unit, d: -unit, e: penX, f: text.Y}
+		polygons = append(polygons, flattenSegments(glyph.Segments, glyphTransform)...)
+		penX += glyph.Advance * unit
+	}
+
+	r.fillPolygons(polygons, transform, text.Fill, parseOpacity(text.FillOpacity))
+}
+
+// drawPath fills and strokes a path element
+func (r *rasterizer) drawPath(path *PathElement) {
+	subpaths, closed := parsePathData(path.D)
+
+	if path.Fill != "none" {
+		r.fillPolygons(subpaths, r.base, path.Fill, parseOpacity(path.FillOpacity))
+	}
+
+	if path.Stroke != "" && path.Stroke != "none" {
+		strokeWidth, err := strconv.ParseFloat(path.StrokeWidth, 64)
+		if err != nil {
+			strokeWidth = 1
+		}
+		// Close the stroked outline of closed subpaths
+		for i, subpath := range subpaths {
+			if closed[i] && len(subpath) > 1 {
+				subpaths[i] = append(subpath, subpath[0])
+			}
+		}
+		r.strokePolylines(subpaths, r.base, path.Stroke, strokeWidth, parseOpacity(path.StrokeOpacity))
+	}
+}
+
+// strokePolylines strokes polylines with round joins by filling one quad per segment and a disc per vertex
+func (r *rasterizer) strokePolylines(polylines [][]point, transform affine, paint string, width, opacity float64) {
+	half := width / 2
+	var polygons [][]point
+	for _, line := range polylines {
+		for i, p := range line {
+			polygons = append(polygons, circlePolygon(p, half, true))
+			if i == 0 {
+				continue
+			}
+			a := line[i-1]
+			dx, dy := p.X-a.X, p.Y-a.Y
+			length := math.Hypot(dx, dy)
+			if length == 0 {
+				continue
+			}
+			nx, ny := -dy/length*half, dx/length*half
+			polygons = append(polygons, []point{
+				{a.X + nx, a.Y + ny}, {p.X + nx, p.Y + ny}, {p.X - nx, p.Y - ny}, {a.X - nx, a.Y - ny},
+			})
+		}
+	}
+	r.fillPolygons(polygons, transform, paint, opacity)
+}
+
+// rasterEdge is a polygon edge in device space with its winding direction
+type rasterEdge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+// crossing is the intersection of a sub-scanline with an edge
+type crossing struct {
+	x   float64
+	dir int
+}
+
+// fillPolygons fills polygons with the nonzero winding rule and composites the paint
+func (r *rasterizer) fillPolygons(polygons [][]point, transform affine, paint string, opacity float64) {
+	c, ok := r.resolvePaint(paint)
+	if !ok || opacity <= 0 {
+		return
+	}
+
+	// Build device-space edges and their bounding box
+	var edges []rasterEdge
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, polygon := range polygons {
+		for i := range polygon {
+			a := transform.apply(polygon[i])
+			b := transform.apply(polygon[(i+1)%len(polygon)])
+			minX, maxX = min(minX, a.X), max(maxX, a.X)
+			minY, maxY = min(minY, a.Y), max(maxY, a.Y)
+			if a.Y == b.Y {
+				continue
+			}
+			if a.Y < b.Y {
+				edges = append(edges, rasterEdge{a.X, a.Y, b.X, b.Y, 1})
+			} else {
+				edges = append(edges, rasterEdge{b.X, b.Y, a.X, a.Y, -1})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+
+	rowStart := max(0, int(math.Floor(minY)))
+	rowEnd := min(r.height, int(math.Ceil(maxY)))
+	colStart := max(0, int(math.Floor(minX)))
+	colEnd := min(r.width, int(math.Ceil(maxX)))
+	if rowStart >= rowEnd || colStart >= colEnd {
+		return
+	}
+
+	crossings := make([]crossing, 0, 16)
+	for row := rowStart; row < rowEnd; row++ {
+		for sub := 0; sub < rasterSubsamples; sub++ {
+			y := float64(row) + (float64(sub)+0.5)/rasterSubsamples
+
+			crossings = crossings[:0]
+			for _, e := range edges {
+				if y < e.y0 || y >= e.y1 {
+					continue
+				}
+				x := e.x0 + (y-e.y0)/(e.y1-e.y0)*(e.x1-e.x0)
+				crossings = append(crossings, crossing{x, e.dir})
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for i, cr := range crossings {
+				winding += cr.dir
+				if winding != 0 && i+1 < len(crossings) {
+					r.addSpan(row, cr.x, crossings[i+1].x)
+				}
+			}
+		}
+	}
+
+	// Composite the accumulated coverage and clear it for the next shape
+	alpha := float64(c.A) / 255 * opacity
+	for row := rowStart; row < rowEnd; row++ {
+		for col := colStart; col < colEnd; col++ {
+			index := row*r.width + col
+			coverage := float64(min(r.cover[index], 1))
+			if coverage <= 0 {
+				continue
+			}
+			r.cover[index] = 0
+
+			a := coverage * alpha
+			offset := r.img.PixOffset(col, row)
+			pix := r.img.Pix[offset : offset+4 : offset+4]
+			pix[0] = uint8(math.Round(float64(c.R)*a + float64(pix[0])*(1-a)))
+			pix[1] = uint8(math.Round(float64(c.G)*a + float64(pix[1])*(1-a)))
+			pix[2] = uint8(math.Round(float64(c.B)*a + float64(pix[2])*(1-a)))
+			pix[3] = 0xff
+		}
+	}
+}
+
+// addSpan adds horizontal coverage for one sub-scanline, with fractional coverage at the span ends
+func (r *rasterizer) addSpan(row int, x0, x1 float64) {
+	x0 = max(x0, 0)
+	x1 = min(x1, float64(r.width))
+	if x1 <= x0 {
+		return
+	}
+
+	const weight = 1.0 / rasterSubsamples
+	line := r.cover[row*r.width : (row+1)*r.width]
+	first, last := int(x0), int(math.Ceil(x1))-1
+	if first == last {
+		line[first] += float32((x1 - x0) * weight)
+		return
+	}
+
+	line[first] += float32((float64(first+1) - x0) * weight)
+	for col := first + 1; col < last; col++ {
+		line[col] += weight
+	}
+	line[last] += float32((x1 - float64(last)) * weight)
+}
+
+// resolvePaint converts a fill or stroke attribute into a color
+func (r *rasterizer) resolvePaint(paint string) (color.NRGBA, bool) {
+	if strings.HasPrefix(paint, "url(#") {
+		id := strings.TrimSuffix(strings.TrimPrefix(paint, "url(#"), ")")
+		resolved, ok := r.gradients[id]
+		if !ok {
+			return color.NRGBA{}, false // patterns are not rasterized
+		}
+		paint = resolved
+	}
+
+	c, ok := parseRGBA(paint)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(math.Round(c.A * 0xff))}, true
+}
+
+// parseOpacity parses a fill-opacity or stroke-opacity attribute, defaulting to opaque
+func parseOpacity(value string) float64 {
+	if value == "" {
+		return 1
+	}
+	opacity, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 1
+	}
+	return math.Max(0, math.Min(1, opacity))
+}
+
+// averageGradientColor approximates a gradient by the mean of its stop colors
+func averageGradientColor(gradient *LinearGradientElement) string {
+	var sumR, sumG, sumB, count int
+	for _, stop := range gradient.Stops {
+		if red, green, blue, ok := parseColor(stop.StopColor); ok {
+			sumR += int(red)
+			sumG += int(green)
+			sumB += int(blue)
+			count++
+		}
+	}
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", sumR/count, sumG/count, sumB/count)
+}
+
+// circlePolygon approximates a circle; clockwise selects the orientation used by stroke quads
+func circlePolygon(center point, radius float64, clockwise bool) []point {
+	const steps = 16
+	polygon := make([]point, steps)
+	for i := range polygon {
+		angle := 2 * math.Pi * float64(i) / steps
+		if clockwise {
+			angle = -angle
+		}
+		polygon[i] = point{center.X + radius*math.Cos(angle), center.Y + radius*math.Sin(angle)}
+	}
+	return polygon
+}
+
+// flattenSegments converts outline segments into closed polygons after transforming them
+func flattenSegments(segments []segment, transform affine) [][]point {
+	var polygons [][]point
+	var current []point
+	var last point
+
+	for _, seg := range segments {
+		switch seg.Op {
+		case 'M':
+			if len(current) > 0 {
+				polygons = append(polygons, current)
+			}
+			last = transform.apply(seg.Pts[0])
+			current = []point{last}
+		case 'L':
+			last = transform.apply(seg.Pts[0])
+			current = append(current, last)
+		case 'Q':
+			control := transform.apply(seg.Pts[0])
+			end := transform.apply(seg.Pts[1])
+			current = appendQuadratic(current, last, control, end)
+			last = end
+		case 'Z':
+			if len(current) > 0 {
+				polygons = append(polygons, current)
+			}
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		polygons = append(polygons, current)
+	}
+
+	return polygons
+}
+
+// curveSteps is the number of line segments used to flatten each curve
+const curveSteps = 8
+
+// appendQuadratic flattens a quadratic Bezier curve, excluding its start point
+func appendQuadratic(points []point, p0, p1, p2 point) []point {
+	for i := 1; i <= curveSteps; i++ {
+		t := float64(i) / curveSteps
+		u := 1 - t
+		points = append(points, point{
+			u*u*p0.X + 2*u*t*p1.X + t*t*p2.X,
+			u*u*p0.Y + 2*u*t*p1.Y + t*t*p2.Y,
+		})
+	}
+	return points
+}
+
+// appendCubic flattens a cubic Bezier curve, excluding its start point
+func appendCubic(points []point, p0, p1, p2, p3 point) []point {
+	for i := 1; i <= curveSteps; i++ {
+		t := float64(i) / curveSteps
+		u := 1 - t
+		points = append(points, point{
+			u*u*u*p0.X + 3*u*u*t*p1.X + 3*u*t*t*p2.X + t*t*t*p3.X,
+			u*u*u*p0.Y + 3*u*u*t*p1.Y + 3*u*t*t*p2.Y + t*t*t*p3.Y,
+		})
+	}
+	return points
+}
+
+// parsePathData flattens SVG path data into polylines, reporting which subpaths were closed.
+// It supports the M, L, H, V, Q, T, C and Z commands in absolute and relative form.
+func parsePathData(d string) ([][]point, []bool) {
+	var subpaths [][]point
+	var closed []bool
+	var current []point
+	var pos, start, lastControl point
+	var prevCmd byte
+
+	tokens := tokenizePath(d)
+	var cmd byte
+	for i := 0; i < len(tokens); {
+		if isPathCommand(tokens[i]) {
+			cmd = tokens[i][0]
+			i++
+		} else if cmd == 'Z' || cmd == 'z' || cmd == 0 {
+			break // numbers without a command
+		}
+		relative := cmd >= 'a' && cmd <= 'z'
+		upper := cmd &^ 0x20
+
+		// Read the operands for one command repetition
+		count := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'Q': 4, 'T': 2, 'C': 6, 'Z': 0}[upper]
+		if upper != 'Z' && (count == 0 || i+count > len(tokens)) {
+			break
+		}
+		args := make([]float64, count)
+		for k := range args {
+			args[k], _ = strconv.ParseFloat(tokens[i+k], 64)
+		}
+		i += count
+
+		abs := func(x, y float64) point {
+			if relative {
+				return point{pos.X + x, pos.Y + y}
+			}
+			return point{x, y}
+		}
+
+		switch upper {
+		case 'M':
+			if len(current) > 0 {
+				subpaths = append(subpaths, current)
+				closed = append(closed, false)
+			}
+			pos = abs(args[0], args[1])
+			start = pos
+			current = []point{pos}
+			// Subsequent coordinate pairs are implicit line commands
+			if relative {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			pos = abs(args[0], args[1])
+			current = append(current, pos)
+		case 'H':
+			if relative {
+				pos.X += args[0]
+			} else {
+				pos.X = args[0]
+			}
+			current = append(current, pos)
+		case 'V':
+			if relative {
+				pos.Y += args[0]
+			} else {
+				pos.Y = args[0]
+			}
+			current = append(current, pos)
+		case 'Q':
+			control := abs(args[0], args[1])
+			end := abs(args[2], args[3])
+			current = appendQuadratic(current, pos, control, end)
+			lastControl, pos = control, end
+		case 'T':
+			control := pos
+			if prevCmd == 'Q' || prevCmd == 'T' {
+				control = point{2*pos.X - lastControl.X, 2*pos.Y - lastControl.Y}
+			}
+			end := abs(args[0], args[1])
+			current = appendQuadratic(current, pos, control, end)
+			lastControl, pos = control, end
+		case 'C':
+			c1 := abs(args[0], args[1])
+			c2 := abs(args[2], args[3])
+			end := abs(args[4], args[5])
+			current = appendCubic(current, pos, c1, c2, end)
+			pos = end
+		case 'Z':
+			if len(current) > 0 {
+				subpaths = append(subpaths, current)
+				closed = append(closed, true)
+			}
+			current = nil
+			pos = start
+		}
+		prevCmd = upper
+	}
+
+	if len(current) > 0 {
+		subpaths = append(subpaths, current)
+		closed = append(closed, false)
+	}
+	return subpaths, closed
+}
+
+// isPathCommand reports whether a token is a path command letter
+func isPathCommand(token string) bool {
+	return len(token) == 1 && strings.ContainsRune("MmLlHhVvQqTtCcZz", rune(token[0]))
+}
+
+// tokenizePath splits path data into command letters and numbers
+func tokenizePath(d string) []string {
+	var tokens []string
+	for i := 0; i < len(d); {
+		ch := d[i]
+		switch {
+		case ch == ' ' || ch == ',' || ch == '\n' || ch == '\t':
+			i++
+		case isPathCommand(string(ch)):
+			tokens = append(tokens, string(ch))
+			i++
+		default:
+			// A number ends at a separator, command, second sign or second decimal point
+			j := i + 1
+			seenDot := ch == '.'
+			for j < len(d) {
+				c := d[j]
+				if c == '.' {
+					if seenDot {
+						break
+					}
+					seenDot = true
+				} else if c == '-' || c == '+' {
+					if d[j-1] != 'e' && d[j-1] != 'E' {
+						break
+					}
+				} else if !(c >= '0' && c <= '9' || c == 'e' || c == 'E') {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, d[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// parseTransform parses an SVG transform list of rotate, translate, scale and matrix functions
+func parseTransform(value string) affine {
+	result := affine{a: 1, d: 1}
+	for _, part := range strings.Split(value, ")") {
+		name, argText, ok := strings.Cut(strings.TrimSpace(part), "(")
+		if !ok {
+			continue
+		}
+		var args []float64
+		for _, field := range strings.FieldsFunc(argText, func(r rune) bool { return r == ' ' || r == ',' }) {
+			if v, err := strconv.ParseFloat(field, 64); err == nil {
+				args = append(args, v)
+			}
+		}
+
+		var m affine
+		switch strings.TrimSpace(name) {
+		case "rotate":
+			if len(args) == 0 {
+				continue
+			}
+			theta := args[0] * math.Pi / 180
+			m = affine{a: math.Cos(theta), b: math.Sin(theta), c: -math.Sin(theta), d: math.Cos(theta)}
+			if len(args) == 3 {
+				m = translate(-args[1], -args[2]).then(m).then(translate(args[1], args[2]))
+			}
+		case "translate":
+			if len(args) == 0 {
+				continue
+			}
+			ty := 0.0
+			if len(args) > 1 {
+				ty = args[1]
+			}
+			m = translate(args[0], ty)
+		case "scale":
+			if len(args) == 0 {
+				continue
+			}
+			sy := args[0]
+			if len(args) > 1 {
+				sy = args[1]
+			}
+			m = affine{a: args[0], d: sy}
+		case "matrix":
+			if len(args) != 6 {
+				continue
+			}
+			m = affine{args[0], args[1], args[2], args[3], args[4], args[5]}
+		default:
+			continue
+		}
+		// Transform lists apply right to left
+		result = m.then(result)
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package captcha
+
+import (
+	"sync"
+	"time"
+)
+
+// Default refresh limit of a Service
+const (
+	DefaultRefreshLimit  = 5
+	DefaultRefreshWindow = time.Minute
+)
+
+// RateLimiter allows a number of events per key in fixed windows, for example refreshes per
+// session. It is safe for concurrent use.
+type RateLimiter struct {
+	limit   int
+	window  time.Duration
+	windows map[string]rateWindow
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+// rateWindow counts the events of one key since start
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a limiter allowing limit events per key in every window
+func NewRateLimiter(limit int, window time.Duration) (*RateLimiter, error) {
+	if limit <= 0 || window <= 0 {
+		return nil, NewError(ErrInvalidConfig, "rate limit and window must be positive", 400)
+	}
+	return &RateLimiter{limit: limit, window: window, windows: make(map[string]rateWindow), now: time.Now}, nil
+}
+
+// Allow counts an event for key. If the key used up its limit, the event is not counted and
+// Allow returns false with the time until the key's window ends.
+func (rl *RateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	w, ok := rl.windows[key]
+	if !ok || now.Sub(w.start) >= rl.window {
+		w = rateWindow{start: now}
+	}
+	if w.count >= rl.limit {
+		return false, w.start.Add(rl.window).Sub(now)
+	}
+	w.count++
+	rl.windows[key] = w
+	return true, 0
+}
+
+// Cleanup forgets keys whose window ended before now and returns how many were removed
+func (rl *RateLimiter) Cleanup(now time.Time) int {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	removed := 0
+	for key, w := range rl.windows {
+		if now.Sub(w.start) >= rl.window {
+			delete(rl.windows, key)
+			removed++
+		}
+	}
+	return removed
+}
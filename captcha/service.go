@@ -0,0 +1,318 @@
+package captcha
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// DefaultChallengeTTL is how long an issued captcha can be verified
+const DefaultChallengeTTL = 5 * time.Minute
+
+// VerifyResult is the outcome of Service.Verify
+type VerifyResult string
+
+// Verification outcomes
+const (
+	VerifySuccess  VerifyResult = "success"   // The answer matched
+	VerifyFailure  VerifyResult = "failure"   // The answer was wrong
+	VerifyExpired  VerifyResult = "expired"   // The captcha expired before verification
+	VerifyNotFound VerifyResult = "not_found" // Unknown ID, or the captcha was already verified
+)
+
+// Challenge is an issued captcha: the ID to verify against and the image to show. The answer
+// is kept in the store and never part of the challenge.
+type Challenge struct {
+	ID        string         `json:"id"`
+	Result    *CaptchaResult `json:"-"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+}
+
+// Service issues captchas and verifies answers against a Store
+type Service struct {
+	generator *CaptchaGenerator
+	store     Store
+	ttl       time.Duration
+	metrics   Metrics
+	refreshes *RateLimiter
+}
+
+// NewService creates a service that issues captchas from generator and keeps answers in
+// store for DefaultChallengeTTL, allowing clients DefaultRefreshLimit refreshes per
+// DefaultRefreshWindow. A nil store uses a new MemoryStore.
+func NewService(generator *CaptchaGenerator, store Store) *Service {
+	if generator == nil {
+		generator = NewCaptchaGenerator(DefaultConfig())
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	refreshes, _ := NewRateLimiter(DefaultRefreshLimit, DefaultRefreshWindow)
+	return &Service{
+		generator: generator,
+		store:     store,
+		ttl:       DefaultChallengeTTL,
+		refreshes: refreshes,
+	}
+}
+
+// SetTTL changes how long issued captchas stay valid
+func (s *Service) SetTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return NewError(ErrInvalidConfig, "TTL must be positive", 400)
+	}
+	s.ttl = ttl
+	return nil
+}
+
+// SetRefreshLimit changes how many captchas RefreshClientContext and IssueWithOptionsContext
+// with a client allow each client per window; a limit of 0 removes the cap
+func (s *Service) SetRefreshLimit(limit int, window time.Duration) error {
+	if limit == 0 {
+		s.refreshes = nil
+		return nil
+	}
+	refreshes, err := NewRateLimiter(limit, window)
+	if err != nil {
+		return err
+	}
+	s.refreshes = refreshes
+	return nil
+}
+
+// SetMetrics reports verification outcomes and store size to metrics, and instruments the
+// generator's generation path with the same hook
+func (s *Service) SetMetrics(metrics Metrics) {
+	s.metrics = metrics
+	s.generator.SetMetrics(metrics)
+}
+
+// Generator returns the generator used to issue captchas
+func (s *Service) Generator() *CaptchaGenerator {
+	return s.generator
+}
+
+// Store returns the store holding issued answers
+func (s *Service) Store() Store {
+	return s.store
+}
+
+// Issue generates a captcha with the generator's configuration and stores its answer
+func (s *Service) Issue() (*Challenge, error) {
+	return s.IssueContext(context.Background())
+}
+
+// IssueContext is Issue reporting spans as children of any span in ctx
+func (s *Service) IssueContext(ctx context.Context) (*Challenge, error) {
+	return s.issue(ctx, nil, "")
+}
+
+// IssueWithTheme generates a captcha in the named theme and stores its answer
+func (s *Service) IssueWithTheme(theme string) (*Challenge, error) {
+	return s.IssueWithThemeContext(context.Background(), theme)
+}
+
+// IssueWithThemeContext is IssueWithTheme reporting spans as children of any span in ctx
+func (s *Service) IssueWithThemeContext(ctx context.Context, theme string) (*Challenge, error) {
+	opts := s.generator.GetConfig()
+	if err := opts.ApplyTheme(theme); err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, opts, "")
+}
+
+// Refresh replaces the captcha issued under id with a new one, for users who cannot read it.
+// The old captcha is invalidated whether or not it was still valid.
+func (s *Service) Refresh(id string) (*Challenge, error) {
+	return s.RefreshWithThemeContext(context.Background(), id, "")
+}
+
+// RefreshContext is Refresh reporting spans as children of any span in ctx
+func (s *Service) RefreshContext(ctx context.Context, id string) (*Challenge, error) {
+	return s.RefreshWithThemeContext(ctx, id, "")
+}
+
+// RefreshWithThemeContext is RefreshContext issuing the new captcha in the named theme, or the
+// generator's colors if theme is empty. An empty id only issues a new captcha.
+func (s *Service) RefreshWithThemeContext(ctx context.Context, id, theme string) (*Challenge, error) {
+	return s.IssueWithOptionsContext(ctx, IssueOptions{Theme: theme, Replace: id})
+}
+
+// RefreshClientContext is RefreshWithThemeContext counting against the refresh limit of client,
+// such as a session ID, so bots cannot cycle captchas until they get an easy one. Refreshes
+// beyond the limit return an ErrRateLimited error, answered with 429, and leave the old captcha
+// valid.
+func (s *Service) RefreshClientContext(ctx context.Context, client, id, theme string) (*Challenge, error) {
+	return s.IssueWithOptionsContext(ctx, IssueOptions{Theme: theme, Replace: id, Client: client})
+}
+
+// IssueOptions controls how IssueWithOptionsContext issues a captcha
+type IssueOptions struct {
+	Theme   string // Named theme of the captcha (default: the generator's colors)
+	Replace string // ID of a previous captcha to invalidate, as Refresh does (default: none)
+	// Client the captcha counts against in the refresh limit, such as a session ID or client
+	// address (default: none, unlimited)
+	Client string
+	// Session to bind the captcha to: only VerifySessionContext with the same session can
+	// verify it, VerifyContext treats it as not found (default: none)
+	Session string
+}
+
+// IssueWithOptionsContext issues a captcha as selected by opts, the general form of the Issue
+// and Refresh methods. The captcha to replace is only invalidated once the new one is issued:
+// an unknown theme, a failure or a request beyond the refresh limit of opts.Client, which
+// returns an ErrRateLimited error answered with 429, leave it valid.
+func (s *Service) IssueWithOptionsContext(ctx context.Context, opts IssueOptions) (*Challenge, error) {
+	if s.refreshes != nil && opts.Client != "" {
+		if ok, retryAfter := s.refreshes.Allow(opts.Client); !ok {
+			s.generator.logger.LogAttrs(ctx, slog.LevelDebug, "captcha refresh rate limited",
+				slog.Duration("retry_after", retryAfter))
+			err := NewError(ErrRateLimited, "too many captcha refreshes", 429)
+			err.RetryAfter = retryAfter
+			return nil, err
+		}
+	}
+
+	var config *Config
+	if opts.Theme != "" {
+		config = s.generator.GetConfig()
+		if err := config.ApplyTheme(opts.Theme); err != nil {
+			return nil, err
+		}
+	}
+
+	// The old captcha stays valid until the new one is stored, so failed requests keep it
+	ctx = s.generator.traceContext(ctx)
+	challenge, err := s.issue(ctx, config, opts.Session)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Replace != "" {
+		_, span := startSpan(ctx, SpanStoreTake)
+		_, _, err := s.store.Take(opts.Replace)
+		endSpan(span, err)
+		if err != nil {
+			return nil, WrapError(ErrRenderFailed, "failed to invalidate captcha", 500, err)
+		}
+		s.observeStoreSize()
+	}
+	return challenge, nil
+}
+
+// issue generates a captcha with opts, or the generator's configuration if nil, and stores its
+// answer, bound to session if not empty, under a new random ID
+func (s *Service) issue(ctx context.Context, opts *Config, session string) (*Challenge, error) {
+	ctx = s.generator.traceContext(ctx)
+	result, err := s.generator.CreateMathExprContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newChallengeID()
+	if err != nil {
+		return nil, WrapError(ErrRenderFailed, "failed to generate captcha ID", 500, err)
+	}
+
+	expiresAt := time.Now().Add(s.ttl)
+	_, span := startSpan(ctx, SpanStoreSet)
+	err = s.store.Set(id, StoreEntry{Answer: result.Text, ExpiresAt: expiresAt, Session: session})
+	endSpan(span, err)
+	if err != nil {
+		return nil, WrapError(ErrRenderFailed, "failed to store captcha", 500, err)
+	}
+	s.observeStoreSize()
+
+	return &Challenge{ID: id, Result: result, ExpiresAt: expiresAt}, nil
+}
+
+// Verify checks answer against the captcha issued under id. The captcha is consumed whatever
+// the outcome, so each one allows a single attempt.
+func (s *Service) Verify(id, answer string) (VerifyResult, error) {
+	return s.VerifyContext(context.Background(), id, answer)
+}
+
+// VerifyContext is Verify reporting spans as children of any span in ctx. Captchas bound to a
+// session count as not found: they are verified with VerifySessionContext.
+func (s *Service) VerifyContext(ctx context.Context, id, answer string) (VerifyResult, error) {
+	return s.verify(ctx, "", id, answer)
+}
+
+// VerifySessionContext is VerifyContext for a captcha issued to session with
+// IssueWithOptionsContext. Captchas of other sessions, or of none, count as not found.
+func (s *Service) VerifySessionContext(ctx context.Context, session, id, answer string) (VerifyResult, error) {
+	return s.verify(ctx, session, id, answer)
+}
+
+// verify checks answer against the captcha issued to session under id
+func (s *Service) verify(ctx context.Context, session, id, answer string) (result VerifyResult, err error) {
+	ctx, span := startSpan(s.generator.traceContext(ctx), SpanVerify)
+	defer func() {
+		if err == nil && span.IsRecording() {
+			span.SetAttributes(StringAttribute("captcha.verify.result", string(result)))
+		}
+		endSpan(span, err)
+	}()
+
+	_, takeSpan := startSpan(ctx, SpanStoreTake)
+	entry, ok, err := s.store.Take(id)
+	endSpan(takeSpan, err)
+	if err != nil {
+		return VerifyFailure, WrapError(ErrRenderFailed, "failed to load captcha", 500, err)
+	}
+
+	result = VerifySuccess
+	switch {
+	case !ok, entry.Session != session:
+		result = VerifyNotFound
+	case entry.Expired(time.Now()):
+		result = VerifyExpired
+	case subtle.ConstantTimeCompare([]byte(entry.Answer), []byte(strings.TrimSpace(answer))) != 1:
+		result = VerifyFailure
+	}
+
+	if s.metrics != nil {
+		s.metrics.ObserveVerification(result)
+	}
+	s.observeStoreSize()
+	s.generator.logger.LogAttrs(ctx, slog.LevelDebug, "captcha verified",
+		slog.String("result", string(result)))
+	return result, nil
+}
+
+// Cleanup removes expired captchas if the store supports it, like MemoryStore, and returns how
+// many were removed. It also forgets refresh counts of ended windows.
+func (s *Service) Cleanup() int {
+	if s.refreshes != nil {
+		s.refreshes.Cleanup(time.Now())
+	}
+
+	cleaner, ok := s.store.(interface{ Cleanup(now time.Time) int })
+	if !ok {
+		return 0
+	}
+
+	removed := cleaner.Cleanup(time.Now())
+	s.observeStoreSize()
+	return removed
+}
+
+// observeStoreSize reports the store size to the metrics hook
+func (s *Service) observeStoreSize() {
+	if s.metrics != nil {
+		s.metrics.SetStoreSize(s.store.Len())
+	}
+}
+
+// newChallengeID returns 128 random bits in hex
+func newChallengeID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,83 @@
+package captcha
+
+import (
+	"sync"
+	"time"
+)
+
+// StoreEntry is an issued captcha awaiting verification
+type StoreEntry struct {
+	Answer    string    `json:"answer"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Session the captcha was issued to, if any: only VerifySessionContext with that session
+	// can verify it
+	Session string `json:"session,omitempty"`
+}
+
+// Expired reports whether the entry is no longer valid at now
+func (e StoreEntry) Expired(now time.Time) bool {
+	return now.After(e.ExpiresAt)
+}
+
+// Store keeps captcha answers between issue and verification. Implementations must be safe for
+// concurrent use; Take removes the entry so every captcha can be verified only once.
+type Store interface {
+	Set(id string, entry StoreEntry) error
+	Take(id string) (StoreEntry, bool, error)
+	Len() int
+}
+
+// MemoryStore is an in-process Store backed by a map
+type MemoryStore struct {
+	entries map[string]StoreEntry
+	mutex   sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]StoreEntry)}
+}
+
+// Set stores the entry under id, replacing any previous entry
+func (ms *MemoryStore) Set(id string, entry StoreEntry) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.entries[id] = entry
+	return nil
+}
+
+// Take returns and removes the entry stored under id
+func (ms *MemoryStore) Take(id string) (StoreEntry, bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	entry, ok := ms.entries[id]
+	if ok {
+		delete(ms.entries, id)
+	}
+	return entry, ok, nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet cleaned up
+func (ms *MemoryStore) Len() int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return len(ms.entries)
+}
+
+// Cleanup removes entries that expired before now and returns how many were removed
+func (ms *MemoryStore) Cleanup(now time.Time) int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	removed := 0
+	for id, entry := range ms.entries {
+		if entry.Expired(now) {
+			delete(ms.entries, id)
+			removed++
+		}
+	}
+	return removed
+}
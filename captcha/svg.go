@@ -1,9 +1,14 @@
 package captcha
 
 import (
+	"context"
 	"encoding/xml"
-	"fmt"
+	"io"
+	"math"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // SVGElement represents the root SVG element
@@ -13,23 +18,51 @@ type SVGElement struct {
 	Height     int              `xml:"height,attr"`
 	ViewBox    string           `xml:"viewBox,attr"`
 	Xmlns      string           `xml:"xmlns,attr"`
+	Defs       *DefsElement     `xml:"defs,omitempty"`
 	Background *RectElement     `xml:"rect,omitempty"`
+	Groups     []*GroupElement  `xml:"g,omitempty"`
 	Texts      []*TextElement   `xml:"text,omitempty"`
 	Paths      []*PathElement   `xml:"path,omitempty"`
 	Lines      []*LineElement   `xml:"line,omitempty"`
 	Circles    []*CircleElement `xml:"circle,omitempty"`
+
+	textBounds Bounds // Area covered by the rendered text, used to target noise
+}
+
+// Bounds is an axis-aligned bounding box in SVG user space
+type Bounds struct {
+	MinX, MinY, MaxX, MaxY float64
+	valid                  bool
+}
+
+// Extend grows the bounds to include a point
+func (b *Bounds) Extend(x, y float64) {
+	if !b.valid {
+		*b = Bounds{MinX: x, MinY: y, MaxX: x, MaxY: y, valid: true}
+		return
+	}
+	b.MinX = min(b.MinX, x)
+	b.MinY = min(b.MinY, y)
+	b.MaxX = max(b.MaxX, x)
+	b.MaxY = max(b.MaxY, y)
+}
+
+// Empty reports whether no point has been added to the bounds
+func (b Bounds) Empty() bool {
+	return !b.valid
 }
 
 // TextElement represents an SVG text element
 type TextElement struct {
-	XMLName    xml.Name `xml:"text"`
-	X          float64  `xml:"x,attr"`
-	Y          float64  `xml:"y,attr"`
-	Fill       string   `xml:"fill,attr"`
-	FontSize   int      `xml:"font-size,attr"`
-	FontFamily string   `xml:"font-family,attr"`
-	Transform  string   `xml:"transform,attr,omitempty"`
-	Content    string   `xml:",chardata"`
+	XMLName     xml.Name `xml:"text"`
+	X           float64  `xml:"x,attr"`
+	Y           float64  `xml:"y,attr"`
+	Fill        string   `xml:"fill,attr"`
+	FillOpacity string   `xml:"fill-opacity,attr,omitempty"`
+	FontSize    int      `xml:"font-size,attr"`
+	FontFamily  string   `xml:"font-family,attr"`
+	Transform   string   `xml:"transform,attr,omitempty"`
+	Content     string   `xml:",chardata"`
 }
 
 // RectElement represents an SVG rectangle
@@ -42,33 +75,142 @@ type RectElement struct {
 	Fill    string   `xml:"fill,attr"`
 }
 
+// DefsElement holds reusable definitions referenced by other elements
+type DefsElement struct {
+	XMLName         xml.Name                 `xml:"defs"`
+	Filters         []*FilterElement         `xml:"filter,omitempty"`
+	LinearGradients []*LinearGradientElement `xml:"linearGradient,omitempty"`
+	Patterns        []*PatternElement        `xml:"pattern,omitempty"`
+}
+
+// FilterElement represents an SVG filter chain; primitives are applied in field order
+type FilterElement struct {
+	XMLName      xml.Name                `xml:"filter"`
+	ID           string                  `xml:"id,attr"`
+	X            string                  `xml:"x,attr,omitempty"`
+	Y            string                  `xml:"y,attr,omitempty"`
+	Width        string                  `xml:"width,attr,omitempty"`
+	Height       string                  `xml:"height,attr,omitempty"`
+	Turbulence   *TurbulenceElement      `xml:"feTurbulence,omitempty"`
+	Displacement *DisplacementMapElement `xml:"feDisplacementMap,omitempty"`
+	Blur         *GaussianBlurElement    `xml:"feGaussianBlur,omitempty"`
+}
+
+// TurbulenceElement represents an feTurbulence filter primitive
+type TurbulenceElement struct {
+	XMLName       xml.Name `xml:"feTurbulence"`
+	Type          string   `xml:"type,attr"`
+	BaseFrequency string   `xml:"baseFrequency,attr"`
+	NumOctaves    int      `xml:"numOctaves,attr"`
+	Seed          int      `xml:"seed,attr"`
+	Result        string   `xml:"result,attr"`
+}
+
+// DisplacementMapElement represents an feDisplacementMap filter primitive
+type DisplacementMapElement struct {
+	XMLName          xml.Name `xml:"feDisplacementMap"`
+	In               string   `xml:"in,attr"`
+	In2              string   `xml:"in2,attr"`
+	Scale            string   `xml:"scale,attr"`
+	XChannelSelector string   `xml:"xChannelSelector,attr"`
+	YChannelSelector string   `xml:"yChannelSelector,attr"`
+	Result           string   `xml:"result,attr,omitempty"`
+}
+
+// GaussianBlurElement represents an feGaussianBlur filter primitive
+type GaussianBlurElement struct {
+	XMLName      xml.Name `xml:"feGaussianBlur"`
+	In           string   `xml:"in,attr,omitempty"`
+	StdDeviation string   `xml:"stdDeviation,attr"`
+}
+
+// LinearGradientElement represents an SVG linear gradient
+type LinearGradientElement struct {
+	XMLName xml.Name       `xml:"linearGradient"`
+	ID      string         `xml:"id,attr"`
+	X1      string         `xml:"x1,attr"`
+	Y1      string         `xml:"y1,attr"`
+	X2      string         `xml:"x2,attr"`
+	Y2      string         `xml:"y2,attr"`
+	Stops   []*StopElement `xml:"stop"`
+}
+
+// StopElement represents a gradient color stop
+type StopElement struct {
+	XMLName   xml.Name `xml:"stop"`
+	Offset    string   `xml:"offset,attr"`
+	StopColor string   `xml:"stop-color,attr"`
+}
+
+// PatternElement represents a repeating SVG pattern tile
+type PatternElement struct {
+	XMLName          xml.Name         `xml:"pattern"`
+	ID               string           `xml:"id,attr"`
+	Width            float64          `xml:"width,attr"`
+	Height           float64          `xml:"height,attr"`
+	PatternUnits     string           `xml:"patternUnits,attr"`
+	PatternTransform string           `xml:"patternTransform,attr,omitempty"`
+	Paths            []*PathElement   `xml:"path,omitempty"`
+	Circles          []*CircleElement `xml:"circle,omitempty"`
+}
+
+// GroupElement represents an SVG group, used to apply a filter to a layer of elements
+type GroupElement struct {
+	XMLName xml.Name         `xml:"g"`
+	ID      string           `xml:"id,attr,omitempty"`
+	Filter  string           `xml:"filter,attr,omitempty"`
+	Rects   []*RectElement   `xml:"rect,omitempty"`
+	Texts   []*TextElement   `xml:"text,omitempty"`
+	Paths   []*PathElement   `xml:"path,omitempty"`
+	Circles []*CircleElement `xml:"circle,omitempty"`
+}
+
 // PathElement represents an SVG path (for text rendering)
 type PathElement struct {
-	XMLName     xml.Name `xml:"path"`
-	D           string   `xml:"d,attr"`
-	Fill        string   `xml:"fill,attr"`
-	Stroke      string   `xml:"stroke,attr,omitempty"`
-	StrokeWidth string   `xml:"stroke-width,attr,omitempty"`
+	XMLName       xml.Name `xml:"path"`
+	D             string   `xml:"d,attr"`
+	Fill          string   `xml:"fill,attr"`
+	FillOpacity   string   `xml:"fill-opacity,attr,omitempty"`
+	Stroke        string   `xml:"stroke,attr,omitempty"`
+	StrokeWidth   string   `xml:"stroke-width,attr,omitempty"`
+	StrokeOpacity string   `xml:"stroke-opacity,attr,omitempty"`
 }
 
 // LineElement represents an SVG line (for noise)
 type LineElement struct {
-	XMLName xml.Name `xml:"line"`
-	X1      float64  `xml:"x1,attr"`
-	Y1      float64  `xml:"y1,attr"`
-	X2      float64  `xml:"x2,attr"`
-	Y2      float64  `xml:"y2,attr"`
-	Stroke  string   `xml:"stroke,attr"`
-	Width   float64  `xml:"stroke-width,attr"`
+	XMLName       xml.Name `xml:"line"`
+	X1            float64  `xml:"x1,attr"`
+	Y1            float64  `xml:"y1,attr"`
+	X2            float64  `xml:"x2,attr"`
+	Y2            float64  `xml:"y2,attr"`
+	Stroke        string   `xml:"stroke,attr"`
+	Width         float64  `xml:"stroke-width,attr"`
+	StrokeOpacity string   `xml:"stroke-opacity,attr,omitempty"`
 }
 
 // CircleElement represents an SVG circle (for noise)
 type CircleElement struct {
-	XMLName xml.Name `xml:"circle"`
-	CX      float64  `xml:"cx,attr"`
-	CY      float64  `xml:"cy,attr"`
-	R       float64  `xml:"r,attr"`
-	Fill    string   `xml:"fill,attr"`
+	XMLName     xml.Name `xml:"circle"`
+	CX          float64  `xml:"cx,attr"`
+	CY          float64  `xml:"cy,attr"`
+	R           float64  `xml:"r,attr"`
+	Fill        string   `xml:"fill,attr"`
+	FillOpacity string   `xml:"fill-opacity,attr,omitempty"`
+}
+
+// Renderer turns a math expression into an SVG document. SVGRenderer is the default
+// implementation; WithRenderer injects another one.
+type Renderer interface {
+	RenderMathExpression(expr *MathExpression, config *Config) (string, error)
+	WriteMathExpression(w io.Writer, expr *MathExpression, config *Config) (int64, error)
+}
+
+// ContextRenderer is a Renderer that also accepts a context, which carries the tracer for spans
+// reported while rendering
+type ContextRenderer interface {
+	Renderer
+	RenderMathExpressionContext(ctx context.Context, expr *MathExpression, config *Config) (string, error)
+	WriteMathExpressionContext(ctx context.Context, w io.Writer, expr *MathExpression, config *Config) (int64, error)
 }
 
 // SVGRenderer handles the generation of SVG content
@@ -91,48 +233,133 @@ func NewSVGRenderer(config *Config) *SVGRenderer {
 
 // RenderMathExpression converts a math expression into SVG format
 func (sr *SVGRenderer) RenderMathExpression(expr *MathExpression, config *Config) (string, error) {
+	return sr.RenderMathExpressionContext(context.Background(), expr, config)
+}
+
+// RenderMathExpressionContext is RenderMathExpression reporting spans to the tracer in ctx
+func (sr *SVGRenderer) RenderMathExpressionContext(ctx context.Context, expr *MathExpression, config *Config) (string, error) {
+	svg, err := sr.buildMathExpression(ctx, expr, config)
+	if err != nil {
+		return "", err
+	}
+	defer releaseSVG(svg)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := encodeSVG(buf, svg, config); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// WriteMathExpression renders a math expression and streams the SVG document to w. The document
+// is encoded into a pooled buffer first, so nothing is written to w if rendering fails.
+func (sr *SVGRenderer) WriteMathExpression(w io.Writer, expr *MathExpression, config *Config) (int64, error) {
+	return sr.WriteMathExpressionContext(context.Background(), w, expr, config)
+}
+
+// WriteMathExpressionContext is WriteMathExpression reporting spans to the tracer in ctx
+func (sr *SVGRenderer) WriteMathExpressionContext(ctx context.Context, w io.Writer, expr *MathExpression, config *Config) (int64, error) {
+	svg, err := sr.buildMathExpression(ctx, expr, config)
+	if err != nil {
+		return 0, err
+	}
+	defer releaseSVG(svg)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := encodeSVG(buf, svg, config); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+// buildMathExpression assembles the SVG element tree of a math expression
+func (sr *SVGRenderer) buildMathExpression(ctx context.Context, expr *MathExpression, config *Config) (*SVGElement, error) {
 	// Create SVG container
 	svg := sr.createSVGContainer(config)
 
+	// Scatter decoy characters behind the expression
+	if err := sr.addDecoysToSVG(svg, config); err != nil {
+		releaseSVG(svg)
+		return nil, WrapError(ErrSVGGeneration, "failed to add decoys to SVG", 500, err)
+	}
+
 	// Generate text paths for the expression
 	questionText := strings.Replace(expr.Question, " = ?", " = ", 1)
 	err := sr.addTextToSVG(svg, questionText, config)
 	if err != nil {
-		return "", NewError(ErrSVGGeneration, "failed to add text to SVG: "+err.Error(), 500)
+		releaseSVG(svg)
+		return nil, WrapError(ErrSVGGeneration, "failed to add text to SVG", 500, err)
 	}
 
+	// Apply filter effects before noise so only the background and text layers are affected
+	sr.addEffectsToSVG(svg, config)
+
 	// Add noise elements
+	_, span := startSpan(ctx, SpanNoise)
 	sr.addNoiseToSVG(svg, config)
-
-	// Convert to XML
-	xmlData, err := xml.MarshalIndent(svg, "", "  ")
-	if err != nil {
-		return "", NewError(ErrSVGGeneration, "failed to marshal SVG to XML: "+err.Error(), 500)
+	if span.IsRecording() {
+		span.SetAttributes(IntAttribute("captcha.noise.level", config.Noise))
 	}
+	span.End()
+
+	return svg, nil
+}
 
-	return xml.Header + string(xmlData), nil
+// svgPool recycles element trees between renders so their slices keep their capacity
+var svgPool = sync.Pool{
+	New: func() any { return new(SVGElement) },
+}
+
+// releaseSVG clears svg and returns it to the pool; svg must not be used afterwards
+func releaseSVG(svg *SVGElement) {
+	clear(svg.Groups)
+	clear(svg.Texts)
+	clear(svg.Paths)
+	clear(svg.Lines)
+	clear(svg.Circles)
+	*svg = SVGElement{
+		Background: svg.Background,
+		Groups:     svg.Groups[:0],
+		Texts:      svg.Texts[:0],
+		Paths:      svg.Paths[:0],
+		Lines:      svg.Lines[:0],
+		Circles:    svg.Circles[:0],
+	}
+	svgPool.Put(svg)
 }
 
 // createSVGContainer creates the base SVG element with background
 func (sr *SVGRenderer) createSVGContainer(config *Config) *SVGElement {
-	svg := &SVGElement{
-		Width:   sr.width,
-		Height:  sr.height,
-		ViewBox: fmt.Sprintf("0 0 %d %d", sr.width, sr.height),
-		Xmlns:   "http://www.w3.org/2000/svg",
-		Background: &RectElement{
-			X:      0,
-			Y:      0,
-			Width:  sr.width,
-			Height: sr.height,
-			Fill:   config.Background,
-		},
+	svg := svgPool.Get().(*SVGElement)
+	background := svg.Background
+	if background == nil {
+		background = &RectElement{}
 	}
+	*background = RectElement{
+		X:      0,
+		Y:      0,
+		Width:  sr.width,
+		Height: sr.height,
+		Fill:   config.Background,
+	}
+
+	svg.Width = sr.width
+	svg.Height = sr.height
+	svg.ViewBox = "0 0 " + strconv.Itoa(sr.width) + " " + strconv.Itoa(sr.height)
+	svg.Xmlns = "http://www.w3.org/2000/svg"
+	svg.Background = background
 	return svg
 }
 
-// addTextToSVG adds the math expression text as SVG text elements
+// addTextToSVG adds the math expression text as SVG text elements, or as distorted
+// glyph outlines when any distortion filter is enabled
 func (sr *SVGRenderer) addTextToSVG(svg *SVGElement, text string, config *Config) error {
+	if config.Distortion.Enabled() {
+		return sr.addGlyphPathsToSVG(svg, text, config)
+	}
+
 	// Calculate positioning
 	textLen := len(text)
 	charWidth := float64(sr.fontSize) * 0.6 // Approximate character width
@@ -147,6 +374,7 @@ func (sr *SVGRenderer) addTextToSVG(svg *SVGElement, text string, config *Config
 	}
 
 	// Render each character as a text element
+	elements := make([]TextElement, len(text)) // Backing array for all characters in one allocation
 	for i, char := range text {
 		if char == ' ' {
 			continue // Skip spaces
@@ -163,22 +391,44 @@ func (sr *SVGRenderer) addTextToSVG(svg *SVGElement, text string, config *Config
 
 		// Add random rotation
 		rotation, _ := secureRandomFloat(-15, 15)
-		transform := fmt.Sprintf("rotate(%.1f %.2f %.2f)", rotation, charX, charY)
+		transform := formatRotate(rotation, charX, charY)
 
 		// Create text element
-		textElement := &TextElement{
-			X:          charX,
-			Y:          charY,
-			Fill:       sr.colorMgr.GetRandomTextColor(),
-			FontSize:   sr.fontSize,
-			FontFamily: "Arial, sans-serif",
-			Transform:  transform,
-			Content:    string(char),
+		color := sr.colorMgr.GetRandomTextRGBA()
+		textElement := &elements[i]
+		*textElement = TextElement{
+			X:           charX,
+			Y:           charY,
+			Fill:        color.Hex(),
+			FillOpacity: color.opacityAttr(),
+			FontSize:    sr.fontSize,
+			FontFamily:  "Arial, sans-serif",
+			Transform:   transform,
+			Content:     text[i : i+utf8.RuneLen(char)],
 		}
 
 		svg.Texts = append(svg.Texts, textElement)
+		svg.textBounds.Extend(charX, charY-float64(sr.fontSize)*0.7)
+		svg.textBounds.Extend(charX+charWidth, charY)
+	}
+
+	return nil
+}
+
+// addGlyphPathsToSVG adds the text as glyph outline paths with the configured distortion filters
+func (sr *SVGRenderer) addGlyphPathsToSVG(svg *SVGElement, text string, config *Config) error {
+	distorter, err := NewDistorter(config.Distortion)
+	if err != nil {
+		return err
+	}
+
+	paths, bounds, err := distorter.RenderText(text, sr.width, sr.height, sr.fontSize, sr.colorMgr)
+	if err != nil {
+		return err
 	}
+	svg.textBounds = bounds
 
+	svg.Paths = append(svg.Paths, paths...)
 	return nil
 }
 
@@ -197,26 +447,24 @@ func (sr *SVGRenderer) addNoiseToSVG(svg *SVGElement, config *Config) {
 
 	noiseGen := NewNoiseGenerator()
 
+	// Force a fraction of the lines through the text so they cannot be filtered out by position
+	lineCount := config.Noise * 2
+	forced := 0
+	if !svg.textBounds.Empty() {
+		forced = int(math.Round(float64(lineCount) * config.NoiseIntersect))
+	}
+	strikeCount := (forced + 1) / 2
+
 	// Add random curved lines (now using PathElements)
-	curvedLines := noiseGen.GenerateLines(config.Noise*2, sr.width, sr.height, sr.colorMgr)
+	curvedLines := noiseGen.GenerateLines(lineCount-forced, sr.width, sr.height, sr.colorMgr)
 	svg.Paths = append(svg.Paths, curvedLines...)
 
+	// Add text-colored curves and stem-width strokes across the glyphs
+	stemWidth := float64(sr.fontSize) * 0.1
+	svg.Paths = append(svg.Paths, noiseGen.GenerateStrikeThroughs(strikeCount, svg.textBounds, stemWidth, sr.colorMgr)...)
+	svg.Paths = append(svg.Paths, noiseGen.GenerateOcclusions(forced-strikeCount, svg.textBounds, stemWidth, sr.colorMgr)...)
+
 	// Add random dots
 	circles := noiseGen.GenerateDots(config.Noise*3, sr.width, sr.height, sr.colorMgr)
 	svg.Circles = append(svg.Circles, circles...)
 }
-
-// secureRandomFloat generates a secure random float between min and max
-func secureRandomFloat(min, max float64) (float64, error) {
-	if min >= max {
-		return min, nil
-	}
-
-	range_ := max - min
-	randInt, err := secureRandomInt(10000)
-	if err != nil {
-		return min, err
-	}
-
-	return min + (float64(randInt)/10000.0)*range_, nil
-}
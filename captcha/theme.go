@@ -0,0 +1,410 @@
+package captcha
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Built-in theme names
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+	ThemeBrand = "brand"
+)
+
+// DefaultBrandColor is the color the built-in brand theme is derived from. Register a theme
+// named ThemeBrand from NewBrandTheme to use your own brand color.
+const DefaultBrandColor = "#0066cc"
+
+// Theme is a named set of background, text and noise colors
+type Theme struct {
+	Name        string   `json:"name"`
+	Background  string   `json:"background"`
+	TextColors  []string `json:"textColors"`
+	NoiseColors []string `json:"noiseColors"`
+}
+
+// Validate checks that the theme is named and every color parses
+func (t *Theme) Validate() error {
+	if t.Name == "" {
+		return NewError(ErrInvalidConfig, "theme name cannot be empty", 400)
+	}
+	if _, err := ParseColor(t.Background); err != nil {
+		return err
+	}
+	if len(t.TextColors) == 0 {
+		return NewError(ErrInvalidConfig, "theme "+t.Name+" has no text colors", 400)
+	}
+	if err := validateColors(t.TextColors); err != nil {
+		return err
+	}
+	return validateColors(t.NoiseColors)
+}
+
+var (
+	themesMutex sync.RWMutex
+	themes      = map[string]*Theme{
+		ThemeLight: {
+			Name:        ThemeLight,
+			Background:  "#f0f0f0",
+			TextColors:  defaultTextColors,
+			NoiseColors: defaultNoiseColors,
+		},
+		ThemeDark: {
+			Name:       ThemeDark,
+			Background: "#1e1e1e",
+			TextColors: []string{
+				"#f5f5f5", "#ecf0f1", "#f1c40f", "#f0b27a",
+				"#5dade2", "#58d68d", "#ec7063", "#bb8fce",
+			},
+			NoiseColors: []string{
+				"#3d3d3d", "#4a4a4a", "#2e4053", "#424949",
+				"#4a235a", "#1b4f72", "#145a32", "#6e2c00",
+			},
+		},
+		ThemeBrand: defaultBrandTheme(),
+	}
+)
+
+// defaultBrandTheme derives the built-in brand theme from DefaultBrandColor
+func defaultBrandTheme() *Theme {
+	theme, err := NewBrandTheme(ThemeBrand, DefaultBrandColor)
+	if err != nil {
+		panic(err)
+	}
+	return theme
+}
+
+// RegisterTheme adds or replaces a named theme. Colors are normalized to hex, or rgba() when translucent.
+func RegisterTheme(theme Theme) error {
+	if err := theme.Validate(); err != nil {
+		return err
+	}
+
+	normalized := &Theme{Name: theme.Name}
+	normalized.Background, _ = ParseColor(theme.Background)
+	normalized.TextColors, _ = normalizeColors(theme.TextColors)
+	normalized.NoiseColors, _ = normalizeColors(theme.NoiseColors)
+
+	themesMutex.Lock()
+	defer themesMutex.Unlock()
+	themes[theme.Name] = normalized
+	return nil
+}
+
+// GetTheme returns a copy of the named theme
+func GetTheme(name string) (*Theme, error) {
+	themesMutex.RLock()
+	defer themesMutex.RUnlock()
+
+	theme, ok := themes[name]
+	if !ok {
+		return nil, NewError(ErrInvalidConfig, "unknown theme: "+name, 400)
+	}
+	return &Theme{
+		Name:        theme.Name,
+		Background:  theme.Background,
+		TextColors:  append([]string(nil), theme.TextColors...),
+		NoiseColors: append([]string(nil), theme.NoiseColors...),
+	}, nil
+}
+
+// NewBrandTheme derives a theme from a brand color: a faint tint of the brand hue as
+// background, darker shades and neighboring hues for text, and light tints for noise
+func NewBrandTheme(name, primary string) (*Theme, error) {
+	r, g, b, ok := parseColor(primary)
+	if !ok {
+		return nil, NewError(ErrInvalidConfig, "invalid brand color: "+primary, 400)
+	}
+	h, s, _ := rgbToHSL(r, g, b)
+
+	theme := &Theme{Name: name, Background: hslToHex(h, math.Min(s, 0.3), 0.96)}
+	for _, shift := range []float64{0, -30, 30, 180} {
+		for _, lightness := range []float64{0.2, 0.32} {
+			theme.TextColors = append(theme.TextColors, hslToHex(h+shift, math.Max(s, 0.4), lightness))
+		}
+	}
+	for _, shift := range []float64{0, -30, 30, 180} {
+		theme.NoiseColors = append(theme.NoiseColors, hslToHex(h+shift, math.Max(s, 0.3), 0.8))
+	}
+
+	return theme, theme.Validate()
+}
+
+// ThemeForColorScheme maps a prefers-color-scheme value, such as the
+// Sec-CH-Prefers-Color-Scheme client hint, to a built-in theme name
+func ThemeForColorScheme(scheme string) string {
+	if strings.EqualFold(strings.Trim(strings.TrimSpace(scheme), `"`), "dark") {
+		return ThemeDark
+	}
+	return ThemeLight
+}
+
+// ApplyTheme copies the named theme's background and palettes into the configuration
+func (c *Config) ApplyTheme(name string) error {
+	theme, err := GetTheme(name)
+	if err != nil {
+		return err
+	}
+
+	c.Background = theme.Background
+	c.TextColors = theme.TextColors
+	c.NoiseColors = theme.NoiseColors
+	return nil
+}
+
+// ParseColor parses a CSS color in hex ("#rgb", "#rrggbb"), rgb(), hsl() or named form
+// and returns it as "#rrggbb". Any alpha component is dropped; use ParseRGBA to keep it.
+func ParseColor(value string) (string, error) {
+	c, err := ParseRGBA(value)
+	if err != nil {
+		return "", err
+	}
+	return c.Hex(), nil
+}
+
+// ParseRGBA parses a CSS color including its alpha component. In addition to the formats
+// accepted by ParseColor it supports "#rgba", "#rrggbbaa", rgba(), hsla(), the
+// "rgb(r g b / a)" syntax and "transparent".
+func ParseRGBA(value string) (RGBA, error) {
+	c, ok := parseRGBA(value)
+	if !ok {
+		return RGBA{}, NewError(ErrInvalidConfig, "invalid color: "+value, 400)
+	}
+	return c, nil
+}
+
+// parseColor parses any supported CSS color into its channels, ignoring alpha
+func parseColor(value string) (r, g, b uint8, ok bool) {
+	c, ok := parseRGBA(value)
+	return c.R, c.G, c.B, ok
+}
+
+// parseRGBA parses any supported CSS color
+func parseRGBA(value string) (RGBA, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	for _, fn := range []struct {
+		prefix string
+		parse  func(string) (RGBA, bool)
+	}{
+		{"rgba(", parseRGBFunction},
+		{"rgb(", parseRGBFunction},
+		{"hsla(", parseHSLFunction},
+		{"hsl(", parseHSLFunction},
+	} {
+		if strings.HasPrefix(value, fn.prefix) && strings.HasSuffix(value, ")") {
+			return fn.parse(strings.TrimSuffix(strings.TrimPrefix(value, fn.prefix), ")"))
+		}
+	}
+
+	if strings.HasPrefix(value, "#") {
+		return parseHexRGBA(value)
+	}
+	if value == "transparent" {
+		return RGBA{}, true
+	}
+	if rgb, found := namedColors[value]; found {
+		return RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 1}, true
+	}
+	return RGBA{}, false
+}
+
+// colorArguments splits the arguments of rgb() or hsl() on commas, whitespace or the alpha slash
+func colorArguments(args string) []string {
+	return strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+}
+
+// parseAlpha parses an optional alpha argument given as 0-1 or a percentage
+func parseAlpha(parts []string) (float64, bool) {
+	if len(parts) < 4 {
+		return 1, true
+	}
+	percent := strings.HasSuffix(parts[3], "%")
+	alpha, err := strconv.ParseFloat(strings.TrimSuffix(parts[3], "%"), 64)
+	if percent {
+		alpha /= 100
+	}
+	if err != nil || alpha < 0 || alpha > 1 {
+		return 0, false
+	}
+	return alpha, true
+}
+
+// parseRGBFunction parses "r, g, b[, a]" with each channel as 0-255 or a percentage
+func parseRGBFunction(args string) (RGBA, bool) {
+	parts := colorArguments(args)
+	if len(parts) != 3 && len(parts) != 4 {
+		return RGBA{}, false
+	}
+
+	var channels [3]uint8
+	for i, part := range parts[:3] {
+		percent := strings.HasSuffix(part, "%")
+		value, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		if percent {
+			value = value * 255 / 100
+		}
+		if err != nil || value < 0 || value > 255 {
+			return RGBA{}, false
+		}
+		channels[i] = uint8(math.Round(value))
+	}
+
+	alpha, ok := parseAlpha(parts)
+	return RGBA{R: channels[0], G: channels[1], B: channels[2], A: alpha}, ok
+}
+
+// parseHSLFunction parses "h, s%, l%[, a]" with the hue in degrees
+func parseHSLFunction(args string) (RGBA, bool) {
+	parts := colorArguments(args)
+	if (len(parts) != 3 && len(parts) != 4) || !strings.HasSuffix(parts[1], "%") || !strings.HasSuffix(parts[2], "%") {
+		return RGBA{}, false
+	}
+
+	h, err1 := strconv.ParseFloat(strings.TrimSuffix(parts[0], "deg"), 64)
+	s, err2 := strconv.ParseFloat(strings.TrimSuffix(parts[1], "%"), 64)
+	l, err3 := strconv.ParseFloat(strings.TrimSuffix(parts[2], "%"), 64)
+	if err1 != nil || err2 != nil || err3 != nil || s < 0 || s > 100 || l < 0 || l > 100 {
+		return RGBA{}, false
+	}
+
+	r, g, b := hslToRGB(h, s/100, l/100)
+	alpha, ok := parseAlpha(parts)
+	return RGBA{R: r, G: g, B: b, A: alpha}, ok
+}
+
+// hslToRGB converts hue in degrees and saturation and lightness in 0-1 to sRGB
+func hslToRGB(h, s, l float64) (r, g, b uint8) {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	if s == 0 {
+		v := uint8(math.Round(l * 255))
+		return v, v, v
+	}
+
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+
+	channel := func(t float64) uint8 {
+		t = math.Mod(t+1, 1)
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 0.5:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(math.Round(v * 255))
+	}
+	return channel(h + 1.0/3), channel(h), channel(h - 1.0/3)
+}
+
+// rgbToHSL converts sRGB to hue in degrees and saturation and lightness in 0-1
+func rgbToHSL(r, g, b uint8) (h, s, l float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	maxC, minC := math.Max(rf, math.Max(gf, bf)), math.Min(rf, math.Min(gf, bf))
+	l = (maxC + minC) / 2
+	if maxC == minC {
+		return 0, 0, l
+	}
+
+	d := maxC - minC
+	if l > 0.5 {
+		s = d / (2 - maxC - minC)
+	} else {
+		s = d / (maxC + minC)
+	}
+
+	switch maxC {
+	case rf:
+		h = math.Mod((gf-bf)/d+6, 6)
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	return h * 60, s, l
+}
+
+// hslToHex formats an HSL color as "#rrggbb"
+func hslToHex(h, s, l float64) string {
+	r, g, b := hslToRGB(h, s, l)
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// validateColors returns an error for the first color that does not parse
+func validateColors(colors []string) error {
+	for _, color := range colors {
+		if _, err := ParseColor(color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeColors converts every color to "#rrggbb", or rgba() when it is translucent
+func normalizeColors(colors []string) ([]string, error) {
+	if colors == nil {
+		return nil, nil
+	}
+	normalized := make([]string, len(colors))
+	for i, value := range colors {
+		color, err := ParseRGBA(value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = color.String()
+	}
+	return normalized, nil
+}
+
+// namedColors maps the CSS named colors to their sRGB values
+var namedColors = map[string]uint32{
+	"aliceblue": 0xf0f8ff, "antiquewhite": 0xfaebd7, "aqua": 0x00ffff, "aquamarine": 0x7fffd4,
+	"azure": 0xf0ffff, "beige": 0xf5f5dc, "bisque": 0xffe4c4, "black": 0x000000,
+	"blanchedalmond": 0xffebcd, "blue": 0x0000ff, "blueviolet": 0x8a2be2, "brown": 0xa52a2a,
+	"burlywood": 0xdeb887, "cadetblue": 0x5f9ea0, "chartreuse": 0x7fff00, "chocolate": 0xd2691e,
+	"coral": 0xff7f50, "cornflowerblue": 0x6495ed, "cornsilk": 0xfff8dc, "crimson": 0xdc143c,
+	"cyan": 0x00ffff, "darkblue": 0x00008b, "darkcyan": 0x008b8b, "darkgoldenrod": 0xb8860b,
+	"darkgray": 0xa9a9a9, "darkgreen": 0x006400, "darkgrey": 0xa9a9a9, "darkkhaki": 0xbdb76b,
+	"darkmagenta": 0x8b008b, "darkolivegreen": 0x556b2f, "darkorange": 0xff8c00, "darkorchid": 0x9932cc,
+	"darkred": 0x8b0000, "darksalmon": 0xe9967a, "darkseagreen": 0x8fbc8f, "darkslateblue": 0x483d8b,
+	"darkslategray": 0x2f4f4f, "darkslategrey": 0x2f4f4f, "darkturquoise": 0x00ced1, "darkviolet": 0x9400d3,
+	"deeppink": 0xff1493, "deepskyblue": 0x00bfff, "dimgray": 0x696969, "dimgrey": 0x696969,
+	"dodgerblue": 0x1e90ff, "firebrick": 0xb22222, "floralwhite": 0xfffaf0, "forestgreen": 0x228b22,
+	"fuchsia": 0xff00ff, "gainsboro": 0xdcdcdc, "ghostwhite": 0xf8f8ff, "gold": 0xffd700,
+	"goldenrod": 0xdaa520, "gray": 0x808080, "green": 0x008000, "greenyellow": 0xadff2f,
+	"grey": 0x808080, "honeydew": 0xf0fff0, "hotpink": 0xff69b4, "indianred": 0xcd5c5c,
+	"indigo": 0x4b0082, "ivory": 0xfffff0, "khaki": 0xf0e68c, "lavender": 0xe6e6fa,
+	"lavenderblush": 0xfff0f5, "lawngreen": 0x7cfc00, "lemonchiffon": 0xfffacd, "lightblue": 0xadd8e6,
+	"lightcoral": 0xf08080, "lightcyan": 0xe0ffff, "lightgoldenrodyellow": 0xfafad2, "lightgray": 0xd3d3d3,
+	"lightgreen": 0x90ee90, "lightgrey": 0xd3d3d3, "lightpink": 0xffb6c1, "lightsalmon": 0xffa07a,
+	"lightseagreen": 0x20b2aa, "lightskyblue": 0x87cefa, "lightslategray": 0x778899, "lightslategrey": 0x778899,
+	"lightsteelblue": 0xb0c4de, "lightyellow": 0xffffe0, "lime": 0x00ff00, "limegreen": 0x32cd32,
+	"linen": 0xfaf0e6, "magenta": 0xff00ff, "maroon": 0x800000, "mediumaquamarine": 0x66cdaa,
+	"mediumblue": 0x0000cd, "mediumorchid": 0xba55d3, "mediumpurple": 0x9370db, "mediumseagreen": 0x3cb371,
+	"mediumslateblue": 0x7b68ee, "mediumspringgreen": 0x00fa9a, "mediumturquoise": 0x48d1cc, "mediumvioletred": 0xc71585,
+	"midnightblue": 0x191970, "mintcream": 0xf5fffa, "mistyrose": 0xffe4e1, "moccasin": 0xffe4b5,
+	"navajowhite": 0xffdead, "navy": 0x000080, "oldlace": 0xfdf5e6, "olive": 0x808000,
+	"olivedrab": 0x6b8e23, "orange": 0xffa500, "orangered": 0xff4500, "orchid": 0xda70d6,
+	"palegoldenrod": 0xeee8aa, "palegreen": 0x98fb98, "paleturquoise": 0xafeeee, "palevioletred": 0xdb7093,
+	"papayawhip": 0xffefd5, "peachpuff": 0xffdab9, "peru": 0xcd853f, "pink": 0xffc0cb,
+	"plum": 0xdda0dd, "powderblue": 0xb0e0e6, "purple": 0x800080, "rebeccapurple": 0x663399,
+	"red": 0xff0000, "rosybrown": 0xbc8f8f, "royalblue": 0x4169e1, "saddlebrown": 0x8b4513,
+	"salmon": 0xfa8072, "sandybrown": 0xf4a460, "seagreen": 0x2e8b57, "seashell": 0xfff5ee,
+	"sienna": 0xa0522d, "silver": 0xc0c0c0, "skyblue": 0x87ceeb, "slateblue": 0x6a5acd,
+	"slategray": 0x708090, "slategrey": 0x708090, "snow": 0xfffafa, "springgreen": 0x00ff7f,
+	"steelblue": 0x4682b4, "tan": 0xd2b48c, "teal": 0x008080, "thistle": 0xd8bfd8,
+	"tomato": 0xff6347, "turquoise": 0x40e0d0, "violet": 0xee82ee, "wheat": 0xf5deb3,
+	"white": 0xffffff, "whitesmoke": 0xf5f5f5, "yellow": 0xffff00, "yellowgreen": 0x9acd32,
+}
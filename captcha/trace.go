@@ -0,0 +1,213 @@
+package captcha
+
+import (
+	"context"
+	"sync"
+)
+
+// Span names reported to a Tracer
+const (
+	SpanGenerate   = "captcha.generate"
+	SpanExpression = "captcha.expression"
+	SpanRender     = "captcha.render"
+	SpanNoise      = "captcha.noise"
+	SpanRasterize  = "captcha.rasterize"
+	SpanVerify     = "captcha.verify"
+	SpanStoreSet   = "captcha.store.set"
+	SpanStoreTake  = "captcha.store.take"
+)
+
+// Attribute is a key-value pair attached to a span. Values are strings, ints, float64s or
+// bools.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// StringAttribute creates a string attribute
+func StringAttribute(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// IntAttribute creates an integer attribute
+func IntAttribute(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Float64Attribute creates a floating-point attribute
+func Float64Attribute(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// BoolAttribute creates a boolean attribute
+func BoolAttribute(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer starts spans around captcha operations. Its shape follows the OpenTelemetry tracing
+// API, so adapting an OpenTelemetry tracer takes a thin wrapper.
+type Tracer interface {
+	// Start begins a span that is a child of any span in ctx and returns a context holding it
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer
+type Span interface {
+	// IsRecording reports whether attributes and errors are recorded, so callers can skip
+	// computing them otherwise
+	IsRecording() bool
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// NoopTracer discards all spans; it is the default
+type NoopTracer struct{}
+
+// Start returns ctx and a span that records nothing
+func (NoopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is the span of NoopTracer and of untraced calls
+type noopSpan struct{}
+
+func (noopSpan) IsRecording() bool          { return false }
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// tracerKey is the context key of the tracer
+type tracerKey struct{}
+
+// ContextWithTracer returns a context whose captcha operations, such as PNGContext, report
+// spans to tracer. Generators add their own tracer to the contexts they are given.
+func ContextWithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// startSpan starts a span with the tracer in ctx, or returns a no-op span without allocating if
+// there is none
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	tracer, ok := ctx.Value(tracerKey{}).(Tracer)
+	if !ok {
+		return ctx, noopSpan{}
+	}
+	return tracer.Start(ctx, name)
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span Span, err error) {
+	if err != nil && span.IsRecording() {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// RecordedSpan is a span captured by a SpanRecorder
+type RecordedSpan struct {
+	ID         int
+	ParentID   int // 0 for root spans
+	Name       string
+	Attributes []Attribute
+	Errors     []error
+	Ended      bool
+}
+
+// Attribute returns the value of the last attribute set under key
+func (rs RecordedSpan) Attribute(key string) (any, bool) {
+	for i := len(rs.Attributes) - 1; i >= 0; i-- {
+		if rs.Attributes[i].Key == key {
+			return rs.Attributes[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// SpanRecorder is a Tracer that keeps spans in memory, for tests and debugging
+type SpanRecorder struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+// NewSpanRecorder creates an empty span recorder
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+// recordedSpanKey is the context key of the current recorded span
+type recordedSpanKey struct{}
+
+// Start records a new span as a child of the recorded span in ctx
+func (sr *SpanRecorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parentID := 0
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recorderSpan); ok {
+		parentID = parent.span.ID
+	}
+
+	sr.mutex.Lock()
+	span := &recorderSpan{
+		recorder: sr,
+		span: &RecordedSpan{
+			ID:         len(sr.spans) + 1,
+			ParentID:   parentID,
+			Name:       name,
+			Attributes: append([]Attribute(nil), attrs...),
+		},
+	}
+	sr.spans = append(sr.spans, span.span)
+	sr.mutex.Unlock()
+
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns copies of the recorded spans in start order
+func (sr *SpanRecorder) Spans() []RecordedSpan {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	spans := make([]RecordedSpan, len(sr.spans))
+	for i, span := range sr.spans {
+		spans[i] = *span
+		spans[i].Attributes = append([]Attribute(nil), span.Attributes...)
+		spans[i].Errors = append([]error(nil), span.Errors...)
+	}
+	return spans
+}
+
+// Reset discards all recorded spans
+func (sr *SpanRecorder) Reset() {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.spans = nil
+}
+
+// recorderSpan is a span of a SpanRecorder
+type recorderSpan struct {
+	recorder *SpanRecorder
+	span     *RecordedSpan
+}
+
+func (s *recorderSpan) IsRecording() bool { return true }
+
+func (s *recorderSpan) SetAttributes(attrs ...Attribute) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	s.span.Attributes = append(s.span.Attributes, attrs...)
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	s.span.Errors = append(s.span.Errors, err)
+}
+
+func (s *recorderSpan) End() {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	s.span.Ended = true
+}
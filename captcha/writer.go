@@ -0,0 +1,419 @@
+package captcha
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// maxPooledBufferSize keeps unusually large buffers from being retained by the pool
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool holds buffers used to encode SVG documents
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a buffer to the pool
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// encodeSVG serializes the document into buf. Indented output is used unless Minify is set or it
+// exceeds MaxBytes; minified output is retried with fewer decimals until it fits the budget.
+func encodeSVG(buf *bytes.Buffer, svg *SVGElement, config *Config) error {
+	if !config.Minify {
+		buf.WriteString(xml.Header)
+		writeIndented(buf, svg)
+		if config.MaxBytes == 0 || buf.Len() <= config.MaxBytes {
+			return nil
+		}
+	}
+
+	var size int
+	for precision := defaultPrecision; precision >= 0; precision-- {
+		buf.Reset()
+		writeMinified(buf, svg, precision)
+		if config.MaxBytes == 0 || buf.Len() <= config.MaxBytes {
+			return nil
+		}
+		size = buf.Len()
+	}
+
+	buf.Reset()
+	return NewError(ErrSizeBudgetExceeded,
+		"SVG output of "+strconv.Itoa(size)+" bytes exceeds MaxBytes "+strconv.Itoa(config.MaxBytes), 500)
+}
+
+// writeIndented writes svg to buf exactly as xml.MarshalIndent(svg, "", "  ") would, without
+// the reflection and per-attribute allocations of encoding/xml
+func writeIndented(buf *bytes.Buffer, svg *SVGElement) {
+	w := svgWriter{buf: buf}
+	w.svg(svg)
+}
+
+// writeMinified writes svg to buf without indentation or XML declaration, with numbers rounded
+// to precision decimals in their shortest form, adjacent identical paths merged and path data
+// rewritten with relative commands
+func writeMinified(buf *bytes.Buffer, svg *SVGElement, precision int) {
+	w := svgWriter{buf: buf, minify: true, precision: precision}
+	w.svg(svg)
+}
+
+// svgWriter emits SVG markup into a buffer, either indented like encoding/xml or minified
+type svgWriter struct {
+	buf       *bytes.Buffer
+	minify    bool
+	precision int
+
+	// Indentation state mirroring encoding/xml's printer
+	depth      int
+	indentedIn bool
+	putNewline bool
+}
+
+// writeIndent starts a new indented line unless minifying; depthDelta is 1 for a start tag
+// and -1 for an end tag, and end tags directly after their start tag stay on the same line
+func (w *svgWriter) writeIndent(depthDelta int) {
+	if w.minify {
+		return
+	}
+	if depthDelta < 0 {
+		w.depth--
+		if w.indentedIn {
+			w.indentedIn = false
+			return
+		}
+		w.indentedIn = false
+	}
+	if w.putNewline {
+		w.buf.WriteByte('\n')
+	} else {
+		w.putNewline = true
+	}
+	for i := 0; i < w.depth; i++ {
+		w.buf.WriteString("  ")
+	}
+	if depthDelta > 0 {
+		w.depth++
+		w.indentedIn = true
+	}
+}
+
+// open starts an element tag
+func (w *svgWriter) open(name string) {
+	w.writeIndent(1)
+	w.buf.WriteByte('<')
+	w.buf.WriteString(name)
+}
+
+// closeStart ends a start tag whose element has content
+func (w *svgWriter) closeStart() {
+	w.buf.WriteByte('>')
+}
+
+// closeEmpty ends an element without content
+func (w *svgWriter) closeEmpty(name string) {
+	if w.minify {
+		w.writeIndent(-1)
+		w.buf.WriteString("/>")
+		return
+	}
+	w.buf.WriteByte('>')
+	w.end(name)
+}
+
+// end writes the end tag of an element with content
+func (w *svgWriter) end(name string) {
+	w.writeIndent(-1)
+	w.buf.WriteString("</")
+	w.buf.WriteString(name)
+	w.buf.WriteByte('>')
+}
+
+// escape writes s with XML escaping, avoiding a copy when nothing needs escaping
+func (w *svgWriter) escape(s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c < 0x20 || c >= 0x80 || c == '"' || c == '\'' || c == '&' || c == '<' || c == '>':
+			xml.EscapeText(w.buf, []byte(s))
+			return
+		}
+	}
+	w.buf.WriteString(s)
+}
+
+// attr writes an escaped attribute
+func (w *svgWriter) attr(name, value string) {
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(name)
+	w.buf.WriteString(`="`)
+	w.escape(value)
+	w.buf.WriteByte('"')
+}
+
+// optAttr writes an attribute only when its value is not empty, like omitempty
+func (w *svgWriter) optAttr(name, value string) {
+	if value != "" {
+		w.attr(name, value)
+	}
+}
+
+// intAttr writes an integer attribute
+func (w *svgWriter) intAttr(name string, value int) {
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(name)
+	w.buf.WriteString(`="`)
+	w.buf.Write(strconv.AppendInt(w.buf.AvailableBuffer(), int64(value), 10))
+	w.buf.WriteByte('"')
+}
+
+// numAttr writes a float attribute, in its shortest rounded form when minifying
+func (w *svgWriter) numAttr(name string, value float64) {
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(name)
+	w.buf.WriteString(`="`)
+	if w.minify {
+		w.buf.Write(appendNumber(w.buf.AvailableBuffer(), value, w.precision))
+	} else {
+		w.buf.Write(strconv.AppendFloat(w.buf.AvailableBuffer(), value, 'g', -1, 64))
+	}
+	w.buf.WriteByte('"')
+}
+
+// numListAttr writes an optional attribute whose value embeds numbers, such as a transform
+func (w *svgWriter) numListAttr(name, value string) {
+	if value != "" && w.minify {
+		value = minifyNumbers(value, w.precision)
+	}
+	w.optAttr(name, value)
+}
+
+// svg writes the root element and its children in struct field order
+func (w *svgWriter) svg(svg *SVGElement) {
+	w.open("svg")
+	w.intAttr("width", svg.Width)
+	w.intAttr("height", svg.Height)
+	w.attr("viewBox", svg.ViewBox)
+	w.attr("xmlns", svg.Xmlns)
+	w.closeStart()
+
+	if svg.Defs != nil {
+		w.defs(svg.Defs)
+	}
+	if svg.Background != nil {
+		w.rect(svg.Background)
+	}
+	for _, group := range svg.Groups {
+		w.group(group)
+	}
+	for _, text := range svg.Texts {
+		w.text(text)
+	}
+	w.paths(svg.Paths)
+	for _, line := range svg.Lines {
+		w.line(line)
+	}
+	for _, circle := range svg.Circles {
+		w.circle(circle)
+	}
+
+	w.end("svg")
+}
+
+// defs writes filters, gradients and patterns
+func (w *svgWriter) defs(defs *DefsElement) {
+	w.open("defs")
+	w.closeStart()
+	for _, filter := range defs.Filters {
+		w.filter(filter)
+	}
+	for _, gradient := range defs.LinearGradients {
+		w.open("linearGradient")
+		w.attr("id", gradient.ID)
+		w.attr("x1", gradient.X1)
+		w.attr("y1", gradient.Y1)
+		w.attr("x2", gradient.X2)
+		w.attr("y2", gradient.Y2)
+		w.closeStart()
+		for _, stop := range gradient.Stops {
+			w.open("stop")
+			w.attr("offset", stop.Offset)
+			w.attr("stop-color", stop.StopColor)
+			w.closeEmpty("stop")
+		}
+		w.end("linearGradient")
+	}
+	for _, pattern := range defs.Patterns {
+		w.open("pattern")
+		w.attr("id", pattern.ID)
+		w.numAttr("width", pattern.Width)
+		w.numAttr("height", pattern.Height)
+		w.attr("patternUnits", pattern.PatternUnits)
+		w.numListAttr("patternTransform", pattern.PatternTransform)
+		w.closeStart()
+		w.paths(pattern.Paths)
+		for _, circle := range pattern.Circles {
+			w.circle(circle)
+		}
+		w.end("pattern")
+	}
+	w.end("defs")
+}
+
+// filter writes a filter chain
+func (w *svgWriter) filter(filter *FilterElement) {
+	w.open("filter")
+	w.attr("id", filter.ID)
+	w.optAttr("x", filter.X)
+	w.optAttr("y", filter.Y)
+	w.optAttr("width", filter.Width)
+	w.optAttr("height", filter.Height)
+	w.closeStart()
+
+	if t := filter.Turbulence; t != nil {
+		w.open("feTurbulence")
+		w.attr("type", t.Type)
+		w.attr("baseFrequency", t.BaseFrequency)
+		w.intAttr("numOctaves", t.NumOctaves)
+		w.intAttr("seed", t.Seed)
+		w.attr("result", t.Result)
+		w.closeEmpty("feTurbulence")
+	}
+	if d := filter.Displacement; d != nil {
+		w.open("feDisplacementMap")
+		w.attr("in", d.In)
+		w.attr("in2", d.In2)
+		w.attr("scale", d.Scale)
+		w.attr("xChannelSelector", d.XChannelSelector)
+		w.attr("yChannelSelector", d.YChannelSelector)
+		w.optAttr("result", d.Result)
+		w.closeEmpty("feDisplacementMap")
+	}
+	if b := filter.Blur; b != nil {
+		w.open("feGaussianBlur")
+		w.optAttr("in", b.In)
+		w.attr("stdDeviation", b.StdDeviation)
+		w.closeEmpty("feGaussianBlur")
+	}
+
+	w.end("filter")
+}
+
+// group writes a group and its children
+func (w *svgWriter) group(group *GroupElement) {
+	w.open("g")
+	w.optAttr("id", group.ID)
+	w.optAttr("filter", group.Filter)
+	w.closeStart()
+	for _, rect := range group.Rects {
+		w.rect(rect)
+	}
+	for _, text := range group.Texts {
+		w.text(text)
+	}
+	w.paths(group.Paths)
+	for _, circle := range group.Circles {
+		w.circle(circle)
+	}
+	w.end("g")
+}
+
+// rect writes a rectangle
+func (w *svgWriter) rect(rect *RectElement) {
+	w.open("rect")
+	w.intAttr("x", rect.X)
+	w.intAttr("y", rect.Y)
+	w.intAttr("width", rect.Width)
+	w.intAttr("height", rect.Height)
+	w.attr("fill", rect.Fill)
+	w.closeEmpty("rect")
+}
+
+// text writes a text element
+func (w *svgWriter) text(text *TextElement) {
+	w.open("text")
+	w.numAttr("x", text.X)
+	w.numAttr("y", text.Y)
+	w.attr("fill", text.Fill)
+	w.optAttr("fill-opacity", text.FillOpacity)
+	w.intAttr("font-size", text.FontSize)
+	w.attr("font-family", text.FontFamily)
+	w.numListAttr("transform", text.Transform)
+	w.closeStart()
+	w.escape(text.Content)
+	w.end("text")
+}
+
+// paths writes paths; when minifying, runs of adjacent opaque paths with identical paint are
+// merged into one element and path data is rewritten with relative commands
+func (w *svgWriter) paths(paths []*PathElement) {
+	for i := 0; i < len(paths); {
+		path := paths[i]
+		j := i + 1
+		if w.minify {
+			for j < len(paths) && canMergePaths(path, paths[j]) {
+				j++
+			}
+		}
+
+		w.open("path")
+		w.buf.WriteString(` d="`)
+		if w.minify {
+			for _, merged := range paths[i:j] {
+				w.buf.Write(appendRelativePath(w.buf.AvailableBuffer(), merged.D, w.precision))
+			}
+		} else {
+			w.escape(path.D)
+		}
+		w.buf.WriteByte('"')
+		w.attr("fill", path.Fill)
+		w.optAttr("fill-opacity", path.FillOpacity)
+		w.optAttr("stroke", path.Stroke)
+		w.numListAttr("stroke-width", path.StrokeWidth)
+		w.optAttr("stroke-opacity", path.StrokeOpacity)
+		w.closeEmpty("path")
+		i = j
+	}
+}
+
+// line writes a line
+func (w *svgWriter) line(line *LineElement) {
+	w.open("line")
+	w.numAttr("x1", line.X1)
+	w.numAttr("y1", line.Y1)
+	w.numAttr("x2", line.X2)
+	w.numAttr("y2", line.Y2)
+	w.attr("stroke", line.Stroke)
+	w.numAttr("stroke-width", line.Width)
+	w.optAttr("stroke-opacity", line.StrokeOpacity)
+	w.closeEmpty("line")
+}
+
+// circle writes a circle
+func (w *svgWriter) circle(circle *CircleElement) {
+	w.open("circle")
+	w.numAttr("cx", circle.CX)
+	w.numAttr("cy", circle.CY)
+	w.numAttr("r", circle.R)
+	w.attr("fill", circle.Fill)
+	w.optAttr("fill-opacity", circle.FillOpacity)
+	w.closeEmpty("circle")
+}
+
+// WriteTo writes the SVG document to w, implementing io.WriterTo
+func (r *CaptchaResult) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, r.Data)
+	return int64(n), err
+}
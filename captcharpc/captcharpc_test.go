@@ -0,0 +1,216 @@
+package captcharpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// recordingStore is a MemoryStore that remembers issued answers, so tests can answer captchas
+type recordingStore struct {
+	*captcha.MemoryStore
+	mutex   sync.Mutex
+	answers map[string]string
+}
+
+func (rs *recordingStore) Set(id string, entry captcha.StoreEntry) error {
+	rs.mutex.Lock()
+	rs.answers[id] = entry.Answer
+	rs.mutex.Unlock()
+	return rs.MemoryStore.Set(id, entry)
+}
+
+func (rs *recordingStore) answer(id string) string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.answers[id]
+}
+
+// startServer serves a CaptchaService on an in-process listener and returns a client for it
+func startServer(t *testing.T) (*Client, *recordingStore) {
+	t.Helper()
+	store := &recordingStore{MemoryStore: captcha.NewMemoryStore(), answers: make(map[string]string)}
+	server := NewHTTPServer(NewServer(captcha.NewService(nil, store)))
+	listener := NewPipeListener()
+	go server.Serve(listener)
+
+	transport := NewH2CTransport(listener.DialContext)
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		server.Close()
+	})
+	return NewClient("http://pipe", &http.Client{Transport: transport}), store
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	messages := []struct {
+		in, out Message
+	}{
+		{&IssueRequest{Theme: "dark", Format: ImageFormatPNG, PNGScale: 2.5}, new(IssueRequest)},
+		{&Challenge{ID: "abc", Image: []byte("<svg/>"), ContentType: "image/svg+xml", ExpiresAtUnixMilli: 1700000000000}, new(Challenge)},
+		{&VerifyRequest{ID: "abc", Answer: "12"}, new(VerifyRequest)},
+		{&VerifyResponse{Success: true, Result: VerifyResultSuccess}, new(VerifyResponse)},
+		{&RefreshRequest{ID: "abc", Theme: "light", Format: ImageFormatSVG, PNGScale: math.Pi, Client: "session"}, new(RefreshRequest)},
+		{&IssueRequest{Format: -1}, new(IssueRequest)},
+	}
+
+	for _, m := range messages {
+		if err := m.out.Unmarshal(m.in.Marshal()); err != nil {
+			t.Fatalf("Failed to unmarshal %T: %v", m.in, err)
+		}
+		if !bytes.Equal(m.in.Marshal(), m.out.Marshal()) {
+			t.Errorf("%T did not round-trip: %+v != %+v", m.in, m.in, m.out)
+		}
+	}
+
+	if len((&VerifyResponse{}).Marshal()) != 0 {
+		t.Error("Expected default values to be omitted")
+	}
+}
+
+func TestMessagesMatchProtobufEncoding(t *testing.T) {
+	// Reference bytes as produced by protoc-generated code for the same messages
+	req := &VerifyRequest{ID: "ab", Answer: "7"}
+	if got, want := req.Marshal(), []byte{0x0a, 0x02, 'a', 'b', 0x12, 0x01, '7'}; !bytes.Equal(got, want) {
+		t.Errorf("Expected % x, got % x", want, got)
+	}
+	resp := &VerifyResponse{Success: true, Result: VerifyResultNotFound}
+	if got, want := resp.Marshal(), []byte{0x08, 0x01, 0x10, 0x04}; !bytes.Equal(got, want) {
+		t.Errorf("Expected % x, got % x", want, got)
+	}
+
+	// Unknown fields of newer schemas are skipped
+	withUnknown := append(req.Marshal(), 0x18, 0x96, 0x01, 0x25, 1, 2, 3, 4, 0x2a, 0x01, 'x')
+	var decoded VerifyRequest
+	if err := decoded.Unmarshal(withUnknown); err != nil || decoded != *req {
+		t.Errorf("Expected unknown fields to be skipped, got %+v %v", decoded, err)
+	}
+
+	for _, data := range [][]byte{{0x0a, 0x05, 'a'}, {0x08}, {0x0a}, {0x08, 0x01}} {
+		if err := decoded.Unmarshal(data); err == nil {
+			t.Errorf("Expected % x to be rejected", data)
+		}
+	}
+}
+
+func TestIssueVerifyRefresh(t *testing.T) {
+	client, store := startServer(t)
+	ctx := t.Context()
+
+	challenge, err := client.Issue(ctx, &IssueRequest{})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if challenge.ID == "" || challenge.ContentType != "image/svg+xml" || !bytes.Contains(challenge.Image, []byte("<svg")) {
+		t.Fatalf("Unexpected challenge %s %s", challenge.ID, challenge.ContentType)
+	}
+	if expires := time.UnixMilli(challenge.ExpiresAtUnixMilli); time.Until(expires) <= 0 {
+		t.Errorf("Expected a future expiry, got %v", expires)
+	}
+
+	// Refresh invalidates the old captcha
+	refreshed, err := client.Refresh(ctx, &RefreshRequest{ID: challenge.ID, Format: ImageFormatPNG})
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if refreshed.ID == challenge.ID || refreshed.ContentType != "image/png" || !bytes.HasPrefix(refreshed.Image, []byte("\x89PNG")) {
+		t.Errorf("Expected a new PNG captcha, got %s %s", refreshed.ID, refreshed.ContentType)
+	}
+	verified, err := client.Verify(ctx, &VerifyRequest{ID: challenge.ID, Answer: store.answer(challenge.ID)})
+	if err != nil || verified.Success || verified.Result != VerifyResultNotFound {
+		t.Errorf("Expected the refreshed captcha to be gone, got %+v %v", verified, err)
+	}
+
+	verified, err = client.Verify(ctx, &VerifyRequest{ID: refreshed.ID, Answer: store.answer(refreshed.ID)})
+	if err != nil || !verified.Success || verified.Result.Captcha() != captcha.VerifySuccess {
+		t.Errorf("Expected success, got %+v %v", verified, err)
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	client, _ := startServer(t)
+	ctx := t.Context()
+
+	_, err := client.Issue(ctx, &IssueRequest{Theme: "neon"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != CodeInvalidArgument || statusErr.Message != "unknown theme: neon" {
+		t.Errorf("Expected InvalidArgument for an unknown theme, got %v", err)
+	}
+	if _, err := client.Issue(ctx, &IssueRequest{Format: 9}); StatusCode(err) != CodeInvalidArgument {
+		t.Errorf("Expected InvalidArgument for an unknown format, got %v", err)
+	}
+	for _, scale := range []float64{-1, math.NaN(), math.Inf(1), 1e9, 100, MaxPNGScale + 0.5} {
+		if _, err := client.Issue(ctx, &IssueRequest{Format: ImageFormatPNG, PNGScale: scale}); StatusCode(err) != CodeInvalidArgument {
+			t.Errorf("Expected InvalidArgument for PNG scale %v, got %v", scale, err)
+		}
+		if _, err := client.Refresh(ctx, &RefreshRequest{Format: ImageFormatPNG, PNGScale: scale}); StatusCode(err) != CodeInvalidArgument {
+			t.Errorf("Expected InvalidArgument for PNG scale %v on refresh, got %v", scale, err)
+		}
+	}
+	if _, err := client.Verify(ctx, &VerifyRequest{}); StatusCode(err) != CodeInvalidArgument {
+		t.Errorf("Expected InvalidArgument for a missing ID, got %v", err)
+	}
+
+	for range captcha.DefaultRefreshLimit {
+		if _, err := client.Refresh(ctx, &RefreshRequest{Client: "bot"}); err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+	}
+	if _, err := client.Refresh(ctx, &RefreshRequest{Client: "bot"}); StatusCode(err) != CodeResourceExhausted {
+		t.Errorf("Expected ResourceExhausted beyond the refresh limit, got %v", err)
+	}
+
+	unknown := &Client{baseURL: client.baseURL, httpClient: client.httpClient}
+	if err := unknown.invoke(ctx, "Solve", &VerifyRequest{}, new(VerifyResponse)); StatusCode(err) != CodeUnimplemented {
+		t.Errorf("Expected Unimplemented for an unknown method, got %v", err)
+	}
+
+	expired, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+	if _, err := client.Issue(expired, &IssueRequest{}); StatusCode(err) != CodeDeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		err  error
+		code Code
+		msg  string
+	}{
+		{captcha.NewError(captcha.ErrInvalidConfig, "bad", 400), CodeInvalidArgument, "bad"},
+		{captcha.NewError(captcha.ErrVerificationFailed, "invalid captcha session", 403), CodePermissionDenied, "invalid captcha session"},
+		{captcha.NewError(captcha.ErrRateLimited, "slow down", 429), CodeResourceExhausted, "slow down"},
+		{captcha.WrapError(captcha.ErrRenderFailed, "store down", 500, errors.New("secret")), CodeInternal, "Internal Server Error"},
+		{errors.New("boom"), CodeInternal, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		if status := toStatus(tt.err); status.Code != tt.code || status.Message != tt.msg {
+			t.Errorf("%v: expected %d %q, got %d %q", tt.err, tt.code, tt.msg, status.Code, status.Message)
+		}
+	}
+
+	message := "100% sure: ünïcode\n"
+	if decoded := decodeStatusMessage(encodeStatusMessage(message)); decoded != message {
+		t.Errorf("Expected %q after encoding, got %q", message, decoded)
+	}
+
+	for value, want := range map[string]time.Duration{"250m": 250 * time.Millisecond, "3S": 3 * time.Second, "1H": time.Hour} {
+		if got, ok := parseTimeout(value); !ok || got != want {
+			t.Errorf("Expected %s to parse as %v, got %v", value, want, got)
+		}
+	}
+	if _, ok := parseTimeout("5x"); ok {
+		t.Error("Expected an unknown unit to be rejected")
+	}
+	if got := formatTimeout(1500 * time.Microsecond); got != "2m" {
+		t.Errorf("Expected timeouts to round up to milliseconds, got %s", got)
+	}
+}
@@ -0,0 +1,121 @@
+package captcharpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes limits the size of response messages; PNG images dominate
+const maxResponseBytes = 4 << 20
+
+// Client calls a CaptchaService
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the service at baseURL, such as "http://captcha:9090". A nil
+// httpClient speaks HTTP/2 without TLS (h2c); pass a client with TLS and HTTP/2 enabled for https.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: NewH2CTransport(nil)}
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient}
+}
+
+// NewH2CTransport creates a transport speaking HTTP/2 without TLS. A non-nil dial replaces
+// network dialing, for example with PipeListener.DialContext.
+func NewH2CTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Transport {
+	transport := &http.Transport{
+		DialContext:     dial,
+		Protocols:       new(http.Protocols),
+		IdleConnTimeout: 90 * time.Second,
+	}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
+
+// Issue generates a captcha
+func (c *Client) Issue(ctx context.Context, req *IssueRequest) (*Challenge, error) {
+	resp := new(Challenge)
+	return resp, c.invoke(ctx, "Issue", req, resp)
+}
+
+// Verify checks an answer and consumes the captcha
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	resp := new(VerifyResponse)
+	return resp, c.invoke(ctx, "Verify", req, resp)
+}
+
+// Refresh invalidates a captcha and issues a new one
+func (c *Client) Refresh(ctx context.Context, req *RefreshRequest) (*Challenge, error) {
+	resp := new(Challenge)
+	return resp, c.invoke(ctx, "Refresh", req, resp)
+}
+
+// invoke makes a unary call of method and decodes the response into resp
+func (c *Client) invoke(ctx context.Context, method string, req, resp Message) error {
+	url := c.baseURL + "/" + ServiceName + "/" + method
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(appendFrame(nil, req.Marshal())))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Header.Set("Grpc-Timeout", formatTimeout(time.Until(deadline)))
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return &StatusError{Code: CodeDeadlineExceeded, Message: ctx.Err().Error()}
+		}
+		return &StatusError{Code: CodeUnavailable, Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return &StatusError{Code: CodeUnknown, Message: "unexpected HTTP status " + response.Status}
+	}
+	// A failed call carries its status in the headers and no message
+	if err := statusFrom(response.Header); err != nil {
+		return err
+	}
+
+	data, readErr := readMessage(io.LimitReader(response.Body, maxResponseBytes+5), maxResponseBytes)
+	io.Copy(io.Discard, response.Body) // Reading to EOF makes the trailers available
+	if err := statusFrom(response.Trailer); err != nil {
+		return err
+	}
+	if response.Trailer.Get("Grpc-Status") == "" {
+		return &StatusError{Code: CodeInternal, Message: "response without status"}
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if err := resp.Unmarshal(data); err != nil {
+		return &StatusError{Code: CodeInternal, Message: err.Error()}
+	}
+	return nil
+}
+
+// statusFrom returns the error of a non-OK grpc-status in header, if any
+func statusFrom(header http.Header) error {
+	value := header.Get("Grpc-Status")
+	if value == "" || value == "0" {
+		return nil
+	}
+
+	code, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return &StatusError{Code: CodeUnknown, Message: "invalid grpc-status " + value}
+	}
+	return &StatusError{Code: Code(code), Message: decodeStatusMessage(header.Get("Grpc-Message"))}
+}
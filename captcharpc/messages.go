@@ -0,0 +1,233 @@
+package captcharpc
+
+import (
+	"math"
+
+	"svg-math-captcha/captcha"
+)
+
+// ImageFormat selects the image encoding of a Challenge
+type ImageFormat int32
+
+// Image formats
+const (
+	ImageFormatUnspecified ImageFormat = 0 // SVG
+	ImageFormatSVG         ImageFormat = 1
+	ImageFormatPNG         ImageFormat = 2
+)
+
+// VerifyResult is the protobuf form of captcha.VerifyResult
+type VerifyResult int32
+
+// Verification results
+const (
+	VerifyResultUnspecified VerifyResult = 0
+	VerifyResultSuccess     VerifyResult = 1
+	VerifyResultFailure     VerifyResult = 2
+	VerifyResultExpired     VerifyResult = 3
+	VerifyResultNotFound    VerifyResult = 4
+)
+
+// verifyResults maps service outcomes to their protobuf values
+var verifyResults = map[captcha.VerifyResult]VerifyResult{
+	captcha.VerifySuccess:  VerifyResultSuccess,
+	captcha.VerifyFailure:  VerifyResultFailure,
+	captcha.VerifyExpired:  VerifyResultExpired,
+	captcha.VerifyNotFound: VerifyResultNotFound,
+}
+
+// Captcha returns the service outcome of r, or an empty result for unknown values
+func (r VerifyResult) Captcha() captcha.VerifyResult {
+	for result, value := range verifyResults {
+		if value == r {
+			return result
+		}
+	}
+	return ""
+}
+
+// Message is a protobuf message of the CaptchaService
+type Message interface {
+	Marshal() []byte
+	Unmarshal(data []byte) error
+}
+
+// IssueRequest asks for a new captcha
+type IssueRequest struct {
+	Theme    string // Named theme, empty for the server's colors
+	Format   ImageFormat
+	PNGScale float64 // Raster scale of PNG images up to MaxPNGScale, 0 uses 1
+}
+
+// Marshal encodes the message in the protobuf wire format
+func (m *IssueRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Theme)
+	b = appendVarint(b, 2, uint64(m.Format))
+	b = appendDouble(b, 3, m.PNGScale)
+	return b
+}
+
+// Unmarshal decodes the message from the protobuf wire format
+func (m *IssueRequest) Unmarshal(data []byte) error {
+	*m = IssueRequest{}
+	return parseFields(data, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Theme = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 2:
+			m.Format = ImageFormat(f.number64)
+			return checkWireType(f, wireVarint)
+		case 3:
+			m.PNGScale = math.Float64frombits(f.number64)
+			return checkWireType(f, wireFixed64)
+		}
+		return nil
+	})
+}
+
+// Challenge is an issued captcha. It never contains the answer.
+type Challenge struct {
+	ID                 string
+	Image              []byte
+	ContentType        string // image/svg+xml or image/png
+	ExpiresAtUnixMilli int64
+}
+
+// Marshal encodes the message in the protobuf wire format
+func (m *Challenge) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendBytes(b, 2, m.Image)
+	b = appendString(b, 3, m.ContentType)
+	b = appendVarint(b, 4, uint64(m.ExpiresAtUnixMilli))
+	return b
+}
+
+// Unmarshal decodes the message from the protobuf wire format
+func (m *Challenge) Unmarshal(data []byte) error {
+	*m = Challenge{}
+	return parseFields(data, func(f field) error {
+		switch f.number {
+		case 1:
+			m.ID = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 2:
+			m.Image = append([]byte(nil), f.data...)
+			return checkWireType(f, wireBytes)
+		case 3:
+			m.ContentType = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 4:
+			m.ExpiresAtUnixMilli = int64(f.number64)
+			return checkWireType(f, wireVarint)
+		}
+		return nil
+	})
+}
+
+// VerifyRequest carries an answer to check
+type VerifyRequest struct {
+	ID     string
+	Answer string
+}
+
+// Marshal encodes the message in the protobuf wire format
+func (m *VerifyRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.Answer)
+	return b
+}
+
+// Unmarshal decodes the message from the protobuf wire format
+func (m *VerifyRequest) Unmarshal(data []byte) error {
+	*m = VerifyRequest{}
+	return parseFields(data, func(f field) error {
+		switch f.number {
+		case 1:
+			m.ID = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 2:
+			m.Answer = string(f.data)
+			return checkWireType(f, wireBytes)
+		}
+		return nil
+	})
+}
+
+// VerifyResponse is the outcome of a verification
+type VerifyResponse struct {
+	Success bool
+	Result  VerifyResult
+}
+
+// Marshal encodes the message in the protobuf wire format
+func (m *VerifyResponse) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, boolVarint(m.Success))
+	b = appendVarint(b, 2, uint64(m.Result))
+	return b
+}
+
+// Unmarshal decodes the message from the protobuf wire format
+func (m *VerifyResponse) Unmarshal(data []byte) error {
+	*m = VerifyResponse{}
+	return parseFields(data, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Success = f.number64 != 0
+			return checkWireType(f, wireVarint)
+		case 2:
+			m.Result = VerifyResult(f.number64)
+			return checkWireType(f, wireVarint)
+		}
+		return nil
+	})
+}
+
+// RefreshRequest asks to replace a captcha with a new one
+type RefreshRequest struct {
+	ID       string // Captcha to invalidate
+	Theme    string
+	Format   ImageFormat
+	PNGScale float64
+	Client   string // Caller counted against the refresh limit, such as a session ID; empty is not limited
+}
+
+// Marshal encodes the message in the protobuf wire format
+func (m *RefreshRequest) Marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.Theme)
+	b = appendVarint(b, 3, uint64(m.Format))
+	b = appendDouble(b, 4, m.PNGScale)
+	b = appendString(b, 5, m.Client)
+	return b
+}
+
+// Unmarshal decodes the message from the protobuf wire format
+func (m *RefreshRequest) Unmarshal(data []byte) error {
+	*m = RefreshRequest{}
+	return parseFields(data, func(f field) error {
+		switch f.number {
+		case 1:
+			m.ID = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 2:
+			m.Theme = string(f.data)
+			return checkWireType(f, wireBytes)
+		case 3:
+			m.Format = ImageFormat(f.number64)
+			return checkWireType(f, wireVarint)
+		case 4:
+			m.PNGScale = math.Float64frombits(f.number64)
+			return checkWireType(f, wireFixed64)
+		case 5:
+			m.Client = string(f.data)
+			return checkWireType(f, wireBytes)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package captcharpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// PipeListener is an in-memory net.Listener: DialContext connects to it without a network, so
+// a server and client can run in one process, for tests or embedding
+type PipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewPipeListener creates an in-memory listener
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection dialed with DialContext
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections; established connections stay open
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr returns the listener's placeholder address
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// DialContext connects to the listener; network and address are ignored, matching the dialer
+// signature of http.Transport
+func (l *PipeListener) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		err = errors.New("captcharpc: pipe listener closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	server.Close()
+	client.Close()
+	return nil, err
+}
+
+// pipeAddr is the address of a PipeListener
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,298 @@
+package captcharpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// ServiceName is the fully qualified protobuf name of the CaptchaService
+const ServiceName = "captcha.v1.CaptchaService"
+
+// maxMessageBytes limits the size of request messages
+const maxMessageBytes = 64 << 10
+
+// CaptchaServiceServer is the server API of the CaptchaService
+type CaptchaServiceServer interface {
+	Issue(ctx context.Context, req *IssueRequest) (*Challenge, error)
+	Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error)
+	Refresh(ctx context.Context, req *RefreshRequest) (*Challenge, error)
+}
+
+// Server implements the CaptchaService over a captcha.Service
+type Server struct {
+	service *captcha.Service
+}
+
+// NewServer creates a server issuing and verifying captchas with service
+func NewServer(service *captcha.Service) *Server {
+	return &Server{service: service}
+}
+
+// Issue generates a captcha
+func (s *Server) Issue(ctx context.Context, req *IssueRequest) (*Challenge, error) {
+	if err := checkFormat(req.Format, req.PNGScale); err != nil {
+		return nil, err
+	}
+
+	var challenge *captcha.Challenge
+	var err error
+	if req.Theme != "" {
+		challenge, err = s.service.IssueWithThemeContext(ctx, req.Theme)
+	} else {
+		challenge, err = s.service.IssueContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newChallenge(ctx, challenge, req.Format, req.PNGScale)
+}
+
+// Verify checks an answer and consumes the captcha
+func (s *Server) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	if req.ID == "" {
+		return nil, &StatusError{Code: CodeInvalidArgument, Message: "id is required"}
+	}
+
+	result, err := s.service.VerifyContext(ctx, req.ID, req.Answer)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyResponse{Success: result == captcha.VerifySuccess, Result: verifyResults[result]}, nil
+}
+
+// Refresh invalidates a captcha and issues a new one. Refreshes of a Client beyond the service's
+// refresh limit fail with CodeResourceExhausted.
+func (s *Server) Refresh(ctx context.Context, req *RefreshRequest) (*Challenge, error) {
+	if err := checkFormat(req.Format, req.PNGScale); err != nil {
+		return nil, err
+	}
+
+	var challenge *captcha.Challenge
+	var err error
+	if req.Client != "" {
+		challenge, err = s.service.RefreshClientContext(ctx, req.Client, req.ID, req.Theme)
+	} else {
+		challenge, err = s.service.RefreshWithThemeContext(ctx, req.ID, req.Theme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newChallenge(ctx, challenge, req.Format, req.PNGScale)
+}
+
+// MaxPNGScale is the largest PNGScale clients may request, bounding the memory one request
+// takes to rasterize
+const MaxPNGScale = 4
+
+// checkFormat rejects unknown image formats and PNG scales that are not in (0, MaxPNGScale],
+// except 0 for the default
+func checkFormat(format ImageFormat, scale float64) error {
+	if scale != 0 && !(scale > 0 && scale <= MaxPNGScale) {
+		return &StatusError{Code: CodeInvalidArgument, Message: "png_scale must be in (0, " + strconv.Itoa(MaxPNGScale) + "]"}
+	}
+	switch format {
+	case ImageFormatUnspecified, ImageFormatSVG, ImageFormatPNG:
+		return nil
+	}
+	return &StatusError{Code: CodeInvalidArgument, Message: "unknown image format " + strconv.Itoa(int(format))}
+}
+
+// newChallenge converts an issued captcha to its message, rasterizing it for ImageFormatPNG
+func newChallenge(ctx context.Context, challenge *captcha.Challenge, format ImageFormat, scale float64) (*Challenge, error) {
+	message := &Challenge{
+		ID:                 challenge.ID,
+		Image:              []byte(challenge.Result.Data),
+		ContentType:        "image/svg+xml",
+		ExpiresAtUnixMilli: challenge.ExpiresAt.UnixMilli(),
+	}
+	if format != ImageFormatPNG {
+		return message, nil
+	}
+
+	if scale == 0 {
+		scale = 1
+	}
+	image, err := challenge.Result.PNGContext(ctx, scale)
+	if err != nil {
+		return nil, err
+	}
+	message.Image = image
+	message.ContentType = "image/png"
+	return message, nil
+}
+
+// methodHandler decodes a request message, calls the method and returns the response message
+type methodHandler func(ctx context.Context, data []byte) (Message, error)
+
+// unary adapts a typed method to a methodHandler
+func unary[Req any, PReq interface {
+	*Req
+	Message
+}, Resp Message](method func(context.Context, PReq) (Resp, error)) methodHandler {
+	return func(ctx context.Context, data []byte) (Message, error) {
+		req := PReq(new(Req))
+		if err := req.Unmarshal(data); err != nil {
+			return nil, &StatusError{Code: CodeInvalidArgument, Message: err.Error()}
+		}
+		return method(ctx, req)
+	}
+}
+
+// handler serves the gRPC protocol for unary methods
+type handler struct {
+	methods map[string]methodHandler
+}
+
+// NewHandler serves srv with the gRPC protocol. It needs HTTP/2: serve it with NewHTTPServer,
+// or with TLS on a server with HTTP/2 enabled.
+func NewHandler(srv CaptchaServiceServer) http.Handler {
+	prefix := "/" + ServiceName + "/"
+	return &handler{methods: map[string]methodHandler{
+		prefix + "Issue":   unary(srv.Issue),
+		prefix + "Verify":  unary(srv.Verify),
+		prefix + "Refresh": unary(srv.Refresh),
+	}}
+}
+
+// NewHTTPServer creates an HTTP server for srv that accepts HTTP/2 with TLS and, for trusted
+// networks and in-process listeners, without (h2c)
+func NewHTTPServer(srv CaptchaServiceServer) *http.Server {
+	server := &http.Server{
+		Handler:           NewHandler(srv),
+		ReadHeaderTimeout: 5 * time.Second,
+		Protocols:         new(http.Protocols),
+	}
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	return server
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "gRPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	method, ok := h.methods[r.URL.Path]
+	if !ok {
+		writeStatus(w, &StatusError{Code: CodeUnimplemented, Message: "unknown method " + r.URL.Path})
+		return
+	}
+
+	ctx := r.Context()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	data, err := readMessage(r.Body, maxMessageBytes)
+	if err != nil {
+		writeStatus(w, toStatus(err))
+		return
+	}
+
+	response, err := method(ctx, data)
+	if err == nil && ctx.Err() != nil {
+		err = &StatusError{Code: CodeDeadlineExceeded, Message: ctx.Err().Error()}
+	}
+	if err != nil {
+		writeStatus(w, toStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	w.Write(appendFrame(nil, response.Marshal()))
+	w.Header().Set("Grpc-Status", "0")
+	w.Header().Set("Grpc-Message", "")
+}
+
+// writeStatus answers a failed call without a message, with the status in the headers
+// ("Trailers-Only" in the gRPC protocol)
+func writeStatus(w http.ResponseWriter, status *StatusError) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(status.Code)))
+	w.Header().Set("Grpc-Message", encodeStatusMessage(status.Message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// appendFrame appends message with the gRPC length prefix: an uncompressed flag and the
+// big-endian length
+func appendFrame(b []byte, message []byte) []byte {
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(message)))
+	return append(b, message...)
+}
+
+// readMessage reads one length-prefixed message of at most limit bytes
+func readMessage(r io.Reader, limit int) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, &StatusError{Code: CodeInvalidArgument, Message: "missing message"}
+	}
+	if header[0] != 0 {
+		return nil, &StatusError{Code: CodeUnimplemented, Message: "compressed messages are not supported"}
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > uint32(limit) {
+		return nil, &StatusError{Code: CodeResourceExhausted, Message: "message larger than " + strconv.Itoa(limit) + " bytes"}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, &StatusError{Code: CodeInvalidArgument, Message: "truncated message"}
+	}
+	return data, nil
+}
+
+// timeoutUnits are the units of the grpc-timeout header
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseTimeout parses a grpc-timeout header such as "250m"
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	unit, ok := timeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+	return time.Duration(amount) * unit, true
+}
+
+// formatTimeout formats d as a grpc-timeout header, in milliseconds rounded up or in seconds
+// beyond the eight digits the header allows
+func formatTimeout(d time.Duration) string {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms < 1 {
+		ms = 1
+	}
+	if ms > 99999999 {
+		return strconv.FormatInt(int64(min(d/time.Second, 99999999)), 10) + "S"
+	}
+	return strconv.FormatInt(int64(ms), 10) + "m"
+}
@@ -0,0 +1,110 @@
+package captcharpc
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"svg-math-captcha/captcha"
+)
+
+// Code is a gRPC status code
+type Code uint32
+
+// Status codes used by the CaptchaService
+const (
+	CodeOK                Code = 0
+	CodeUnknown           Code = 2
+	CodeInvalidArgument   Code = 3
+	CodeDeadlineExceeded  Code = 4
+	CodeNotFound          Code = 5
+	CodePermissionDenied  Code = 7
+	CodeResourceExhausted Code = 8
+	CodeUnimplemented     Code = 12
+	CodeInternal          Code = 13
+	CodeUnavailable       Code = 14
+)
+
+// StatusError is an RPC that failed with a gRPC status
+type StatusError struct {
+	Code    Code
+	Message string
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return "captcharpc: code " + strconv.Itoa(int(e.Code)) + ": " + e.Message
+}
+
+// StatusCode returns the gRPC status code of err: the code of a StatusError in its chain,
+// CodeOK for nil and CodeUnknown otherwise
+func StatusCode(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code
+	}
+	return CodeUnknown
+}
+
+// toStatus converts a service error to the status sent to clients. Like problem responses,
+// messages of server errors are hidden.
+func toStatus(err error) *StatusError {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr
+	}
+
+	status := captcha.HTTPStatus(err)
+	message := http.StatusText(status)
+	if captchaErr, ok := captcha.AsCaptchaError(err); ok && status < 500 {
+		message = captchaErr.Message
+	}
+
+	switch {
+	case status == http.StatusForbidden:
+		return &StatusError{Code: CodePermissionDenied, Message: message}
+	case status == http.StatusNotFound:
+		return &StatusError{Code: CodeNotFound, Message: message}
+	case status == http.StatusTooManyRequests:
+		return &StatusError{Code: CodeResourceExhausted, Message: message}
+	case status >= 400 && status < 500:
+		return &StatusError{Code: CodeInvalidArgument, Message: message}
+	}
+	return &StatusError{Code: CodeInternal, Message: message}
+}
+
+// encodeStatusMessage percent-encodes a grpc-message value as the gRPC protocol requires
+func encodeStatusMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(strconv.FormatUint(uint64(c)>>4, 16)))
+			b.WriteString(strings.ToUpper(strconv.FormatUint(uint64(c)&0xf, 16)))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeStatusMessage reverses encodeStatusMessage, keeping malformed escapes as they are
+func decodeStatusMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if message[i] == '%' && i+2 < len(message) {
+			if v, err := strconv.ParseUint(message[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(message[i])
+	}
+	return b.String()
+}
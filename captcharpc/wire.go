@@ -0,0 +1,136 @@
+package captcharpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// errTruncated reports a message that ends inside a field
+var errTruncated = errors.New("captcharpc: truncated message")
+
+// appendTag appends the key of field number with wire type
+func appendTag(b []byte, number int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(number)<<3|uint64(wireType))
+}
+
+// appendString appends a length-delimited field, omitting empty values as proto3 does
+func appendString(b []byte, number int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = appendTag(b, number, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendBytes appends a bytes field, omitting empty values
+func appendBytes(b []byte, number int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = appendTag(b, number, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendVarint appends an int64, enum or bool field, omitting zero values
+func appendVarint(b []byte, number int, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendTag(b, number, wireVarint)
+	return binary.AppendUvarint(b, value)
+}
+
+// appendDouble appends a double field, omitting zero values
+func appendDouble(b []byte, number int, value float64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendTag(b, number, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+}
+
+// boolVarint converts a bool to its varint value
+func boolVarint(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// field is one decoded field; varint and fixed values are in number, bytes in data
+type field struct {
+	number   int
+	wireType int
+	number64 uint64
+	data     []byte
+}
+
+// parseFields calls fn for every field of a message. Unknown field numbers reach fn too, which
+// ignores them so older readers accept newer messages.
+func parseFields(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+
+		f := field{number: int(key >> 3), wireType: int(key & 7)}
+		if f.number <= 0 {
+			return errors.New("captcharpc: invalid field number")
+		}
+		switch f.wireType {
+		case wireVarint:
+			f.number64, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			f.number64 = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f.number64 = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return errTruncated
+			}
+			f.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return errors.New("captcharpc: unsupported wire type")
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWireType fails if f does not have the wire type its field number requires
+func checkWireType(f field, wireType int) error {
+	if f.wireType != wireType {
+		return errors.New("captcharpc: wrong wire type for field")
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+// Command captcha-server runs the captcha JSON API as a standalone service.
+//
+// Routes:
+//
+//	GET|POST /api/captcha/issue   issue a captcha
+//	POST     /api/captcha/verify  verify an answer
+//	POST     /api/captcha/refresh replace a captcha, rate-limited per session or client address
+//	GET      /healthz             liveness, 200 while the process runs
+//	GET      /readyz              readiness, 503 before serving and while shutting down
+//	GET      /metrics             Prometheus metrics
+//
+// Usage:
+//
+//	go run ./cmd/captcha-server -addr :8080
+//	go run ./cmd/captcha-server -addr :8443 -tls-cert cert.pem -tls-key key.pem -store file -store-dir /var/lib/captcha
+//	CAPTCHA_NOISE=4 go run ./cmd/captcha-server -env -log-format json
+//	go run ./cmd/captcha-server -cors-origins https://app.example.com -cors-credentials
+//	head -c 32 /dev/urandom > session.key && go run ./cmd/captcha-server -session-key-file session.key
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// Store kinds for the -store flag
+const (
+	storeMemory = "memory"
+	storeFile   = "file"
+)
+
+// serverConfig holds the command-line settings
+type serverConfig struct {
+	addr            string
+	tlsCert         string
+	tlsKey          string
+	prefix          string
+	shutdownTimeout time.Duration
+	ttl             time.Duration
+	cleanupInterval time.Duration
+	refreshLimit    int
+	refreshWindow   time.Duration
+	issueLimit      int
+	issueWindow     time.Duration
+	trustedProxies  string
+	store           string
+	storeDir        string
+	configPath      string
+	useEnv          bool
+	encoding        string
+	logLevel        string
+	logFormat       string
+	corsOrigins     string
+	corsCredentials bool
+	corsMaxAge      time.Duration
+	sessionKeyFile  string
+	sameSite        string
+	insecureCookies bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "captcha-server: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run serves until ctx is done, then shuts down gracefully
+func run(ctx context.Context, args []string, stderr io.Writer) error {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		return err
+	}
+
+	logger, err := newLogger(cfg, stderr)
+	if err != nil {
+		return err
+	}
+
+	app, err := newApp(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		return err
+	}
+	return app.serve(ctx, listener)
+}
+
+// parseFlags reads the command-line settings
+func parseFlags(args []string, stderr io.Writer) (*serverConfig, error) {
+	cfg := &serverConfig{}
+	fs := flag.NewFlagSet("captcha-server", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	fs.StringVar(&cfg.addr, "addr", ":8080", "listen address")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "TLS private key file")
+	fs.StringVar(&cfg.prefix, "prefix", "/api/captcha", "path prefix of the captcha API")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 10*time.Second, "time allowed for in-flight requests on shutdown")
+	fs.DurationVar(&cfg.ttl, "ttl", captcha.DefaultChallengeTTL, "how long issued captchas stay valid")
+	fs.DurationVar(&cfg.cleanupInterval, "cleanup-interval", time.Minute, "how often expired captchas are removed")
+	fs.IntVar(&cfg.refreshLimit, "refresh-limit", captcha.DefaultRefreshLimit, "refreshes allowed per session or client address in each -refresh-window, 0 for no cap")
+	fs.DurationVar(&cfg.refreshWindow, "refresh-window", captcha.DefaultRefreshWindow, "window of -refresh-limit")
+	fs.IntVar(&cfg.issueLimit, "issue-limit", 0, "captchas issued without a pending one allowed per client address in each -issue-window, 0 for no cap")
+	fs.DurationVar(&cfg.issueWindow, "issue-window", time.Minute, "window of -issue-limit")
+	fs.StringVar(&cfg.trustedProxies, "trusted-proxies", "", "comma-separated proxy addresses or CIDR ranges whose X-Forwarded-For header names the client address")
+	fs.StringVar(&cfg.store, "store", storeMemory, "captcha store: memory or file")
+	fs.StringVar(&cfg.storeDir, "store-dir", "", "directory of the file store")
+	fs.StringVar(&cfg.configPath, "config", "", "JSON captcha config file")
+	fs.BoolVar(&cfg.useEnv, "env", false, "load the captcha config from CAPTCHA_* environment variables")
+	fs.StringVar(&cfg.encoding, "encoding", captcha.EncodingBase64, "default image encoding: svg, base64, utf8 or png")
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.logFormat, "log-format", "text", "log format: text or json")
+	fs.StringVar(&cfg.corsOrigins, "cors-origins", "", "comma-separated origins allowed to call the API from browsers, such as https://*.example.com")
+	fs.BoolVar(&cfg.corsCredentials, "cors-credentials", false, "let browsers send cookies with cross-origin requests")
+	fs.StringVar(&cfg.sessionKeyFile, "session-key-file", "", "file holding at least 32 random bytes, used as they are, that sign session cookies; binds captchas to sessions")
+	fs.StringVar(&cfg.sameSite, "same-site", "lax", "SameSite attribute of session cookies: lax, strict or none")
+	fs.BoolVar(&cfg.insecureCookies, "insecure-cookies", false, "send session cookies over plain HTTP, for development")
+	fs.DurationVar(&cfg.corsMaxAge, "cors-max-age", handler.DefaultCORSMaxAge, "how long browsers may cache preflight responses, negative disables caching")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+	if !strings.HasPrefix(cfg.prefix, "/") || strings.HasSuffix(cfg.prefix, "/") {
+		return nil, errors.New("-prefix must start and must not end with /")
+	}
+	if cfg.cleanupInterval <= 0 || cfg.shutdownTimeout <= 0 {
+		return nil, errors.New("-cleanup-interval and -shutdown-timeout must be positive")
+	}
+	switch cfg.encoding {
+	case captcha.EncodingSVG, captcha.EncodingBase64, captcha.EncodingUTF8, captcha.EncodingPNG:
+	default:
+		return nil, fmt.Errorf("unknown -encoding %q, want svg, base64, utf8 or png", cfg.encoding)
+	}
+	return cfg, nil
+}
+
+// newLogger creates the logger selected by the log flags
+func newLogger(cfg *serverConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid -log-level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown -log-format %q, want text or json", cfg.logFormat)
+}
+
+// newStore creates the store selected by the store flags
+func newStore(cfg *serverConfig) (captcha.Store, error) {
+	switch cfg.store {
+	case storeMemory:
+		return captcha.NewMemoryStore(), nil
+	case storeFile:
+		if cfg.storeDir == "" {
+			return nil, errors.New("-store file requires -store-dir")
+		}
+		return captcha.NewFileStore(cfg.storeDir)
+	}
+	return nil, fmt.Errorf("unknown -store %q, want memory or file", cfg.store)
+}
+
+// newSessions creates the session transport selected by the session flags
+func newSessions(cfg *serverConfig) (*handler.Sessions, error) {
+	key, err := os.ReadFile(cfg.sessionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is used as it is: random key bytes may well be whitespace
+	config := handler.SessionConfig{Key: key, Insecure: cfg.insecureCookies}
+	switch cfg.sameSite {
+	case "lax":
+		config.SameSite = http.SameSiteLaxMode
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("unknown -same-site %q, want lax, strict or none", cfg.sameSite)
+	}
+	return handler.NewSessions(config)
+}
+
+// loadCaptchaConfig builds the captcha configuration from defaults, the environment and a file
+func loadCaptchaConfig(cfg *serverConfig) (*captcha.Config, error) {
+	config := captcha.DefaultConfig()
+	if cfg.useEnv {
+		var err error
+		if config, err = captcha.LoadConfigFromEnvE(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.configPath != "" {
+		data, err := os.ReadFile(cfg.configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", cfg.configPath, err)
+		}
+	}
+	return config, config.Validate()
+}
+
+// app is the running service with its dependencies
+type app struct {
+	cfg     *serverConfig
+	logger  *slog.Logger
+	service *captcha.Service
+	metrics *captcha.MetricsRegistry
+	issues  *captcha.RateLimiter
+	handler *handler.Handler
+	cors    *handler.CORS
+	ready   atomic.Bool
+}
+
+// newApp wires the captcha service, store, metrics and handler
+func newApp(cfg *serverConfig, logger *slog.Logger) (*app, error) {
+	config, err := loadCaptchaConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	generator := captcha.NewCaptchaGenerator(config, captcha.WithLogger(logger))
+	service := captcha.NewService(generator, store)
+	if err := service.SetTTL(cfg.ttl); err != nil {
+		return nil, err
+	}
+	if err := service.SetRefreshLimit(cfg.refreshLimit, cfg.refreshWindow); err != nil {
+		return nil, err
+	}
+	metrics := captcha.NewMetricsRegistry()
+	service.SetMetrics(metrics)
+
+	opts := []handler.Option{handler.WithLogger(logger), handler.WithEncoding(cfg.encoding)}
+	if cfg.sessionKeyFile != "" {
+		sessions, err := newSessions(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, handler.WithSessions(sessions))
+	}
+	var issues *captcha.RateLimiter
+	if cfg.issueLimit != 0 {
+		if issues, err = captcha.NewRateLimiter(cfg.issueLimit, cfg.issueWindow); err != nil {
+			return nil, err
+		}
+		opts = append(opts, handler.WithIssueLimiter(issues))
+	}
+	if cfg.trustedProxies != "" {
+		proxies, err := parseProxies(cfg.trustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, handler.WithTrustedProxies(proxies...))
+	}
+
+	var cors *handler.CORS
+	if cfg.corsOrigins != "" {
+		cors, err = handler.NewCORS(handler.CORSConfig{
+			AllowedOrigins:   splitList(cfg.corsOrigins),
+			AllowCredentials: cfg.corsCredentials,
+			MaxAge:           cfg.corsMaxAge,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &app{
+		cfg:     cfg,
+		logger:  logger,
+		service: service,
+		metrics: metrics,
+		issues:  issues,
+		handler: handler.New(service, opts...),
+		cors:    cors,
+	}, nil
+}
+
+// routes returns the HTTP handler of all endpoints
+func (a *app) routes() http.Handler {
+	var api http.Handler = a.handler
+	if a.cors != nil {
+		api = a.cors.Handler(api)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(a.cfg.prefix+"/", http.StripPrefix(a.cfg.prefix, api))
+	mux.Handle("GET /metrics", a.metrics)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !a.ready.Load() {
+			writeStatus(w, http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		writeStatus(w, http.StatusOK, "ready")
+	})
+	return handler.LogRequests(a.logger, mux)
+}
+
+// serve answers requests on listener until ctx is done, then stops accepting connections and
+// waits up to the shutdown timeout for in-flight requests
+func (a *app) serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           a.routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ErrorLog:          slog.NewLogLogger(a.logger.Handler(), slog.LevelWarn),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if a.cfg.tlsCert != "" {
+			serveErr <- server.ServeTLS(listener, a.cfg.tlsCert, a.cfg.tlsKey)
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	cleanupCtx, stopCleanup := context.WithCancel(ctx)
+	defer stopCleanup()
+	go a.cleanupLoop(cleanupCtx)
+
+	a.ready.Store(true)
+	a.logger.Info("captcha server listening", slog.String("addr", listener.Addr().String()),
+		slog.Bool("tls", a.cfg.tlsCert != ""), slog.String("store", a.cfg.store))
+
+	select {
+	case err := <-serveErr:
+		a.ready.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	a.ready.Store(false)
+	a.logger.Info("shutting down", slog.Duration("timeout", a.cfg.shutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// cleanupLoop removes expired captchas every cleanup interval until ctx is done
+func (a *app) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := a.service.Cleanup(); removed > 0 {
+				a.logger.Debug("removed expired captchas", slog.Int("count", removed))
+			}
+			if a.issues != nil {
+				a.issues.Cleanup(time.Now())
+			}
+		}
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping spaces around the items
+func splitList(value string) []string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// parseProxies parses the -trusted-proxies list, taking a plain address as a range of one
+func parseProxies(value string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, item := range splitList(value) {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -trusted-proxies: %w", err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -trusted-proxies: %w", err)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// writeStatus answers a probe with a small JSON status
+func writeStatus(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": message})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApp creates an app with flags args and a silent logger
+func newTestApp(t *testing.T, args ...string) *app {
+	t.Helper()
+	cfg, err := parseFlags(args, io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	a, err := newApp(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+	return a
+}
+
+func TestRoutes(t *testing.T) {
+	a := newTestApp(t)
+	routes := a.routes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	if recorder := get("/healthz"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to answer 200, got %d", recorder.Code)
+	}
+	if recorder := get("/readyz"); recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to answer 503 before serving, got %d", recorder.Code)
+	}
+	a.ready.Store(true)
+	if recorder := get("/readyz"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected /readyz to answer 200 when ready, got %d", recorder.Code)
+	}
+
+	recorder := get("/api/captcha/issue")
+	var issued struct{ ID, Image string }
+	if err := json.Unmarshal(recorder.Body.Bytes(), &issued); err != nil || issued.ID == "" {
+		t.Fatalf("Expected a captcha, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/api/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	routes.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), `"result":"failure"`) {
+		t.Errorf("Expected a failed verification, got %s", recorder.Body.String())
+	}
+
+	metrics := get("/metrics").Body.String()
+	if !strings.Contains(metrics, `captcha_verifications_total{result="failure"} 1`) {
+		t.Errorf("Expected the verification in the metrics, got %s", metrics)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	routes := newTestApp(t, "-cors-origins", "https://app.example.com, https://*.example.org", "-cors-credentials").routes()
+
+	request := httptest.NewRequest("OPTIONS", "/api/captcha/verify", nil)
+	request.Header.Set("Origin", "https://shop.example.org")
+	request.Header.Set("Access-Control-Request-Method", "POST")
+	request.Header.Set("Access-Control-Request-Headers", "content-type")
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Origin") != "https://shop.example.org" ||
+		recorder.Header().Get("Access-Control-Allow-Credentials") != "true" || recorder.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Expected an allowed preflight, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	request = httptest.NewRequest("GET", "/api/captcha/issue", nil)
+	request.Header.Set("Origin", "https://evil.example.com")
+	recorder = httptest.NewRecorder()
+	routes.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for other origins, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	// Without -cors-origins the API stays same-origin
+	request = httptest.NewRequest("GET", "/api/captcha/issue", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	recorder = httptest.NewRecorder()
+	newTestApp(t).routes().ServeHTTP(recorder, request)
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers by default, got %v", recorder.Header())
+	}
+}
+
+func TestSessions(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "session.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("k", 32)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	routes := newTestApp(t, "-session-key-file", keyFile).routes()
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/captcha/issue", nil))
+	var issued struct{ ID, CSRFToken string }
+	json.Unmarshal(recorder.Body.Bytes(), &issued)
+	cookies := recorder.Result().Cookies()
+	if issued.CSRFToken == "" || len(cookies) != 1 || !cookies[0].Secure {
+		t.Fatalf("Expected a CSRF token and a secure session cookie, got %s %v", recorder.Body.String(), cookies)
+	}
+
+	// Verification needs the session cookie
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/api/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	request.Header.Set("X-CSRF-Token", issued.CSRFToken)
+	routes.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected verification without the session to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	request.Body = io.NopCloser(strings.NewReader(`{"id":"` + issued.ID + `","answer":"-1"}`))
+	request.AddCookie(cookies[0])
+	routes.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), `"result":"failure"`) {
+		t.Errorf("Expected a failed verification in the session, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Binary keys are used byte for byte, whitespace included
+	binaryKey := filepath.Join(t.TempDir(), "binary.key")
+	if err := os.WriteFile(binaryKey, []byte("\t"+strings.Repeat("\x00", 31)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := parseFlags([]string{"-session-key-file", binaryKey}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err != nil {
+		t.Errorf("Expected a 32-byte key starting with a tab to be accepted: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"-session-key-file", filepath.Join(t.TempDir(), "missing")},
+		{"-session-key-file", keyFile, "-same-site", "loose"},
+		{"-session-key-file", keyFile, "-same-site", "none", "-insecure-cookies"},
+	} {
+		cfg, _ := parseFlags(args, io.Discard)
+		if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestRefreshLimit(t *testing.T) {
+	routes := newTestApp(t, "-refresh-limit", "1", "-refresh-window", "1h").routes()
+
+	recorder := httptest.NewRecorder()
+	routes.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/captcha/issue", nil))
+	var issued struct{ ID string }
+	json.Unmarshal(recorder.Body.Bytes(), &issued)
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		recorder = httptest.NewRecorder()
+		routes.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/captcha/refresh", strings.NewReader(`{"id":"`+issued.ID+`"}`)))
+		if recorder.Code != want {
+			t.Errorf("Expected %d, got %d %s", want, recorder.Code, recorder.Body.String())
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &issued)
+	}
+}
+
+func TestIssueLimit(t *testing.T) {
+	routes := newTestApp(t, "-issue-limit", "1", "-issue-window", "1h", "-trusted-proxies", "10.0.0.0/8, 192.0.2.1").routes()
+
+	issue := func(remoteAddr, forwardedFor string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/captcha/issue", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		routes.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		if code := issue("192.0.2.1:1234", "198.51.100.7"); code != want {
+			t.Errorf("Expected %d for the forwarded client, got %d", want, code)
+		}
+	}
+	if code := issue("10.0.0.5:1234", "198.51.100.8"); code != http.StatusOK {
+		t.Errorf("Expected another forwarded client to be allowed, got %d", code)
+	}
+}
+
+func TestFileStoreSelection(t *testing.T) {
+	a := newTestApp(t, "-store", "file", "-store-dir", t.TempDir())
+	recorder := httptest.NewRecorder()
+	a.routes().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/captcha/issue", nil))
+	if recorder.Code != http.StatusOK || a.service.Store().Len() != 1 {
+		t.Errorf("Expected a captcha in the file store, got %d with %d stored", recorder.Code, a.service.Store().Len())
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	a := newTestApp(t, "-shutdown-timeout", "5s")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- a.serve(ctx, listener) }()
+
+	url := "http://" + listener.Addr().String()
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.ready.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	response, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatalf("Failed to reach server: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected ready server, got %d", response.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Server did not shut down")
+	}
+	if a.ready.Load() {
+		t.Error("Expected the server not to be ready after shutdown")
+	}
+}
+
+func TestInvalidFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-tls-cert", "cert.pem"},
+		{"-prefix", "api/"},
+		{"-encoding", "gif"},
+		{"-cleanup-interval", "0s"},
+		{"extra"},
+	} {
+		if _, err := parseFlags(args, io.Discard); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+
+	cfg, _ := parseFlags([]string{"-store", "redis"}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("Expected an unknown store to be rejected")
+	}
+	cfg, _ = parseFlags([]string{"-refresh-limit", "-1"}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("Expected a negative refresh limit to be rejected")
+	}
+	cfg, _ = parseFlags([]string{"-issue-limit", "-1"}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("Expected a negative issue limit to be rejected")
+	}
+	cfg, _ = parseFlags([]string{"-trusted-proxies", "10.0.0.0/33"}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("Expected an invalid trusted proxy to be rejected")
+	}
+	cfg, _ = parseFlags([]string{"-cors-origins", "*", "-cors-credentials"}, io.Discard)
+	if _, err := newApp(cfg, slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("Expected credentials for any origin to be rejected")
+	}
+	cfg, _ = parseFlags([]string{"-log-format", "xml"}, io.Discard)
+	if _, err := newLogger(cfg, io.Discard); err == nil {
+		t.Error("Expected an unknown log format to be rejected")
+	}
+}
@@ -0,0 +1,355 @@
+// Command svg-captcha generates captcha images and a manifest of their questions and answers,
+// for example to build a labeled dataset.
+//
+// Every Config field has a flag. Flags override a JSON config file (-config), which overrides
+// the CAPTCHA_* environment variables (-env), which override the defaults.
+//
+// Usage:
+//
+//	go run ./cmd/svg-captcha -n 100 -out dataset -format both -seed 42
+//	go run ./cmd/svg-captcha -config my-config.json -manifest csv
+//	CAPTCHA_NOISE=4 go run ./cmd/svg-captcha -env -width 300 -height 100
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/internal/randsource"
+)
+
+// Output formats
+const (
+	formatSVG  = "svg"
+	formatPNG  = "png"
+	formatBoth = "both"
+)
+
+// Manifest formats
+const (
+	manifestJSON = "json"
+	manifestCSV  = "csv"
+	manifestNone = "none"
+)
+
+// options are the settings of one run besides the captcha configuration
+type options struct {
+	count      int
+	outDir     string
+	prefix     string
+	format     string
+	pngScale   float64
+	manifest   string
+	seed       uint64
+	seeded     bool
+	configPath string
+	useEnv     bool
+	theme      string
+}
+
+// manifestEntry describes one generated captcha
+type manifestEntry struct {
+	ID       string `json:"id"`
+	SVG      string `json:"svg,omitempty"`
+	PNG      string `json:"png,omitempty"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command with args and returns its exit status
+func run(args []string, stdout, stderr io.Writer) int {
+	config, opts, err := parseArgs(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "svg-captcha: %v\n", err)
+		return 2
+	}
+
+	entries, err := generate(config, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "svg-captcha: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Generated %d captchas in %s\n", len(entries), opts.outDir)
+	return 0
+}
+
+// parseArgs builds the configuration from defaults, the environment, a config file, a theme and
+// flags, in increasing precedence
+func parseArgs(args []string, stderr io.Writer) (*captcha.Config, *options, error) {
+	// The first pass only finds the config sources; the second applies flags on top of them
+	fs, _, opts := newFlagSet(captcha.DefaultConfig(), io.Discard)
+	if err := fs.Parse(args); err != nil {
+		// Parse again with output so usage and errors are reported once
+		fs, _, _ = newFlagSet(captcha.DefaultConfig(), stderr)
+		return nil, nil, fs.Parse(args)
+	}
+
+	base, err := loadBaseConfig(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs, config, opts := newFlagSet(base, stderr)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			opts.seeded = true
+		}
+	})
+
+	if err := validateOptions(opts); err != nil {
+		return nil, nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return config, opts, nil
+}
+
+// loadBaseConfig returns the configuration the flags apply to
+func loadBaseConfig(opts *options) (*captcha.Config, error) {
+	config := captcha.DefaultConfig()
+	if opts.useEnv {
+		var err error
+		if config, err = captcha.LoadConfigFromEnvE(); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.configPath != "" {
+		data, err := os.ReadFile(opts.configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", opts.configPath, err)
+		}
+	}
+
+	if opts.theme != "" {
+		if err := config.ApplyTheme(opts.theme); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// validateOptions checks the settings that are not part of the captcha configuration
+func validateOptions(opts *options) error {
+	if opts.count <= 0 {
+		return errors.New("-n must be positive")
+	}
+	switch opts.format {
+	case formatSVG, formatPNG, formatBoth:
+	default:
+		return fmt.Errorf("unknown format %q, want svg, png or both", opts.format)
+	}
+	switch opts.manifest {
+	case manifestJSON, manifestCSV, manifestNone:
+	default:
+		return fmt.Errorf("unknown manifest format %q, want json, csv or none", opts.manifest)
+	}
+	if opts.pngScale <= 0 {
+		return errors.New("-png-scale must be positive")
+	}
+	return nil
+}
+
+// newFlagSet defines the command's flags, with every Config field bound to a copy of base
+func newFlagSet(base *captcha.Config, output io.Writer) (*flag.FlagSet, *captcha.Config, *options) {
+	config := *base
+	config.TextColors = append([]string(nil), base.TextColors...)
+	config.NoiseColors = append([]string(nil), base.NoiseColors...)
+	opts := &options{}
+
+	fs := flag.NewFlagSet("svg-captcha", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	// Run settings
+	fs.IntVar(&opts.count, "n", 1, "number of captchas to generate")
+	fs.StringVar(&opts.outDir, "out", ".", "output directory")
+	fs.StringVar(&opts.prefix, "prefix", "captcha", "file name prefix")
+	fs.StringVar(&opts.format, "format", formatSVG, "image format: svg, png or both")
+	fs.Float64Var(&opts.pngScale, "png-scale", 1, "PNG resolution relative to the SVG size")
+	fs.StringVar(&opts.manifest, "manifest", manifestJSON, "manifest format: json, csv or none")
+	fs.Uint64Var(&opts.seed, "seed", 0, "seed for a reproducible dataset (default: crypto/rand)")
+	fs.StringVar(&opts.configPath, "config", "", "JSON config file")
+	fs.BoolVar(&opts.useEnv, "env", false, "load the configuration from CAPTCHA_* environment variables")
+	fs.StringVar(&opts.theme, "theme", "", "named theme applied before the color flags")
+
+	// Math expression settings
+	fs.IntVar(&config.MathMin, "math-min", config.MathMin, "minimum operand value")
+	fs.IntVar(&config.MathMax, "math-max", config.MathMax, "maximum operand value")
+	fs.StringVar(&config.MathOperator, "operator", config.MathOperator, `operators to use: "+", "-" or "+-"`)
+
+	// Visual settings
+	fs.IntVar(&config.Width, "width", config.Width, "width in pixels")
+	fs.IntVar(&config.Height, "height", config.Height, "height in pixels")
+	fs.IntVar(&config.FontSize, "font-size", config.FontSize, "font size")
+	fs.IntVar(&config.Noise, "noise", config.Noise, "noise level 0-10")
+	fs.BoolVar(&config.Color, "color", config.Color, "use random colors")
+	fs.StringVar(&config.Background, "background", config.Background, "background color")
+	fs.Var((*listFlag)(&config.TextColors), "text-colors", "comma-separated text palette")
+	fs.Var((*listFlag)(&config.NoiseColors), "noise-colors", "comma-separated noise palette")
+
+	// Accessibility settings
+	fs.Float64Var(&config.MinContrast, "min-contrast", config.MinContrast, "minimum WCAG contrast of text colors, 0 disables")
+	fs.StringVar(&config.Palette, "palette", config.Palette, `text palette: "default" or "colorblind"`)
+	fs.BoolVar(&config.HighContrast, "high-contrast", config.HighContrast, "adjust text to a contrast of at least 7")
+
+	// Noise settings
+	fs.Float64Var(&config.NoiseIntersect, "noise-intersect", config.NoiseIntersect, "fraction of noise lines forced through the text, 0-1")
+	fs.Float64Var(&config.LineOpacity.Min, "line-opacity-min", config.LineOpacity.Min, "lowest noise line opacity")
+	fs.Float64Var(&config.LineOpacity.Max, "line-opacity-max", config.LineOpacity.Max, "highest noise line opacity")
+	fs.Float64Var(&config.DotOpacity.Min, "dot-opacity-min", config.DotOpacity.Min, "lowest noise dot opacity")
+	fs.Float64Var(&config.DotOpacity.Max, "dot-opacity-max", config.DotOpacity.Max, "highest noise dot opacity")
+
+	// Decoy and text settings
+	fs.IntVar(&config.Decoys, "decoys", config.Decoys, "decoy characters behind the text, 0-30")
+	fs.Float64Var(&config.DecoyOpacity, "decoy-opacity", config.DecoyOpacity, "maximum decoy strength, 0-1")
+	fs.StringVar(&config.IgnoreChars, "ignore-chars", config.IgnoreChars, "characters to avoid")
+
+	// Distortion settings
+	fs.Float64Var(&config.Distortion.WaveAmplitude, "distort-wave-amplitude", config.Distortion.WaveAmplitude, "sine-wave warp amplitude in pixels")
+	fs.Float64Var(&config.Distortion.WavePeriod, "distort-wave-period", config.Distortion.WavePeriod, "sine-wave period in pixels, 0 uses half the width")
+	fs.Float64Var(&config.Distortion.Skew, "distort-skew", config.Distortion.Skew, "maximum per-glyph skew in degrees, 0-45")
+	fs.Float64Var(&config.Distortion.Scale, "distort-scale", config.Distortion.Scale, "maximum per-glyph scale deviation, 0-0.5")
+	fs.Float64Var(&config.Distortion.Overlap, "distort-overlap", config.Distortion.Overlap, "fraction of each glyph overlapped by the next, 0-0.5")
+	fs.Float64Var(&config.Distortion.StrokeJitter, "distort-stroke-jitter", config.Distortion.StrokeJitter, "maximum extra stroke width in pixels")
+
+	// Effect settings
+	fs.Float64Var(&config.Effects.Displacement, "effect-displacement", config.Effects.Displacement, "turbulence displacement in pixels, 0-20")
+	fs.Float64Var(&config.Effects.BaseFrequency, "effect-base-frequency", config.Effects.BaseFrequency, "turbulence base frequency, 0 uses 0.05")
+	fs.Float64Var(&config.Effects.Blur, "effect-blur", config.Effects.Blur, "Gaussian blur deviation, 0-5")
+	fs.StringVar(&config.Effects.ApplyTo, "effect-target", config.Effects.ApplyTo, `filter target: "text", "background" or "both"`)
+	fs.BoolVar(&config.Effects.Gradient, "effect-gradient", config.Effects.Gradient, "fill the background with a random gradient")
+	fs.StringVar(&config.Effects.Pattern, "effect-pattern", config.Effects.Pattern, `background pattern: "dots", "stripes" or "grid"`)
+
+	// Output settings
+	fs.BoolVar(&config.Minify, "minify", config.Minify, "write compact SVG")
+	fs.IntVar(&config.MaxBytes, "max-bytes", config.MaxBytes, "SVG size budget in bytes, 0 disables")
+
+	return fs, &config, opts
+}
+
+// listFlag is a comma-separated list flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// generate writes opts.count captchas and their manifest to opts.outDir
+func generate(config *captcha.Config, opts *options) ([]manifestEntry, error) {
+	if opts.seeded {
+		restore := randsource.Set(randsource.NewSeeded(opts.seed))
+		defer restore()
+	}
+
+	if err := os.MkdirAll(opts.outDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	generator := captcha.NewCaptchaGenerator(config)
+	width := len(strconv.Itoa(opts.count))
+	entries := make([]manifestEntry, 0, opts.count)
+	for i := 1; i <= opts.count; i++ {
+		result, err := generator.CreateMathExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := manifestEntry{
+			ID:       fmt.Sprintf("%s_%0*d", opts.prefix, width, i),
+			Question: result.Question,
+			Answer:   result.Text,
+		}
+		if opts.format != formatPNG {
+			entry.SVG = entry.ID + ".svg"
+			if err := os.WriteFile(filepath.Join(opts.outDir, entry.SVG), []byte(result.Data), 0o644); err != nil {
+				return nil, err
+			}
+		}
+		if opts.format != formatSVG {
+			data, err := result.PNG(opts.pngScale)
+			if err != nil {
+				return nil, err
+			}
+			entry.PNG = entry.ID + ".png"
+			if err := os.WriteFile(filepath.Join(opts.outDir, entry.PNG), data, 0o644); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, writeManifest(entries, opts)
+}
+
+// writeManifest writes the manifest of entries in the requested format
+func writeManifest(entries []manifestEntry, opts *options) error {
+	if opts.manifest == manifestNone {
+		return nil
+	}
+
+	f, err := os.Create(filepath.Join(opts.outDir, "manifest."+opts.manifest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if opts.manifest == manifestJSON {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "svg", "png", "question", "answer"})
+	for _, entry := range entries {
+		w.Write([]string{entry.ID, entry.SVG, entry.PNG, entry.Question, entry.Answer})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRunWritesFilesAndManifest(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-n", "3", "-out", dir, "-format", "both", "-width", "120", "-height", "40", "-seed", "7"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 manifest entries, got %d", len(entries))
+	}
+
+	for _, entry := range entries {
+		svg, err := os.ReadFile(filepath.Join(dir, entry.SVG))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", entry.SVG, err)
+		}
+		if !bytes.Contains(svg, []byte(`width="120"`)) {
+			t.Errorf("Expected %s to use the width flag", entry.SVG)
+		}
+		png, err := os.ReadFile(filepath.Join(dir, entry.PNG))
+		if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+			t.Errorf("Expected PNG file %s, got error %v", entry.PNG, err)
+		}
+		if _, err := strconv.Atoi(entry.Answer); err != nil || entry.Question == "" {
+			t.Errorf("Expected question and numeric answer, got %+v", entry)
+		}
+	}
+}
+
+func TestSeedIsReproducible(t *testing.T) {
+	generateDataset := func(seed string) ([]byte, []byte) {
+		dir := t.TempDir()
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"-n", "2", "-out", dir, "-seed", seed, "-noise", "4"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("Expected exit 0, got %d: %s", code, stderr.String())
+		}
+		manifest, _ := os.ReadFile(filepath.Join(dir, "manifest.json"))
+		svg, _ := os.ReadFile(filepath.Join(dir, "captcha_1.svg"))
+		return manifest, svg
+	}
+
+	manifest1, svg1 := generateDataset("42")
+	manifest2, svg2 := generateDataset("42")
+	if !bytes.Equal(manifest1, manifest2) || !bytes.Equal(svg1, svg2) {
+		t.Error("Expected the same seed to produce the same dataset")
+	}
+	if _, svg3 := generateDataset("43"); bytes.Equal(svg1, svg3) {
+		t.Error("Expected another seed to produce another dataset")
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	os.WriteFile(configPath, []byte(`{"width": 300, "height": 90, "noise": 6}`), 0o644)
+	t.Setenv("CAPTCHA_HEIGHT", "70")
+	t.Setenv("CAPTCHA_FONT_SIZE", "30")
+
+	config, _, err := parseArgs([]string{"-env", "-config", configPath, "-noise", "2", "-text-colors", "#112233, #445566"}, os.Stderr)
+	if err != nil {
+		t.Fatalf("Failed to parse args: %v", err)
+	}
+	if config.Width != 300 || config.Height != 90 {
+		t.Errorf("Expected the config file over the environment, got %dx%d", config.Width, config.Height)
+	}
+	if config.FontSize != 30 {
+		t.Errorf("Expected the environment over defaults, got font size %d", config.FontSize)
+	}
+	if config.Noise != 2 {
+		t.Errorf("Expected flags over the config file, got noise %d", config.Noise)
+	}
+	if len(config.TextColors) != 2 || config.TextColors[1] != "#445566" {
+		t.Errorf("Expected the text color list, got %v", config.TextColors)
+	}
+
+	t.Setenv("CAPTCHA_THEME", "bogus")
+	if _, _, err := parseArgs([]string{"-env"}, io.Discard); err == nil {
+		t.Error("Expected an unknown CAPTCHA_THEME to be reported")
+	}
+}
+
+func TestCSVManifest(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-n", "2", "-out", dir, "-manifest", "csv", "-prefix", "img"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, stderr.String())
+	}
+
+	f, err := os.Open(filepath.Join(dir, "manifest.csv"))
+	if err != nil {
+		t.Fatalf("Failed to open manifest: %v", err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "id" || records[1][1] != "img_1.svg" {
+		t.Errorf("Unexpected manifest %v", records)
+	}
+}
+
+func TestInvalidArguments(t *testing.T) {
+	for _, args := range [][]string{
+		{"-n", "0"},
+		{"-format", "gif"},
+		{"-manifest", "xml"},
+		{"-width", "-5"},
+		{"-theme", "neon"},
+		{"-unknown"},
+		{"extra"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(append(args, "-out", t.TempDir()), &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit 2 for %v, got %d", args, code)
+		}
+	}
+}
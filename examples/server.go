@@ -1,27 +1,25 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
 )
 
 // Server represents the HTTP server with captcha functionality
 type Server struct {
 	generator *captcha.CaptchaGenerator
-	sessions  map[string]*Session
-}
-
-// Session stores captcha session data
-type Session struct {
-	Answer    string
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	service   *captcha.Service
+	metrics   *captcha.MetricsRegistry
+	sessions  *handler.Sessions
 }
 
 // NewServer creates a new server instance
@@ -38,50 +36,86 @@ func NewServer() *Server {
 		Background:   "#f8f9fa",
 	}
 
+	generator := captcha.NewCaptchaGenerator(config, captcha.WithLogger(slog.Default()))
+	service := captcha.NewService(generator, captcha.NewMemoryStore())
+	metrics := captcha.NewMetricsRegistry()
+	service.SetMetrics(metrics)
+
+	// Sign session cookies with a per-process key; use a persistent secret when running several
+	// instances or to keep sessions across restarts
+	key := make([]byte, 32)
+	rand.Read(key)
+	sessions, err := handler.NewSessions(handler.SessionConfig{
+		Key:         key,
+		Fingerprint: handler.UserAgentFingerprint,
+		Insecure:    true, // The demo serves plain HTTP
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return &Server{
-		generator: captcha.NewCaptchaGenerator(config),
-		sessions:  make(map[string]*Session),
+		generator: generator,
+		service:   service,
+		metrics:   metrics,
+		sessions:  sessions,
 	}
 }
 
 // generateCaptcha handles captcha generation requests
 func (s *Server) generateCaptcha(w http.ResponseWriter, r *http.Request) {
-	// Generate captcha
-	result, err := s.generator.CreateMathExpr()
+	// Match the client's color scheme, from ?theme= or the prefers-color-scheme client hint
+	theme := r.URL.Query().Get("theme")
+	if theme == "" {
+		theme = captcha.ThemeForColorScheme(r.Header.Get("Sec-CH-Prefers-Color-Scheme"))
+	}
+	if _, err := captcha.GetTheme(theme); err != nil {
+		captcha.WriteProblem(w, r, err)
+		return
+	}
+	w.Header().Set("Accept-CH", "Sec-CH-Prefers-Color-Scheme")
+	w.Header().Set("Vary", "Sec-CH-Prefers-Color-Scheme")
+
+	// Generate captcha and store its answer under a random ID, bound to the session so only
+	// Sessions.Verify can check it. A session with a captcha pending is refreshing it: the old
+	// one is invalidated and captchas are rate-limited per session.
+	session := s.sessions.Start(r)
+	challenge, err := s.service.IssueWithOptionsContext(r.Context(), captcha.IssueOptions{
+		Theme:   theme,
+		Replace: session.CaptchaID,
+		Client:  session.ID,
+		Session: session.ID,
+	})
 	if err != nil {
 		log.Printf("Error generating captcha: %v", err)
-		http.Error(w, "Failed to generate captcha", http.StatusInternalServerError)
+		captcha.WriteProblem(w, r, err)
 		return
 	}
+	result := challenge.Result
 
-	// Create session ID (in production, use a proper session management library)
-	sessionID := fmt.Sprintf("session_%d", time.Now().UnixNano())
-
-	// Store session
-	s.sessions[sessionID] = &Session{
-		Answer:    result.Text,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(5 * time.Minute),
-	}
-
-	// Clean up expired sessions
-	s.cleanupSessions()
+	// Bind the captcha to the client's signed session cookie
+	session.CaptchaID = challenge.ID
+	s.sessions.Save(w, r, session)
 
-	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "captcha_session",
-		Value:    sessionID,
-		HttpOnly: true,
-		MaxAge:   300, // 5 minutes
-		Path:     "/",
-	})
-
-	// Return SVG
-	w.Header().Set("Content-Type", "image/svg+xml")
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
 
+	// Return a JSON data URI for single-page apps, e.g. /captcha?encoding=utf8
+	if encoding := r.URL.Query().Get("encoding"); encoding != "" {
+		data, err := result.MarshalJSONWithOptions(captcha.JSONOptions{Encoding: encoding, OmitAnswer: true})
+		if err != nil {
+			http.Error(w, "Unknown encoding", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+
+	// Return SVG
+	w.Header().Set("Content-Type", "image/svg+xml")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(result.Data))
 }
@@ -102,30 +136,23 @@ func (s *Server) validateCaptcha(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get session from cookie
-	cookie, err := r.Cookie("captcha_session")
+	// Validate the answer against the session's captcha, which requires the session's CSRF
+	// token; each captcha allows a single attempt
+	result, err := s.sessions.Verify(w, r, s.service, "", request.Answer)
 	if err != nil {
-		log.Printf("No session cookie found: %v", err)
-		http.Error(w, "No captcha session found", http.StatusBadRequest)
+		log.Printf("Error verifying captcha: %v", err)
+		captcha.WriteProblem(w, r, err)
 		return
 	}
-
-	// Find session
-	session, exists := s.sessions[cookie.Value]
-	if !exists {
+	switch result {
+	case captcha.VerifyNotFound:
 		http.Error(w, "Invalid or expired session", http.StatusBadRequest)
 		return
-	}
-
-	// Check if session is expired
-	if time.Now().After(session.ExpiresAt) {
-		delete(s.sessions, cookie.Value)
+	case captcha.VerifyExpired:
 		http.Error(w, "Captcha expired", http.StatusBadRequest)
 		return
 	}
-
-	// Validate answer
-	isValid := captcha.ValidateAnswer(session.Answer, request.Answer)
+	isValid := result == captcha.VerifySuccess
 
 	response := struct {
 		Valid   bool   `json:"valid"`
@@ -137,17 +164,6 @@ func (s *Server) validateCaptcha(w http.ResponseWriter, r *http.Request) {
 
 	if isValid {
 		response.Message = "Captcha validation successful"
-		// Remove session after successful validation
-		delete(s.sessions, cookie.Value)
-
-		// Clear the session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "captcha_session",
-			Value:    "",
-			HttpOnly: true,
-			MaxAge:   -1,
-			Path:     "/",
-		})
 	} else {
 		response.Message = "Captcha validation failed"
 	}
@@ -164,6 +180,7 @@ func (s *Server) serveDemoPage(w http.ResponseWriter, r *http.Request) {
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="csrf-token" content="{{.CSRFToken}}">
     <title>SVG Math Captcha Demo</title>
     <style>
         body {
@@ -344,7 +361,8 @@ func (s *Server) serveDemoPage(w http.ResponseWriter, r *http.Request) {
                 const response = await fetch('/validate', {
                     method: 'POST',
                     headers: {
-                        'Content-Type': 'application/json'
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': document.querySelector('meta[name="csrf-token"]').content
                     },
                     body: JSON.stringify({ answer: answer })
                 });
@@ -361,7 +379,11 @@ func (s *Server) serveDemoPage(w http.ResponseWriter, r *http.Request) {
                         refreshCaptcha();
                     }, 2000);
                 } else {
+                    // Each captcha allows a single attempt, so show a new one
                     resultDiv.innerHTML = '<div class="result error">❌ ' + data.message + '</div>';
+                    setTimeout(() => {
+                        refreshCaptcha();
+                    }, 2000);
                 }
             } catch (error) {
                 console.error('Error:', error);
@@ -391,8 +413,12 @@ func (s *Server) serveDemoPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Start the session the page's captchas are bound to and hand its CSRF token to the page
+	session := s.sessions.Start(r)
+	s.sessions.Save(w, r, session)
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	t.Execute(w, nil)
+	t.Execute(w, struct{ CSRFToken string }{s.sessions.CSRFToken(session)})
 }
 
 // apiStatus returns server status information
@@ -405,7 +431,7 @@ func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
 	}{
 		Status:         "ok",
 		Version:        "1.0.0",
-		ActiveSessions: len(s.sessions),
+		ActiveSessions: s.service.Store().Len(),
 		Config:         s.generator.GetConfig(),
 	}
 
@@ -413,40 +439,26 @@ func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-// cleanupSessions removes expired sessions
-func (s *Server) cleanupSessions() {
-	now := time.Now()
-	for sessionID, session := range s.sessions {
-		if now.After(session.ExpiresAt) {
-			delete(s.sessions, sessionID)
-		}
-	}
-}
-
-// CORS middleware for API endpoints
-func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	}
-}
-
 func main() {
 	server := NewServer()
+	port := ":8080"
+
+	// The flow depends on the captcha_session cookie, so cross-origin callers must be listed
+	// explicitly; browsers do not send cookies to a "*" origin
+	cors, err := handler.NewCORS(handler.CORSConfig{
+		AllowedOrigins:   []string{"http://localhost" + port},
+		AllowCredentials: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Routes
 	http.HandleFunc("/", server.serveDemoPage)
-	http.HandleFunc("/captcha", corsMiddleware(server.generateCaptcha))
-	http.HandleFunc("/validate", corsMiddleware(server.validateCaptcha))
-	http.HandleFunc("/status", corsMiddleware(server.apiStatus))
+	http.Handle("/captcha", cors.Handler(http.HandlerFunc(server.generateCaptcha)))
+	http.Handle("/validate", cors.Handler(http.HandlerFunc(server.validateCaptcha)))
+	http.Handle("/status", cors.Handler(http.HandlerFunc(server.apiStatus)))
+	http.Handle("/metrics", server.metrics)
 
 	// Start cleanup routine
 	go func() {
@@ -454,15 +466,15 @@ func main() {
 		defer ticker.Stop()
 
 		for range ticker.C {
-			server.cleanupSessions()
+			server.service.Cleanup()
 		}
 	}()
 
-	port := ":8080"
 	fmt.Printf("🚀 SVG Math Captcha Server starting on http://localhost%s\n", port)
 	fmt.Printf("📱 Visit http://localhost%s for the demo\n", port)
 	fmt.Printf("🔍 API Status: http://localhost%s/status\n", port)
 	fmt.Printf("📊 Captcha API: http://localhost%s/captcha\n", port)
+	fmt.Printf("📈 Metrics: http://localhost%s/metrics\n", port)
 	fmt.Printf("✅ Validate API: http://localhost%s/validate\n", port)
 
 	log.Fatal(http.ListenAndServe(port, nil))
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"svg-math-captcha/captcha"
+)
+
+// recordingStore is a MemoryStore remembering issued answers, standing in for the user
+type recordingStore struct {
+	*captcha.MemoryStore
+	mutex   sync.Mutex
+	answers map[string]string
+}
+
+func (rs *recordingStore) Set(id string, entry captcha.StoreEntry) error {
+	rs.mutex.Lock()
+	rs.answers[id] = entry.Answer
+	rs.mutex.Unlock()
+	return rs.MemoryStore.Set(id, entry)
+}
+
+func (rs *recordingStore) answer(id string) string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.answers[id]
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	server := NewServer()
+	store := &recordingStore{MemoryStore: captcha.NewMemoryStore(), answers: make(map[string]string)}
+	server.service = captcha.NewService(server.generator, store)
+
+	// issue fetches a captcha in the session of cookie, or a new session if nil
+	issue := func(cookie *http.Cookie) *http.Cookie {
+		request := httptest.NewRequest("GET", "/captcha", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		server.generateCaptcha(recorder, request)
+		if recorder.Code != http.StatusOK || len(recorder.Result().Cookies()) != 1 {
+			t.Fatalf("Expected a captcha and a session cookie, got %d %v", recorder.Code, recorder.Result().Cookies())
+		}
+		return recorder.Result().Cookies()[0]
+	}
+	// validate posts answer with the session cookie and its CSRF token
+	validate := func(cookie *http.Cookie, answer string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/validate", strings.NewReader(`{"answer":"`+answer+`"}`))
+		request.AddCookie(cookie)
+		session, err := server.sessions.Get(request)
+		if err != nil {
+			t.Fatalf("Expected a valid session cookie: %v", err)
+		}
+		request.Header.Set("X-CSRF-Token", server.sessions.CSRFToken(session))
+		recorder := httptest.NewRecorder()
+		server.validateCaptcha(recorder, request)
+		return recorder
+	}
+	// answer returns the answer of the captcha pending in the session of cookie
+	answer := func(cookie *http.Cookie) string {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(cookie)
+		session, err := server.sessions.Get(request)
+		if err != nil {
+			t.Fatalf("Expected a valid session cookie: %v", err)
+		}
+		return store.answer(session.CaptchaID)
+	}
+
+	cookie := issue(nil)
+	if recorder := validate(cookie, answer(cookie)); !strings.Contains(recorder.Body.String(), `"valid":true`) {
+		t.Errorf("Expected the right answer to validate, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// A refreshed captcha replaces the pending one in the same session
+	cookie = issue(issue(nil))
+	if recorder := validate(cookie, answer(cookie)); !strings.Contains(recorder.Body.String(), `"valid":true`) {
+		t.Errorf("Expected the refreshed captcha to validate, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	cookie = issue(nil)
+	if recorder := validate(cookie, answer(cookie)+"0"); !strings.Contains(recorder.Body.String(), `"valid":false`) {
+		t.Errorf("Expected a wrong answer to fail, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,35 @@
+// Package chicaptcha mounts the captcha API and guard on a chi router
+package chicaptcha
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// Mount registers the routes of h on r, typically a sub-router:
+//
+//	r.Route("/captcha", func(r chi.Router) { chicaptcha.Mount(r, h) })
+func Mount(r chi.Router, h *handler.Handler) {
+	for _, route := range h.Routes() {
+		r.Method(route.Method, route.Path, route.Handler)
+	}
+}
+
+// Require returns middleware admitting only requests with a correctly answered captcha:
+//
+//	r.With(chicaptcha.Require(service)).Post("/signup", signup)
+func Require(service *captcha.Service) func(http.Handler) http.Handler {
+	return handler.RequireCaptcha(service)
+}
+
+// RequireSession is Require for captchas issued by a handler with sessions, see
+// handler.Sessions.Require:
+//
+//	r.With(chicaptcha.RequireSession(sessions, service)).Post("/signup", signup)
+func RequireSession(sessions *handler.Sessions, service *captcha.Service) func(http.Handler) http.Handler {
+	return sessions.Require(service)
+}
@@ -0,0 +1,74 @@
+package chicaptcha
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/frameworks/internal/frameworktest"
+	"svg-math-captcha/handler"
+)
+
+func TestMountAndRequire(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	r := chi.NewRouter()
+	r.Route("/captcha", func(r chi.Router) { Mount(r, handler.New(service)) })
+	r.With(Require(service)).Post("/signup", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	})
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/captcha/issue", nil))
+	var issued handler.IssueResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&issued); err != nil || recorder.Code != http.StatusOK || issued.ID == "" {
+		t.Fatalf("Expected a captcha from the mounted issue route, got %d %v", recorder.Code, err)
+	}
+
+	request := httptest.NewRequest("POST", "/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), string(captcha.VerifyFailure)) {
+		t.Errorf("Expected the mounted verify route to reject a wrong answer, got %s", recorder.Body.String())
+	}
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = httptest.NewRequest("POST", "/signup", nil)
+	request.Header.Set(handler.HeaderCaptchaID, challenge.ID)
+	request.Header.Set(handler.HeaderCaptchaAnswer, challenge.Result.Text)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "welcome" {
+		t.Errorf("Expected a solved captcha to pass, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("POST", "/signup", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a missing captcha to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	sessions := frameworktest.NewSessions(t)
+	r := chi.NewRouter()
+	welcome := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("welcome")) }
+	r.With(Require(service)).Post("/plain", welcome)
+	r.With(RequireSession(sessions, service)).Post("/signup", welcome)
+	serve := func(request *http.Request) (int, string) {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	frameworktest.CheckRequireSession(t, sessions, service, serve)
+}
@@ -0,0 +1,10 @@
+// Package frameworks holds adapters that mount the captcha API of the handler package and its
+// require-captcha guard on popular web frameworks, one package per framework:
+//
+//	chicaptcha    github.com/go-chi/chi/v5
+//	gincaptcha    github.com/gin-gonic/gin
+//	echocaptcha   github.com/labstack/echo/v4
+//	fibercaptcha  github.com/gofiber/fiber/v2
+//
+// They live in their own module so the captcha library stays free of dependencies.
+package frameworks
@@ -0,0 +1,48 @@
+// Package echocaptcha mounts the captcha API and guard on an echo instance or group
+package echocaptcha
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// Router is implemented by *echo.Echo and *echo.Group
+type Router interface {
+	Add(method, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Mount registers the routes of h on r, typically a group:
+//
+//	echocaptcha.Mount(e.Group("/captcha"), h)
+func Mount(r Router, h *handler.Handler) {
+	for _, route := range h.Routes() {
+		r.Add(route.Method, route.Path, echo.WrapHandler(route.Handler))
+	}
+}
+
+// Require returns middleware admitting only requests with a correctly answered captcha. Other
+// requests are answered with a 403 problem response.
+//
+//	e.POST("/signup", signup, echocaptcha.Require(service))
+func Require(service *captcha.Service) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, answer := handler.Credentials(c.Request())
+			if err := handler.Check(c.Request().Context(), service, id, answer); err != nil {
+				captcha.WriteProblem(c.Response(), c.Request(), err)
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireSession is Require for captchas issued by a handler with sessions: the request must
+// also carry the session cookie and CSRF token, see handler.Sessions.Require.
+//
+//	e.POST("/signup", signup, echocaptcha.RequireSession(sessions, service))
+func RequireSession(sessions *handler.Sessions, service *captcha.Service) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(sessions.Require(service))
+}
@@ -0,0 +1,74 @@
+package echocaptcha
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/frameworks/internal/frameworktest"
+	"svg-math-captcha/handler"
+)
+
+func TestMountAndRequire(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	e := echo.New()
+	Mount(e.Group("/captcha"), handler.New(service))
+	e.POST("/signup", func(c echo.Context) error {
+		return c.String(http.StatusOK, "welcome")
+	}, Require(service))
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("GET", "/captcha/issue", nil))
+	var issued handler.IssueResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&issued); err != nil || recorder.Code != http.StatusOK || issued.ID == "" {
+		t.Fatalf("Expected a captcha from the mounted issue route, got %d %v", recorder.Code, err)
+	}
+
+	request := httptest.NewRequest("POST", "/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), string(captcha.VerifyFailure)) {
+		t.Errorf("Expected the mounted verify route to reject a wrong answer, got %s", recorder.Body.String())
+	}
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = httptest.NewRequest("POST", "/signup", nil)
+	request.Header.Set(handler.HeaderCaptchaID, challenge.ID)
+	request.Header.Set(handler.HeaderCaptchaAnswer, challenge.Result.Text)
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "welcome" {
+		t.Errorf("Expected a solved captcha to pass, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("POST", "/signup?captcha_id="+challenge.ID+"&captcha_answer=-1", nil))
+	if recorder.Code != http.StatusForbidden || recorder.Header().Get("Content-Type") != captcha.ProblemContentType {
+		t.Errorf("Expected a wrong answer to be rejected with a problem, got %d", recorder.Code)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	sessions := frameworktest.NewSessions(t)
+	e := echo.New()
+	welcome := func(c echo.Context) error { return c.String(http.StatusOK, "welcome") }
+	e.POST("/plain", welcome, Require(service))
+	e.POST("/signup", welcome, RequireSession(sessions, service))
+	serve := func(request *http.Request) (int, string) {
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	frameworktest.CheckRequireSession(t, sessions, service, serve)
+}
@@ -0,0 +1,57 @@
+// Package fibercaptcha mounts the captcha API and guard on a fiber app or group
+package fibercaptcha
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// Mount registers the routes of h on r, typically a group. Fiber is not built on net/http, so
+// the routes are converted with fiber's adaptor.
+//
+//	fibercaptcha.Mount(app.Group("/captcha"), h)
+func Mount(r fiber.Router, h *handler.Handler) {
+	for _, route := range h.Routes() {
+		r.Add(route.Method, route.Path, adaptor.HTTPHandlerFunc(route.Handler))
+	}
+}
+
+// Require returns middleware admitting only requests with a correctly answered captcha, read
+// like handler.Credentials from headers, form fields or the query. Other requests are answered
+// with a 403 problem response.
+//
+//	app.Post("/signup", fibercaptcha.Require(service), signup)
+func Require(service *captcha.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, answer := credentials(c)
+		if err := handler.Check(c.UserContext(), service, id, answer); err != nil {
+			problem := captcha.NewProblem(err)
+			problem.Instance = c.Path()
+			return c.Status(problem.Status).JSON(problem, captcha.ProblemContentType)
+		}
+		return c.Next()
+	}
+}
+
+// RequireSession is Require for captchas issued by a handler with sessions: the request must
+// also carry the session cookie and CSRF token, see handler.Sessions.Require. Credentials are
+// read like handler.Credentials, from headers or form fields.
+//
+//	app.Post("/signup", fibercaptcha.RequireSession(sessions, service), signup)
+func RequireSession(sessions *handler.Sessions, service *captcha.Service) fiber.Handler {
+	return adaptor.HTTPMiddleware(sessions.Require(service))
+}
+
+// credentials returns the captcha ID and answer of c, looked up like handler.Credentials
+func credentials(c *fiber.Ctx) (id, answer string) {
+	if id = c.Get(handler.HeaderCaptchaID); id != "" {
+		return id, c.Get(handler.HeaderCaptchaAnswer)
+	}
+	if id = c.FormValue(handler.FieldCaptchaID); id != "" {
+		return id, c.FormValue(handler.FieldCaptchaAnswer)
+	}
+	return c.Query(handler.FieldCaptchaID), c.Query(handler.FieldCaptchaAnswer)
+}
@@ -0,0 +1,113 @@
+package fibercaptcha
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/frameworks/internal/frameworktest"
+	"svg-math-captcha/handler"
+)
+
+func TestMountAndRequire(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	app := fiber.New()
+	Mount(app.Group("/captcha"), handler.New(service))
+	app.Post("/signup", Require(service), func(c *fiber.Ctx) error {
+		return c.SendString("welcome " + c.FormValue("name"))
+	})
+
+	response, err := app.Test(httptest.NewRequest("GET", "/captcha/issue", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issued handler.IssueResponse
+	if err := json.NewDecoder(response.Body).Decode(&issued); err != nil || response.StatusCode != http.StatusOK || issued.ID == "" {
+		t.Fatalf("Expected a captcha from the mounted issue route, got %d %v", response.StatusCode, err)
+	}
+
+	request := httptest.NewRequest("POST", "/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	if body := testBody(t, app, request); !strings.Contains(body, string(captcha.VerifyFailure)) {
+		t.Errorf("Expected the mounted verify route to reject a wrong answer, got %s", body)
+	}
+
+	// Form fields
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{handler.FieldCaptchaID: {challenge.ID}, handler.FieldCaptchaAnswer: {challenge.Result.Text}, "name": {"ada"}}
+	request = httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if body := testBody(t, app, request); body != "welcome ada" {
+		t.Errorf("Expected a solved captcha to pass, got %s", body)
+	}
+
+	// Headers
+	challenge, err = service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = httptest.NewRequest("POST", "/signup", nil)
+	request.Header.Set(handler.HeaderCaptchaID, challenge.ID)
+	request.Header.Set(handler.HeaderCaptchaAnswer, challenge.Result.Text)
+	if body := testBody(t, app, request); body != "welcome " {
+		t.Errorf("Expected a solved captcha to pass, got %s", body)
+	}
+
+	// Query, answered wrong
+	challenge, err = service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = app.Test(httptest.NewRequest("POST", "/signup?captcha_id="+challenge.ID+"&captcha_answer=-1", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problem captcha.Problem
+	if err := json.NewDecoder(response.Body).Decode(&problem); err != nil || response.StatusCode != http.StatusForbidden ||
+		response.Header.Get("Content-Type") != captcha.ProblemContentType || problem.Instance != "/signup" {
+		t.Errorf("Expected a wrong answer to be rejected with a problem, got %d %+v", response.StatusCode, problem)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	service := captcha.NewService(nil, nil)
+	sessions := frameworktest.NewSessions(t)
+	app := fiber.New()
+	welcome := func(c *fiber.Ctx) error { return c.SendString("welcome") }
+	app.Post("/plain", Require(service), welcome)
+	app.Post("/signup", RequireSession(sessions, service), welcome)
+	serve := func(request *http.Request) (int, string) {
+		response, err := app.Test(request, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	frameworktest.CheckRequireSession(t, sessions, service, serve)
+}
+
+// testBody sends request to app and returns the response body
+func testBody(t *testing.T, app *fiber.App, request *http.Request) string {
+	t.Helper()
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
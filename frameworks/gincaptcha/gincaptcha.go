@@ -0,0 +1,54 @@
+// Package gincaptcha mounts the captcha API and guard on a gin engine or group
+package gincaptcha
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// Mount registers the routes of h on r, typically a group:
+//
+//	gincaptcha.Mount(engine.Group("/captcha"), h)
+func Mount(r gin.IRoutes, h *handler.Handler) {
+	for _, route := range h.Routes() {
+		r.Handle(route.Method, route.Path, gin.WrapF(route.Handler))
+	}
+}
+
+// Require returns middleware admitting only requests with a correctly answered captcha. Other
+// requests are aborted with a 403 problem response.
+//
+//	engine.POST("/signup", gincaptcha.Require(service), signup)
+func Require(service *captcha.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, answer := handler.Credentials(c.Request)
+		if err := handler.Check(c.Request.Context(), service, id, answer); err != nil {
+			captcha.WriteProblem(c.Writer, c.Request, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession is Require for captchas issued by a handler with sessions: the request must
+// also carry the session cookie and CSRF token, see handler.Sessions.Require.
+//
+//	engine.POST("/signup", gincaptcha.RequireSession(sessions, service), signup)
+func RequireSession(sessions *handler.Sessions, service *captcha.Service) gin.HandlerFunc {
+	require := sessions.Require(service)
+	return func(c *gin.Context) {
+		passed := false
+		require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			passed = true
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+		if !passed {
+			c.Abort()
+		}
+	}
+}
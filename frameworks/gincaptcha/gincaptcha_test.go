@@ -0,0 +1,77 @@
+package gincaptcha
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/frameworks/internal/frameworktest"
+	"svg-math-captcha/handler"
+)
+
+func TestMountAndRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := captcha.NewService(nil, nil)
+	engine := gin.New()
+	Mount(engine.Group("/captcha"), handler.New(service))
+	engine.POST("/signup", Require(service), func(c *gin.Context) {
+		c.String(http.StatusOK, "welcome "+c.PostForm("name"))
+	})
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest("GET", "/captcha/issue", nil))
+	var issued handler.IssueResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&issued); err != nil || recorder.Code != http.StatusOK || issued.ID == "" {
+		t.Fatalf("Expected a captcha from the mounted issue route, got %d %v", recorder.Code, err)
+	}
+
+	request := httptest.NewRequest("POST", "/captcha/verify", strings.NewReader(`{"id":"`+issued.ID+`","answer":"-1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), string(captcha.VerifyFailure)) {
+		t.Errorf("Expected the mounted verify route to reject a wrong answer, got %s", recorder.Body.String())
+	}
+
+	challenge, err := service.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{handler.FieldCaptchaID: {challenge.ID}, handler.FieldCaptchaAnswer: {challenge.Result.Text}, "name": {"ada"}}
+	request = httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "welcome ada" {
+		t.Errorf("Expected a solved captcha to pass, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || recorder.Header().Get("Content-Type") != captcha.ProblemContentType {
+		t.Errorf("Expected a reused captcha to be rejected with a problem, got %d", recorder.Code)
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := captcha.NewService(nil, nil)
+	sessions := frameworktest.NewSessions(t)
+	engine := gin.New()
+	welcome := func(c *gin.Context) { c.String(http.StatusOK, "welcome") }
+	engine.POST("/plain", Require(service), welcome)
+	engine.POST("/signup", RequireSession(sessions, service), welcome)
+	serve := func(request *http.Request) (int, string) {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	frameworktest.CheckRequireSession(t, sessions, service, serve)
+}
@@ -0,0 +1,71 @@
+// Package frameworktest holds the fixtures shared by the tests of the framework adapters
+package frameworktest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/handler"
+)
+
+// NewSessions returns a session transport with a fixed test key
+func NewSessions(t *testing.T) *handler.Sessions {
+	t.Helper()
+	sessions, err := handler.NewSessions(handler.SessionConfig{Key: []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+// SessionCaptcha issues a captcha to a new session and returns it with the session cookie and
+// CSRF token
+func SessionCaptcha(t *testing.T, sessions *handler.Sessions, service *captcha.Service) (*captcha.Challenge, *http.Cookie, string) {
+	t.Helper()
+	session := &handler.Session{ID: "session", CreatedAt: time.Now()}
+	challenge, err := service.IssueWithOptionsContext(t.Context(), captcha.IssueOptions{Session: session.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.CaptchaID = challenge.ID
+	recorder := httptest.NewRecorder()
+	sessions.Save(recorder, httptest.NewRequest("GET", "/", nil), session)
+	return challenge, recorder.Result().Cookies()[0], sessions.CSRFToken(session)
+}
+
+// SessionRequest posts the answer of challenge to path with the session cookie and token
+func SessionRequest(path string, challenge *captcha.Challenge, cookie *http.Cookie, token string) *http.Request {
+	request := httptest.NewRequest("POST", path, nil)
+	request.Header.Set(handler.HeaderCaptchaID, challenge.ID)
+	request.Header.Set(handler.HeaderCaptchaAnswer, challenge.Result.Text)
+	if token != "" {
+		request.Header.Set(handler.HeaderCSRFToken, token)
+	}
+	request.AddCookie(cookie)
+	return request
+}
+
+// CheckRequireSession tests the guards of an adapter through serve, which answers requests
+// with the status and body of a router guarding "/plain" with Require and "/signup" with
+// RequireSession, both answering "welcome"
+func CheckRequireSession(t *testing.T, sessions *handler.Sessions, service *captcha.Service, serve func(*http.Request) (int, string)) {
+	t.Helper()
+
+	// The plain guard rejects session captchas, even with the cookie and token
+	challenge, cookie, token := SessionCaptcha(t, sessions, service)
+	if code, _ := serve(SessionRequest("/plain", challenge, cookie, token)); code != http.StatusForbidden {
+		t.Errorf("Expected Require to reject a session captcha, got %d", code)
+	}
+
+	challenge, cookie, token = SessionCaptcha(t, sessions, service)
+	if code, _ := serve(SessionRequest("/signup", challenge, cookie, "")); code != http.StatusForbidden {
+		t.Errorf("Expected a request without the CSRF token to be rejected, got %d", code)
+	}
+	if code, body := serve(SessionRequest("/signup", challenge, cookie, token)); body != "welcome" {
+		t.Errorf("Expected a solved session captcha to pass, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// DefaultCORSMaxAge is how long browsers may cache a preflight response if CORSConfig.MaxAge is 0
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig controls which web origins may call the API from a browser
+type CORSConfig struct {
+	// Origins allowed to call the API, such as "https://example.com". "https://*.example.com"
+	// allows every subdomain and "*" any origin (default: none)
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// Let browsers send cookies, such as a captcha session, with cross-origin requests. Cannot
+	// be combined with the "*" origin (default: false)
+	AllowCredentials bool `json:"allowCredentials"`
+	// Methods allowed in cross-origin requests (default: GET, POST)
+	AllowedMethods []string `json:"allowedMethods"`
+	// Request headers allowed in cross-origin requests (default: Content-Type and the captcha
+	// and CSRF headers)
+	AllowedHeaders []string `json:"allowedHeaders"`
+	// Response headers readable by cross-origin scripts (default: none)
+	ExposedHeaders []string `json:"exposedHeaders"`
+	// How long browsers may cache a preflight response, 0 uses DefaultCORSMaxAge and a negative
+	// value disables caching (default: 0)
+	MaxAge time.Duration `json:"maxAge"`
+}
+
+// Validate checks the configuration for malformed origins and for credentials allowed to any origin
+func (c *CORSConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return captcha.NewError(captcha.ErrInvalidConfig, `allowed origin "*" cannot be combined with credentials`, 400)
+			}
+			continue
+		}
+		if !validOrigin(strings.Replace(origin, "://*.", "://", 1)) {
+			return captcha.NewError(captcha.ErrInvalidConfig, "invalid allowed origin: "+origin, 400)
+		}
+	}
+	return nil
+}
+
+// validOrigin reports whether origin is a scheme and host with an optional port, nothing else
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.User == nil && u.Path == "" &&
+		u.RawQuery == "" && u.Fragment == "" && !strings.Contains(u.Host, "*")
+}
+
+// CORS answers preflight requests and adds CORS headers to the responses of allowed origins
+type CORS struct {
+	config         CORSConfig
+	anyOrigin      bool
+	origins        map[string]bool
+	subdomains     [][2]string // Scheme prefix and host suffix of "scheme://*.host" origins
+	methods        []string
+	allowedMethods string
+	allowedHeaders string
+	headers        map[string]bool
+	exposedHeaders string
+	maxAge         string
+}
+
+// NewCORS creates CORS middleware from config
+func NewCORS(config CORSConfig) (*CORS, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &CORS{config: config, origins: make(map[string]bool), headers: make(map[string]bool)}
+	for _, origin := range config.AllowedOrigins {
+		switch scheme, host, ok := strings.Cut(origin, "://*."); {
+		case origin == "*":
+			c.anyOrigin = true
+		case ok:
+			c.subdomains = append(c.subdomains, [2]string{strings.ToLower(scheme) + "://", "." + strings.ToLower(host)})
+		default:
+			c.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	c.methods = config.AllowedMethods
+	if len(c.methods) == 0 {
+		c.methods = []string{http.MethodGet, http.MethodPost}
+	}
+	c.allowedMethods = strings.Join(c.methods, ", ")
+
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", HeaderCaptchaID, HeaderCaptchaAnswer, HeaderCSRFToken}
+	}
+	for _, header := range headers {
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	c.allowedHeaders = strings.Join(headers, ", ")
+	c.exposedHeaders = strings.Join(config.ExposedHeaders, ", ")
+
+	switch {
+	case config.MaxAge == 0:
+		c.maxAge = strconv.Itoa(int(DefaultCORSMaxAge.Seconds()))
+	case config.MaxAge > 0:
+		c.maxAge = strconv.Itoa(int(config.MaxAge.Seconds()))
+	}
+	return c, nil
+}
+
+// AllowOrigin reports whether origin may call the API
+func (c *CORS) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for _, subdomain := range c.subdomains {
+		if strings.HasPrefix(origin, subdomain[0]) && strings.HasSuffix(origin, subdomain[1]) &&
+			len(origin) > len(subdomain[0])+len(subdomain[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns next wrapped with CORS handling. Preflight requests are answered with 204 and
+// never reach next; requests from origins that are not allowed pass without CORS headers, so
+// browsers keep the response from their scripts.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if preflight {
+			header.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+		} else {
+			header.Add("Vary", "Origin")
+		}
+		if !c.AllowOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			if c.allowPreflight(r) {
+				c.setOrigin(header, origin)
+				header.Set("Access-Control-Allow-Methods", c.allowedMethods)
+				header.Set("Access-Control-Allow-Headers", c.allowedHeaders)
+				if c.maxAge != "" {
+					header.Set("Access-Control-Max-Age", c.maxAge)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		c.setOrigin(header, origin)
+		if c.exposedHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", c.exposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowPreflight reports whether the method and headers requested by a preflight are allowed
+func (c *CORS) allowPreflight(r *http.Request) bool {
+	if !slices.Contains(c.methods, r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" && !c.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin allows origin to read the response
+func (c *CORS) setOrigin(header http.Header, origin string) {
+	if c.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if c.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"svg-math-captcha/captcha"
+)
+
+// Names under which protected requests carry the captcha ID and answer: headers for API
+// clients, form fields for HTML forms
+const (
+	HeaderCaptchaID     = "X-Captcha-Id"
+	HeaderCaptchaAnswer = "X-Captcha-Answer"
+	FieldCaptchaID      = "captcha_id"
+	FieldCaptchaAnswer  = "captcha_answer"
+)
+
+// Credentials returns the captcha ID and answer of r from its headers, or else its form fields
+func Credentials(r *http.Request) (id, answer string) {
+	id, answer = r.Header.Get(HeaderCaptchaID), r.Header.Get(HeaderCaptchaAnswer)
+	if id == "" && answer == "" {
+		id, answer = r.FormValue(FieldCaptchaID), r.FormValue(FieldCaptchaAnswer)
+	}
+	return id, answer
+}
+
+// Check verifies answer against the captcha issued under id. It returns nil on success and an
+// ErrVerificationFailed error, answered with 403, if the captcha is missing, wrong, expired or
+// unknown. Captchas issued to a session count as unknown: Sessions.Verify checks those.
+func Check(ctx context.Context, service *captcha.Service, id, answer string) error {
+	if id == "" {
+		return captcha.NewError(captcha.ErrVerificationFailed, "captcha required", http.StatusForbidden)
+	}
+
+	result, err := service.VerifyContext(ctx, id, answer)
+	if err != nil {
+		return err
+	}
+	if result != captcha.VerifySuccess {
+		return verificationError(result)
+	}
+	return nil
+}
+
+// verificationError returns the 403 error of a captcha that was not solved
+func verificationError(result captcha.VerifyResult) error {
+	return captcha.NewError(captcha.ErrVerificationFailed, "captcha verification failed: "+string(result), http.StatusForbidden)
+}
+
+// RequireCaptcha returns middleware that passes a request on only if it carries a correctly
+// answered captcha, see Credentials. Each captcha admits one request. Captchas issued by a
+// Handler with sessions are rejected; guard their requests with Sessions.Require.
+func RequireCaptcha(service *captcha.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, answer := Credentials(r)
+			if err := Check(r.Context(), service, id, answer); err != nil {
+				captcha.WriteProblem(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
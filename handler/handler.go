@@ -0,0 +1,417 @@
+// Package handler serves the captcha JSON API over HTTP on top of a captcha.Service.
+//
+// Mount it under a prefix with http.StripPrefix:
+//
+//	h := handler.New(service)
+//	mux.Handle("/api/captcha/", http.StripPrefix("/api/captcha", h))
+//
+// Routes, relative to the prefix:
+//
+//	GET or POST /issue   issue a captcha: {"id", "image", "encoding", "expiresAt"}
+//	POST        /verify  verify {"id", "answer"}: {"success", "result"}
+//	POST        /refresh replace {"id"} with a new captcha, answered like /issue
+//
+// Errors are answered with RFC 9457 application/problem+json.
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// maxRequestBytes limits the size of request bodies
+const maxRequestBytes = 4 << 10
+
+// IssueResponse is the JSON answer of the issue route. The question and answer are never sent.
+type IssueResponse struct {
+	ID        string    `json:"id"`
+	Image     string    `json:"image"`    // Image in Encoding, a data URI unless the encoding is "svg"
+	Encoding  string    `json:"encoding"` // One of the captcha.Encoding constants
+	ExpiresAt time.Time `json:"expiresAt"`
+	CSRFToken string    `json:"csrfToken,omitempty"` // Token to send with verification, only with sessions
+}
+
+// VerifyRequest is the JSON body of the verify route
+type VerifyRequest struct {
+	ID     string `json:"id"`
+	Answer string `json:"answer"`
+}
+
+// RefreshRequest is the JSON body of the refresh route. With sessions the ID may be left out.
+type RefreshRequest struct {
+	ID string `json:"id"`
+}
+
+// VerifyResponse is the JSON answer of the verify route
+type VerifyResponse struct {
+	Success bool                 `json:"success"`
+	Result  captcha.VerifyResult `json:"result"`
+}
+
+// Handler serves the captcha JSON API
+type Handler struct {
+	service  *captcha.Service
+	logger   *slog.Logger
+	encoding string
+	pngScale float64
+	sessions *Sessions
+	issues   *captcha.RateLimiter
+	proxies  []netip.Prefix
+	mux      *http.ServeMux
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithLogger logs failed requests to logger; nil discards them (default)
+func WithLogger(logger *slog.Logger) Option {
+	return func(h *Handler) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		h.logger = logger
+	}
+}
+
+// WithEncoding sets the default image encoding, which clients can override with the encoding
+// query parameter (default: captcha.EncodingBase64)
+func WithEncoding(encoding string) Option {
+	return func(h *Handler) {
+		h.encoding = encoding
+	}
+}
+
+// WithPNGScale sets the raster scale of the "png" encoding (default: 1)
+func WithPNGScale(scale float64) Option {
+	return func(h *Handler) {
+		h.pngScale = scale
+	}
+}
+
+// WithSessions binds issued captchas to signed session cookies: verification then needs the
+// session cookie and its CSRF token, which the issue response carries (default: none)
+func WithSessions(sessions *Sessions) Option {
+	return func(h *Handler) {
+		h.sessions = sessions
+	}
+}
+
+// WithIssueLimiter caps the captchas issued per client address with limiter, like the refresh
+// limit caps refreshes, so clients cannot collect captchas by not keeping a session. Issues that
+// refresh the pending captcha of a session count against the refresh limit instead. Users
+// sharing an address through NAT share its limit; call the limiter's Cleanup periodically to
+// forget ended windows (default: none, unlimited)
+func WithIssueLimiter(limiter *captcha.RateLimiter) Option {
+	return func(h *Handler) {
+		h.issues = limiter
+	}
+}
+
+// WithTrustedProxies takes the client address of requests from the given networks, such as a
+// reverse proxy, from the X-Forwarded-For header: it is the last address in the header that is
+// not in these networks (default: none, the address of the connection)
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(h *Handler) {
+		h.proxies = proxies
+	}
+}
+
+// New creates a handler issuing and verifying captchas with service
+func New(service *captcha.Service, opts ...Option) *Handler {
+	h := &Handler{
+		service:  service,
+		logger:   slog.New(slog.DiscardHandler),
+		encoding: captcha.EncodingBase64,
+		pngScale: 1,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux = http.NewServeMux()
+	for _, route := range h.Routes() {
+		h.mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	}
+	return h
+}
+
+// Route is one endpoint of the API, for mounting it on routers other than http.ServeMux
+type Route struct {
+	Method  string
+	Path    string // Relative to the mount prefix, starting with "/"
+	Handler http.HandlerFunc
+}
+
+// Routes returns the API's endpoints
+func (h *Handler) Routes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: "/issue", Handler: h.Issue},
+		{Method: http.MethodPost, Path: "/issue", Handler: h.Issue},
+		{Method: http.MethodPost, Path: "/verify", Handler: h.Verify},
+		{Method: http.MethodPost, Path: "/refresh", Handler: h.Refresh},
+	}
+}
+
+// Service returns the service behind the handler
+func (h *Handler) Service() *captcha.Service {
+	return h.service
+}
+
+// ServeHTTP routes a request to Issue or Verify
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Issue issues a captcha. The optional theme and encoding query parameters select a theme and
+// the image encoding. With sessions, a session that still has a captcha pending gets it
+// refreshed, so issuing cannot bypass the refresh limit. Other issues count against the issue
+// limit of the client address if WithIssueLimiter is set; excess requests get 429.
+func (h *Handler) Issue(w http.ResponseWriter, r *http.Request) {
+	encoding, err := h.requestEncoding(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	opts := captcha.IssueOptions{Theme: r.URL.Query().Get("theme")}
+	var session *Session
+	if h.sessions != nil {
+		session = h.sessions.Start(r)
+		opts.Session = session.ID
+		if session.CaptchaID != "" {
+			opts.Replace, opts.Client = session.CaptchaID, session.ID
+		}
+	}
+	if opts.Client == "" && h.issues != nil {
+		if ok, retryAfter := h.issues.Allow(h.clientAddress(r)); !ok {
+			err := captcha.NewError(captcha.ErrRateLimited, "too many captchas issued", http.StatusTooManyRequests)
+			err.RetryAfter = retryAfter
+			h.writeError(w, r, err)
+			return
+		}
+	}
+
+	challenge, err := h.service.IssueWithOptionsContext(r.Context(), opts)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeChallenge(w, r, challenge, encoding, session)
+}
+
+// Refresh replaces the captcha of a RefreshRequest with a new one, answered like Issue. With
+// sessions the captcha is the session's, the request must carry the session's CSRF token and the
+// new captcha is bound to the same session. Refreshes are capped per session, or per client
+// address without sessions, see captcha.Service.SetRefreshLimit; excess requests get 429.
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	encoding, err := h.requestEncoding(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	request, err := DecodeRefreshRequest(w, r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	opts := captcha.IssueOptions{Theme: r.URL.Query().Get("theme"), Replace: request.ID, Client: h.clientAddress(r)}
+	var session *Session
+	if h.sessions != nil {
+		if session, err = h.sessions.Get(r); err == nil {
+			err = h.sessions.CheckCSRF(r, session)
+		}
+		if err == nil && opts.Replace != "" && opts.Replace != session.CaptchaID {
+			err = sessionError("captcha was not issued to this session")
+		}
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+		opts.Replace, opts.Client, opts.Session = session.CaptchaID, session.ID, session.ID
+	} else if opts.Replace == "" {
+		h.writeError(w, r, captcha.NewError(captcha.ErrInvalidRequest, "id is required", 400))
+		return
+	}
+
+	challenge, err := h.service.IssueWithOptionsContext(r.Context(), opts)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writeChallenge(w, r, challenge, encoding, session)
+}
+
+// requestEncoding returns the image encoding of the encoding query parameter or the default
+func (h *Handler) requestEncoding(r *http.Request) (string, error) {
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = h.encoding
+	}
+	switch encoding {
+	case captcha.EncodingSVG, captcha.EncodingBase64, captcha.EncodingUTF8, captcha.EncodingPNG:
+		return encoding, nil
+	}
+	return "", captcha.NewError(captcha.ErrInvalidRequest, "unknown encoding: "+encoding, 400)
+}
+
+// writeChallenge answers with challenge as an IssueResponse, binding it to session if not nil
+func (h *Handler) writeChallenge(w http.ResponseWriter, r *http.Request, challenge *captcha.Challenge, encoding string, session *Session) {
+	image, err := challenge.Result.Encode(encoding, h.pngScale)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := IssueResponse{
+		ID:        challenge.ID,
+		Image:     image,
+		Encoding:  encoding,
+		ExpiresAt: challenge.ExpiresAt,
+	}
+	if session != nil {
+		session.CaptchaID = challenge.ID
+		h.sessions.Save(w, r, session)
+		response.CSRFToken = h.sessions.CSRFToken(session)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Verify checks a VerifyRequest. Every outcome is answered with 200 and a VerifyResponse; only
+// malformed requests, store failures and, with sessions, requests outside the captcha's session
+// are errors.
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+	request, err := DecodeVerifyRequest(w, r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	var result captcha.VerifyResult
+	if h.sessions != nil {
+		result, err = h.sessions.Verify(w, r, h.service, request.ID, request.Answer)
+	} else {
+		result, err = h.service.VerifyContext(r.Context(), request.ID, request.Answer)
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, VerifyResponse{Success: result == captcha.VerifySuccess, Result: result})
+}
+
+// DecodeVerifyRequest reads a VerifyRequest from a JSON or form-encoded body
+func DecodeVerifyRequest(w http.ResponseWriter, r *http.Request) (*VerifyRequest, error) {
+	var request VerifyRequest
+	if err := decodeBody(w, r, &request); err != nil {
+		return nil, err
+	}
+	if request.ID == "" {
+		return nil, captcha.NewError(captcha.ErrInvalidRequest, "id is required", 400)
+	}
+	return &request, nil
+}
+
+// DecodeRefreshRequest reads a RefreshRequest from a JSON or form-encoded body, which may be empty
+func DecodeRefreshRequest(w http.ResponseWriter, r *http.Request) (*RefreshRequest, error) {
+	var request VerifyRequest
+	if err := decodeBody(w, r, &request); err != nil {
+		return nil, err
+	}
+	return &RefreshRequest{ID: request.ID}, nil
+}
+
+// decodeBody reads the id and answer fields of a JSON or form-encoded body into request. An
+// empty body leaves request unchanged.
+func decodeBody(w http.ResponseWriter, r *http.Request, request *VerifyRequest) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return bodyError("invalid form body", err)
+		}
+		request.ID = r.PostForm.Get("id")
+		request.Answer = r.PostForm.Get("answer")
+	} else if err := json.NewDecoder(r.Body).Decode(request); err != nil && !errors.Is(err, io.EOF) {
+		return bodyError("invalid JSON body", err)
+	}
+	return nil
+}
+
+// clientAddress returns the host of the request's remote address or, for requests from trusted
+// proxies, the last address in X-Forwarded-For that is not a trusted proxy
+func (h *Handler) clientAddress(r *http.Request) string {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if !h.trustedProxy(address) {
+		return address
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if !h.trustedProxy(hop) {
+			return hop
+		}
+		address = hop
+	}
+	return address
+}
+
+// trustedProxy reports whether address is in a network of WithTrustedProxies
+func (h *Handler) trustedProxy(address string) bool {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, proxy := range h.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError answers with err as problem details, logging server errors
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if status := captcha.HTTPStatus(err); status >= 500 {
+		h.logger.ErrorContext(r.Context(), "captcha request failed",
+			slog.String("path", r.URL.Path), slog.Int("status", status), slog.String("error", err.Error()))
+	}
+	captcha.WriteProblem(w, r, err)
+}
+
+// writeJSON answers with value as JSON
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// bodyError describes a body that could not be decoded, answering oversized bodies with 413
+func bodyError(message string, err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return captcha.WrapError(captcha.ErrInvalidRequest, "request body too large", http.StatusRequestEntityTooLarge, err)
+	}
+	return captcha.WrapError(captcha.ErrInvalidRequest, message, 400, err)
+}
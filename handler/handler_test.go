@@ -0,0 +1,591 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// recordingStore is a MemoryStore that remembers issued answers, so tests can answer captchas
+type recordingStore struct {
+	*captcha.MemoryStore
+	mutex   sync.Mutex
+	answers map[string]string
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{MemoryStore: captcha.NewMemoryStore(), answers: make(map[string]string)}
+}
+
+func (rs *recordingStore) Set(id string, entry captcha.StoreEntry) error {
+	rs.mutex.Lock()
+	rs.answers[id] = entry.Answer
+	rs.mutex.Unlock()
+	return rs.MemoryStore.Set(id, entry)
+}
+
+func (rs *recordingStore) answer(id string) string {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	return rs.answers[id]
+}
+
+// newTestHandler creates a handler over a recording store
+func newTestHandler(opts ...Option) (*Handler, *recordingStore) {
+	store := newRecordingStore()
+	return New(captcha.NewService(nil, store), opts...), store
+}
+
+// issue issues a captcha through h and decodes the response
+func issue(t *testing.T, h http.Handler, query string) IssueResponse {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("POST", "/issue"+query, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected issue to succeed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response IssueResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode issue response: %v", err)
+	}
+	return response
+}
+
+// verify posts a JSON verification through h
+func verify(h http.Handler, id, answer string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(VerifyRequest{ID: id, Answer: answer})
+	request := httptest.NewRequest("POST", "/verify", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	h, store := newTestHandler()
+
+	response := issue(t, h, "")
+	if response.ID == "" || response.ExpiresAt.IsZero() {
+		t.Fatalf("Expected ID and expiry, got %+v", response)
+	}
+	if response.Encoding != captcha.EncodingBase64 || !strings.HasPrefix(response.Image, "data:image/svg+xml;base64,") {
+		t.Errorf("Expected a base64 data URI by default, got %s", response.Encoding)
+	}
+
+	recorder := verify(h, response.ID, store.answer(response.ID))
+	var result VerifyResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode verify response: %v", err)
+	}
+	if recorder.Code != http.StatusOK || !result.Success || result.Result != captcha.VerifySuccess {
+		t.Errorf("Expected success, got %d %+v", recorder.Code, result)
+	}
+
+	// Each captcha allows one attempt
+	recorder = verify(h, response.ID, store.answer(response.ID))
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	if result.Success || result.Result != captcha.VerifyNotFound {
+		t.Errorf("Expected a reused captcha to be rejected, got %+v", result)
+	}
+}
+
+func TestIssueResponseHidesAnswer(t *testing.T) {
+	h, store := newTestHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/issue?encoding=svg", nil))
+
+	var raw map[string]any
+	json.Unmarshal(recorder.Body.Bytes(), &raw)
+	for _, key := range []string{"text", "answer", "question"} {
+		if _, ok := raw[key]; ok {
+			t.Errorf("Expected %q to stay out of the issue response", key)
+		}
+	}
+	if image, _ := raw["image"].(string); !strings.Contains(image, "<svg") {
+		t.Errorf("Expected raw SVG for encoding=svg, got %.20q", image)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected one stored captcha, got %d", store.Len())
+	}
+}
+
+func TestIssueOptions(t *testing.T) {
+	h, _ := newTestHandler(WithEncoding(captcha.EncodingUTF8))
+
+	if response := issue(t, h, ""); response.Encoding != captcha.EncodingUTF8 {
+		t.Errorf("Expected the configured default encoding, got %s", response.Encoding)
+	}
+	if response := issue(t, h, "?encoding=png&theme=dark"); !strings.HasPrefix(response.Image, "data:image/png;base64,") {
+		t.Errorf("Expected a PNG data URI, got %.30q", response.Image)
+	}
+
+	for _, query := range []string{"?encoding=gif", "?theme=neon"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/issue"+query, nil))
+		if recorder.Code != http.StatusBadRequest || recorder.Header().Get("Content-Type") != captcha.ProblemContentType {
+			t.Errorf("Expected a 400 problem for %s, got %d", query, recorder.Code)
+		}
+	}
+}
+
+func TestVerifyRequests(t *testing.T) {
+	h, store := newTestHandler()
+
+	// Form bodies are accepted as well as JSON
+	response := issue(t, h, "")
+	form := url.Values{"id": {response.ID}, "answer": {store.answer(response.ID)}}
+	request := httptest.NewRequest("POST", "/verify", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), `"success":true`) {
+		t.Errorf("Expected form verification to succeed, got %s", recorder.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed JSON", "{", http.StatusBadRequest},
+		{"missing id", `{"answer":"5"}`, http.StatusBadRequest},
+		{"oversized body", `{"id":"` + strings.Repeat("a", maxRequestBytes) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("POST", "/verify", strings.NewReader(tt.body)))
+		if recorder.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, recorder.Code)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/verify", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /verify to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	h := LogRequests(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pot", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to decode log record: %v", err)
+	}
+	if record["path"] != "/pot" || record["status"] != float64(http.StatusTeapot) || record["bytes"] != float64(15) {
+		t.Errorf("Unexpected log record %v", record)
+	}
+}
+
+func TestRequireCaptcha(t *testing.T) {
+	h, store := newTestHandler()
+	protected := RequireCaptcha(h.Service())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome " + r.PostFormValue("name")))
+	}))
+
+	// Headers
+	response := issue(t, h, "")
+	request := httptest.NewRequest("POST", "/signup", nil)
+	request.Header.Set(HeaderCaptchaID, response.ID)
+	request.Header.Set(HeaderCaptchaAnswer, store.answer(response.ID))
+	recorder := httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected a solved captcha to pass, got %d", recorder.Code)
+	}
+
+	// The captcha admits one request
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), captcha.ErrVerificationFailed) {
+		t.Errorf("Expected a reused captcha to be rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Form fields stay readable by the protected handler
+	response = issue(t, h, "")
+	form := url.Values{FieldCaptchaID: {response.ID}, FieldCaptchaAnswer: {store.answer(response.ID)}, "name": {"ada"}}
+	request = httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "welcome ada" {
+		t.Errorf("Expected the form to reach the handler, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	for name, request := range map[string]*http.Request{
+		"missing": httptest.NewRequest("POST", "/signup", nil),
+		"wrong":   httptest.NewRequest("POST", "/signup?captcha_id="+issue(t, h, "").ID+"&captcha_answer=-1", nil),
+	} {
+		recorder := httptest.NewRecorder()
+		protected.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusForbidden || recorder.Header().Get("Content-Type") != captcha.ProblemContentType {
+			t.Errorf("%s: expected a 403 problem, got %d", name, recorder.Code)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	for _, config := range []CORSConfig{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"example.com"}},
+		{AllowedOrigins: []string{"https://example.com/"}},
+		{AllowedOrigins: []string{"https://a.*.example.com"}},
+	} {
+		if _, err := NewCORS(config); !errors.Is(err, captcha.ErrorInvalidConfig) {
+			t.Errorf("Expected %v to be rejected, got %v", config.AllowedOrigins, err)
+		}
+	}
+
+	cors, err := NewCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"Retry-After"},
+		MaxAge:           time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"https://app.example.com":  true,
+		"HTTPS://APP.EXAMPLE.COM":  true,
+		"https://a.b.example.org":  true,
+		"https://example.org":      false,
+		"http://app.example.com":   false,
+		"https://evil-example.org": false,
+		"null":                     false,
+		"":                         false,
+	} {
+		if got := cors.AllowOrigin(origin); got != want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	h, _ := newTestHandler()
+	api := cors.Handler(h)
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/issue", nil)
+		request.Header.Set("Origin", origin)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := serve("OPTIONS", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "content-type, x-captcha-id",
+	})
+	header := recorder.Header()
+	if recorder.Code != http.StatusNoContent || header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		header.Get("Access-Control-Allow-Credentials") != "true" || header.Get("Access-Control-Max-Age") != "3600" ||
+		!strings.Contains(header.Get("Access-Control-Allow-Headers"), HeaderCaptchaAnswer) {
+		t.Errorf("Expected an allowed preflight, got %d %v", recorder.Code, header)
+	}
+
+	for name, headers := range map[string]map[string]string{
+		"method": {"Access-Control-Request-Method": "DELETE"},
+		"header": {"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "authorization"},
+	} {
+		recorder := serve("OPTIONS", "https://app.example.com", headers)
+		if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: expected a denied preflight, got %d %v", name, recorder.Code, recorder.Header())
+		}
+	}
+
+	recorder = serve("GET", "https://app.example.com", nil)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		recorder.Header().Get("Access-Control-Expose-Headers") != "Retry-After" || recorder.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected CORS headers on an allowed request, got %d %v", recorder.Code, recorder.Header())
+	}
+	recorder = serve("GET", "https://evil.example.com", nil)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for other origins, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	// Any origin, without credentials or preflight caching
+	cors, err = NewCORS(CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api = cors.Handler(h)
+	recorder = serve("OPTIONS", "https://anywhere.example", map[string]string{"Access-Control-Request-Method": "GET"})
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "*" || recorder.Header().Get("Access-Control-Allow-Credentials") != "" ||
+		recorder.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("Expected a wildcard preflight without caching, got %v", recorder.Header())
+	}
+}
+
+func TestSessions(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	for name, config := range map[string]SessionConfig{
+		"short key":       {Key: key[:16]},
+		"insecure none":   {Key: key, SameSite: http.SameSiteNoneMode, Insecure: true},
+		"negative maxAge": {Key: key, MaxAge: -time.Minute},
+	} {
+		if _, err := NewSessions(config); !errors.Is(err, captcha.ErrorInvalidConfig) {
+			t.Errorf("%s: expected the config to be rejected, got %v", name, err)
+		}
+	}
+
+	sessions, err := NewSessions(SessionConfig{Key: key, Fingerprint: UserAgentFingerprint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, store := newTestHandler()
+	h := New(plain.Service(), WithSessions(sessions))
+
+	// issueSession issues a captcha and returns the response and session cookie
+	issueSession := func(cookies ...*http.Cookie) (IssueResponse, *http.Cookie) {
+		request := httptest.NewRequest("POST", "/issue", nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		var response IssueResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		result := recorder.Result().Cookies()
+		if len(result) != 1 {
+			t.Fatalf("Expected a session cookie, got %v", result)
+		}
+		return response, result[0]
+	}
+	// verifySession verifies answer with the session cookie and CSRF token
+	verifySession := func(id, answer, token string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(VerifyRequest{ID: id, Answer: answer})
+		request := httptest.NewRequest("POST", "/verify", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(HeaderCSRFToken, token)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response, cookie := issueSession()
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Name != DefaultSessionCookie ||
+		cookie.MaxAge < int(DefaultSessionMaxAge.Seconds())-1 || response.CSRFToken == "" {
+		t.Errorf("Expected a secure session cookie and a CSRF token, got %+v %q", cookie, response.CSRFToken)
+	}
+	session, err := sessions.Get(func() *http.Request {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(cookie)
+		return request
+	}())
+	if err != nil || len(session.ID) < 26 || session.CaptchaID != response.ID {
+		t.Fatalf("Expected the session to hold the captcha, got %+v %v", session, err)
+	}
+
+	// The session survives a new captcha and is bound to the latest one
+	previous := response
+	response, cookie = issueSession(cookie)
+	if again, _ := sessions.Get(func() *http.Request {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.AddCookie(cookie)
+		return request
+	}()); again == nil || again.ID != session.ID || response.CSRFToken != previous.CSRFToken {
+		t.Errorf("Expected the session to be kept, got %+v", again)
+	}
+
+	tampered := *cookie
+	tampered.Value = strings.Replace(cookie.Value, response.ID, previous.ID, 1)
+	_, otherCookie := issueSession()
+	for name, recorder := range map[string]*httptest.ResponseRecorder{
+		"no cookie":     verifySession(response.ID, store.answer(response.ID), response.CSRFToken, nil),
+		"no token":      verifySession(response.ID, store.answer(response.ID), "", cookie),
+		"other session": verifySession(response.ID, store.answer(response.ID), response.CSRFToken, otherCookie),
+		"other captcha": verifySession(previous.ID, store.answer(previous.ID), response.CSRFToken, cookie),
+		"tampered":      verifySession(previous.ID, store.answer(previous.ID), response.CSRFToken, &tampered),
+	} {
+		if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), captcha.ErrVerificationFailed) {
+			t.Errorf("%s: expected a 403 problem, got %d %s", name, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder := verifySession(response.ID, store.answer(response.ID), response.CSRFToken, cookie)
+	if !strings.Contains(recorder.Body.String(), `"success":true`) {
+		t.Fatalf("Expected the session's captcha to verify, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	cleared := recorder.Result().Cookies()[0]
+	recorder = verifySession(response.ID, store.answer(response.ID), response.CSRFToken, cleared)
+	if !strings.Contains(recorder.Body.String(), string(captcha.VerifyNotFound)) {
+		t.Errorf("Expected the verified captcha to leave the session, got %s", recorder.Body.String())
+	}
+
+	// Fingerprint and expiry
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(cookie)
+	request.Header.Set("User-Agent", "other-browser")
+	if _, err := sessions.Get(request); err == nil {
+		t.Error("Expected a cookie from another client to be rejected")
+	}
+	sessions.now = func() time.Time { return time.Now().Add(DefaultSessionMaxAge) }
+	request.Header.Del("User-Agent")
+	request.Header.Set("User-Agent", httptest.NewRequest("GET", "/", nil).UserAgent())
+	if _, err := sessions.Get(request); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected an expired session to be rejected, got %v", err)
+	}
+	sessions.now = time.Now
+
+	// Require, with form fields
+	protected := sessions.Require(h.Service())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	response, cookie = issueSession()
+	form := url.Values{FieldCaptchaAnswer: {store.answer(response.ID)}, FieldCSRFToken: {response.CSRFToken}}
+	request = httptest.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(cookie)
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+	if recorder.Body.String() != "welcome" {
+		t.Errorf("Expected a solved session captcha to pass, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Session captchas cannot be replayed through the guard without the session
+	response, _ = issueSession()
+	if err := Check(t.Context(), h.Service(), response.ID, store.answer(response.ID)); !errors.Is(err, captcha.ErrorVerificationFailed) {
+		t.Errorf("Expected the plain guard to reject a session captcha, got %v", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	h, store := newTestHandler()
+	if err := h.Service().SetRefreshLimit(2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(h http.Handler, body string, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/refresh?encoding=svg", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			request.AddCookie(cookie)
+			request.Header.Set(HeaderCSRFToken, token)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Without sessions, refreshes are counted per client address
+	old := issue(t, h, "")
+	recorder := refresh(h, `{"id":"`+old.ID+`"}`, nil, "")
+	var refreshed IssueResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &refreshed); err != nil || refreshed.ID == old.ID || refreshed.Encoding != captcha.EncodingSVG {
+		t.Fatalf("Expected a new captcha, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if !strings.Contains(verify(h, old.ID, store.answer(old.ID)).Body.String(), string(captcha.VerifyNotFound)) {
+		t.Error("Expected the refreshed captcha to be invalidated")
+	}
+	if recorder := refresh(h, "", nil, ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a refresh without ID to be rejected, got %d", recorder.Code)
+	}
+	refresh(h, `{"id":"`+refreshed.ID+`"}`, nil, "")
+	recorder = refresh(h, `{"id":"`+refreshed.ID+`"}`, nil, "")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" ||
+		!strings.Contains(recorder.Body.String(), captcha.ErrRateLimited) {
+		t.Errorf("Expected 429 beyond the refresh limit, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	// With sessions, the new captcha is bound to the same session and issuing counts as a refresh
+	sessions, err := NewSessions(SessionConfig{Key: bytes.Repeat([]byte("k"), 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ = newTestHandler(WithSessions(sessions))
+	if err := h.Service().SetRefreshLimit(2, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/issue", nil))
+	json.Unmarshal(recorder.Body.Bytes(), &old)
+	cookie := recorder.Result().Cookies()[0]
+
+	if recorder := refresh(h, "", cookie, "wrong"); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a refresh without the CSRF token to be rejected, got %d", recorder.Code)
+	}
+	if recorder := refresh(h, `{"id":"`+issue(t, h, "").ID+`"}`, cookie, old.CSRFToken); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a refresh of another session's captcha to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = refresh(h, "", cookie, old.CSRFToken)
+	json.Unmarshal(recorder.Body.Bytes(), &refreshed)
+	cookie = recorder.Result().Cookies()[0]
+	if recorder.Code != http.StatusOK || refreshed.ID == old.ID || refreshed.CSRFToken != old.CSRFToken {
+		t.Fatalf("Expected a new captcha in the same session, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	request := httptest.NewRequest("GET", "/issue", nil)
+	request.AddCookie(cookie)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the second refresh to succeed, got %d", recorder.Code)
+	}
+	cookie = recorder.Result().Cookies()[0]
+	request = httptest.NewRequest("GET", "/issue", nil)
+	request.AddCookie(cookie)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected issuing in a session with a pending captcha to count as a refresh, got %d", recorder.Code)
+	}
+
+	// With an issue limiter, dropping the cookie starts a new session but counts against the
+	// client address, taken from X-Forwarded-For behind trusted proxies
+	limiter, err := captcha.NewRateLimiter(2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ = newTestHandler(WithSessions(sessions), WithIssueLimiter(limiter),
+		WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")))
+	issueFrom := func(remote, forwarded string) int {
+		request := httptest.NewRequest("GET", "/issue", nil)
+		request.RemoteAddr = remote
+		if forwarded != "" {
+			request.Header.Set("X-Forwarded-For", forwarded)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := issueFrom("198.51.100.7:4321", ""); code != want {
+			t.Errorf("Cookie-less issue %d: expected %d, got %d", i+1, want, code)
+		}
+	}
+	if code := issueFrom("198.51.100.7:4321", "203.0.113.9"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected X-Forwarded-For from an untrusted client to be ignored, got %d", code)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := issueFrom("10.1.2.3:80", "203.0.113.9, 10.0.0.2"); code != want {
+			t.Errorf("Proxied issue %d: expected %d, got %d", i+1, want, code)
+		}
+	}
+	if code := issueFrom("10.1.2.3:80", "203.0.113.10"); code != http.StatusOK {
+		t.Errorf("Expected another client behind the proxy to keep its own limit, got %d", code)
+	}
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// Defaults of SessionConfig
+const (
+	DefaultSessionCookie = "captcha_session"
+	DefaultSessionMaxAge = 30 * time.Minute
+)
+
+// Names under which requests carry the CSRF token of their session
+const (
+	HeaderCSRFToken = "X-CSRF-Token"
+	FieldCSRFToken  = "csrf_token"
+)
+
+// minSessionKeyBytes is the shortest accepted SessionConfig.Key
+const minSessionKeyBytes = 32
+
+// SessionConfig controls the captcha session cookies of Sessions
+type SessionConfig struct {
+	Key        []byte        // HMAC-SHA256 key signing the cookies, at least 32 random bytes (required)
+	CookieName string        // Name of the session cookie (default: "captcha_session")
+	Path       string        // Path of the session cookie (default: "/")
+	Domain     string        // Domain of the session cookie (default: host of the request)
+	MaxAge     time.Duration // Lifetime of a session from its creation (default: 30 minutes)
+	// SameSite attribute of the cookie. Front ends on another site calling the API with CORS
+	// credentials need http.SameSiteNoneMode (default: http.SameSiteLaxMode)
+	SameSite http.SameSite
+	// Leave out the Secure attribute, only for development over plain HTTP (default: false)
+	Insecure bool
+	// Client properties a session is bound to, such as UserAgentFingerprint. A cookie copied to
+	// a client with a different fingerprint is rejected (default: none)
+	Fingerprint func(r *http.Request) string
+}
+
+// Validate checks the key length and the cookie attributes
+func (c *SessionConfig) Validate() error {
+	if len(c.Key) < minSessionKeyBytes {
+		return captcha.NewError(captcha.ErrInvalidConfig, "session key must be at least 32 bytes", 400)
+	}
+	if c.MaxAge < 0 {
+		return captcha.NewError(captcha.ErrInvalidConfig, "session max age cannot be negative", 400)
+	}
+	if c.SameSite == http.SameSiteNoneMode && c.Insecure {
+		return captcha.NewError(captcha.ErrInvalidConfig, "SameSite=None cookies must be secure", 400)
+	}
+	return nil
+}
+
+// UserAgentFingerprint binds sessions to the client's User-Agent header
+func UserAgentFingerprint(r *http.Request) string {
+	return r.UserAgent()
+}
+
+// Session is a client's captcha session, kept in a signed cookie
+type Session struct {
+	ID        string    // Random, unguessable session ID
+	CaptchaID string    // Captcha issued to the session, empty if none is pending
+	CreatedAt time.Time // Sessions expire MaxAge after creation
+}
+
+// Sessions keeps captcha sessions in HMAC-signed cookies and binds captchas to them: a captcha
+// can only be verified from the session it was issued to, with the session's CSRF token.
+type Sessions struct {
+	config SessionConfig
+	now    func() time.Time
+}
+
+// NewSessions creates a session transport from config
+func NewSessions(config SessionConfig) (*Sessions, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.CookieName == "" {
+		config.CookieName = DefaultSessionCookie
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = DefaultSessionMaxAge
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	return &Sessions{config: config, now: time.Now}, nil
+}
+
+// Get returns the session of r. It returns an ErrVerificationFailed error, answered with 403,
+// if r has no session cookie or the cookie is forged, expired or from another client.
+func (s *Sessions) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, sessionError("captcha session required")
+	}
+
+	payload, mac, ok := cutLast(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.sign(r, "session", payload))) {
+		return nil, sessionError("invalid captcha session")
+	}
+	fields := strings.SplitN(payload, ".", 3)
+	if len(fields) != 3 {
+		return nil, sessionError("invalid captcha session")
+	}
+	created, err := strconv.ParseInt(fields[1], 36, 64)
+	if err != nil {
+		return nil, sessionError("invalid captcha session")
+	}
+
+	session := &Session{ID: fields[0], CaptchaID: fields[2], CreatedAt: time.Unix(created, 0)}
+	if !s.now().Before(session.CreatedAt.Add(s.config.MaxAge)) {
+		return nil, sessionError("captcha session expired")
+	}
+	return session, nil
+}
+
+// Start returns the session of r, or a new session if r has no valid one. The session is only
+// sent to the client by Save.
+func (s *Sessions) Start(r *http.Request) *Session {
+	if session, err := s.Get(r); err == nil {
+		return session
+	}
+	return &Session{ID: rand.Text(), CreatedAt: s.now().Truncate(time.Second)}
+}
+
+// Save sets the session cookie for the rest of the session's lifetime. Call it before writing
+// the response body.
+func (s *Sessions) Save(w http.ResponseWriter, r *http.Request, session *Session) {
+	payload := session.ID + "." + strconv.FormatInt(session.CreatedAt.Unix(), 36) + "." + session.CaptchaID
+	http.SetCookie(w, s.cookie(payload+"."+s.sign(r, "session", payload), session.CreatedAt.Add(s.config.MaxAge).Sub(s.now())))
+}
+
+// Clear deletes the session cookie
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie("", -1))
+}
+
+// cookie returns the session cookie with value, expiring after maxAge
+func (s *Sessions) cookie(value string, maxAge time.Duration) *http.Cookie {
+	seconds := int(maxAge.Seconds())
+	if seconds <= 0 {
+		seconds = -1
+	}
+	return &http.Cookie{
+		Name:     s.config.CookieName,
+		Value:    value,
+		Path:     s.config.Path,
+		Domain:   s.config.Domain,
+		MaxAge:   seconds,
+		Secure:   !s.config.Insecure,
+		HttpOnly: true,
+		SameSite: s.config.SameSite,
+	}
+}
+
+// CSRFToken returns the token requests of session must carry to verify captchas, in the
+// X-CSRF-Token header or the csrf_token form field
+func (s *Sessions) CSRFToken(session *Session) string {
+	return s.sign(nil, "csrf", session.ID)
+}
+
+// CheckCSRF returns an ErrVerificationFailed error unless r carries the CSRF token of session
+func (s *Sessions) CheckCSRF(r *http.Request, session *Session) error {
+	token := r.Header.Get(HeaderCSRFToken)
+	if token == "" {
+		token = r.FormValue(FieldCSRFToken)
+	}
+	if token == "" || !hmac.Equal([]byte(token), []byte(s.CSRFToken(session))) {
+		return sessionError("invalid CSRF token")
+	}
+	return nil
+}
+
+// Verify verifies answer against the captcha issued to the session of r, which must carry the
+// session's CSRF token. A non-empty id must be the ID of that captcha. Whatever the outcome, the
+// captcha is removed from the session and the session saved to w.
+func (s *Sessions) Verify(w http.ResponseWriter, r *http.Request, service *captcha.Service, id, answer string) (captcha.VerifyResult, error) {
+	session, err := s.Get(r)
+	if err != nil {
+		return "", err
+	}
+	if err := s.CheckCSRF(r, session); err != nil {
+		return "", err
+	}
+	if session.CaptchaID == "" {
+		return captcha.VerifyNotFound, nil
+	}
+	if id != "" && id != session.CaptchaID {
+		return "", sessionError("captcha was not issued to this session")
+	}
+
+	result, err := service.VerifySessionContext(r.Context(), session.ID, session.CaptchaID, answer)
+	if err != nil {
+		return "", err
+	}
+	session.CaptchaID = ""
+	s.Save(w, r, session)
+	return result, nil
+}
+
+// Require returns middleware that passes a request on only if it solves the captcha of its
+// session, see Verify and Credentials. It is RequireCaptcha for captchas issued with sessions.
+func (s *Sessions) Require(service *captcha.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, answer := Credentials(r)
+			result, err := s.Verify(w, r, service, id, answer)
+			if err == nil && result != captcha.VerifySuccess {
+				err = verificationError(result)
+			}
+			if err != nil {
+				captcha.WriteProblem(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sign returns the base64 HMAC of purpose and value, bound to the fingerprint of r if r is not nil
+func (s *Sessions) sign(r *http.Request, purpose, value string) string {
+	mac := hmac.New(sha256.New, s.config.Key)
+	mac.Write([]byte(purpose + "\x00"))
+	if r != nil && s.config.Fingerprint != nil {
+		mac.Write([]byte(s.config.Fingerprint(r)))
+	}
+	mac.Write([]byte("\x00" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// sessionError returns the 403 error of a missing or invalid session
+func sessionError(message string) error {
+	return captcha.NewError(captcha.ErrVerificationFailed, message, http.StatusForbidden)
+}
@@ -0,0 +1,84 @@
+// Package randsource is the source of all captcha randomness: a buffered CSPRNG reading
+// crypto/rand. Tools and tests of this module replace it with Set to produce reproducible
+// captchas; being internal, the source cannot be swapped by programs importing the module.
+package randsource
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	mathrand "math/rand/v2"
+	"sync"
+)
+
+// bufferSize is how many bytes of source output are fetched at once
+const bufferSize = 512
+
+// reader buffers source output so the many small draws of one captcha share a few reads
+// instead of each allocating a big.Int and reading separately
+type reader struct {
+	mutex  sync.Mutex
+	source io.Reader
+	buf    [bufferSize]byte
+	pos    int
+}
+
+// global is the reader behind Uint64
+var global = &reader{source: rand.Reader, pos: bufferSize}
+
+// Uint64 returns 64 uniformly random bits
+func Uint64() (uint64, error) {
+	return global.uint64()
+}
+
+// Set replaces the source, crypto/rand by default, and returns a function restoring the
+// previous source. A predictable source makes captchas predictable: use it only for
+// reproducible datasets and tests, never for captchas shown to users.
+func Set(source io.Reader) (restore func()) {
+	previous := global.setSource(source)
+	return func() { global.setSource(previous) }
+}
+
+// Source returns the current source
+func Source() io.Reader {
+	global.mutex.Lock()
+	defer global.mutex.Unlock()
+	return global.source
+}
+
+// NewSeeded returns a deterministic ChaCha8 stream derived from seed, for Set
+func NewSeeded(seed uint64) io.Reader {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	return mathrand.NewChaCha8(key)
+}
+
+// setSource replaces the source, discarding buffered bytes, and returns the previous source
+func (r *reader) setSource(source io.Reader) io.Reader {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	previous := r.source
+	r.source = source
+	clear(r.buf[:])
+	r.pos = len(r.buf)
+	return previous
+}
+
+// uint64 returns 64 uniformly random bits from the buffer, refilling it when drained
+func (r *reader) uint64() (uint64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pos+8 > len(r.buf) {
+		if _, err := io.ReadFull(r.source, r.buf[:]); err != nil {
+			return 0, err
+		}
+		r.pos = 0
+	}
+
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	clear(r.buf[r.pos : r.pos+8]) // Consumed bytes never stay in memory
+	r.pos += 8
+	return v, nil
+}
@@ -0,0 +1,142 @@
+// Package ocreval measures how well captcha configurations resist automated solving.
+//
+// It generates captchas, rasterizes them with captcha.Rasterize and attacks them with
+// a simple pure-Go template-matching solver. The resulting solve rate is a regression
+// signal for noise and distortion changes, not a guarantee against stronger attackers.
+package ocreval
+
+import (
+	"fmt"
+	"time"
+
+	"svg-math-captcha/captcha"
+)
+
+// DefaultScale is the rasterization scale used by Evaluate
+const DefaultScale = 2
+
+// Preset is a named captcha configuration to evaluate
+type Preset struct {
+	Name   string
+	Config *captcha.Config
+}
+
+// Report summarizes the solver's success against one configuration
+type Report struct {
+	Name       string        `json:"name"`
+	Samples    int           `json:"samples"`
+	Recognized int           `json:"recognized"` // Captchas where a well-formed expression was read
+	Solved     int           `json:"solved"`     // Captchas answered correctly
+	SolveRate  float64       `json:"solveRate"`
+	Elapsed    time.Duration `json:"elapsed"`
+}
+
+// Presets returns the built-in configurations, from no protection to every countermeasure enabled
+func Presets() []Preset {
+	clean := captcha.DefaultConfig()
+	clean.Noise = 0
+
+	noisy := captcha.DefaultConfig()
+	noisy.Noise = 3
+	noisy.NoiseIntersect = 0.5
+
+	distorted := captcha.DefaultConfig()
+	distorted.Distortion = captcha.DistortionConfig{
+		WaveAmplitude: 3,
+		Skew:          20,
+		Scale:         0.2,
+		Overlap:       0.15,
+		StrokeJitter:  1,
+	}
+
+	decoys := captcha.DefaultConfig()
+	decoys.Decoys = 10
+
+	hardened := captcha.DefaultConfig()
+	hardened.Noise = 3
+	hardened.NoiseIntersect = 0.5
+	hardened.Decoys = 10
+	hardened.Distortion = distorted.Distortion
+
+	return []Preset{
+		{Name: "clean", Config: clean},
+		{Name: "default", Config: captcha.DefaultConfig()},
+		{Name: "noisy", Config: noisy},
+		{Name: "distorted", Config: distorted},
+		{Name: "decoys", Config: decoys},
+		{Name: "hardened", Config: hardened},
+	}
+}
+
+// Evaluate generates samples captchas with config and reports how many the solver answers correctly
+func Evaluate(name string, config *captcha.Config, samples int) (*Report, error) {
+	if samples <= 0 {
+		return nil, fmt.Errorf("samples must be positive")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	solver, err := NewSolver(config, DefaultScale)
+	if err != nil {
+		return nil, err
+	}
+
+	background, err := parseBackground(config.Background)
+	if err != nil {
+		return nil, err
+	}
+
+	generator := captcha.NewCaptchaGenerator(config)
+	report := &Report{Name: name, Samples: samples}
+	start := time.Now()
+
+	for i := 0; i < samples; i++ {
+		result, err := generator.CreateMathExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := captcha.Rasterize(result.Data, DefaultScale)
+		if err != nil {
+			return nil, err
+		}
+
+		answer, ok := solver.Solve(img, background)
+		if ok {
+			report.Recognized++
+		}
+		if ok && answer == result.Text {
+			report.Solved++
+		}
+	}
+
+	report.Elapsed = time.Since(start)
+	report.SolveRate = float64(report.Solved) / float64(samples)
+	return report, nil
+}
+
+// EvaluatePresets evaluates every preset with the same number of samples
+func EvaluatePresets(presets []Preset, samples int) ([]*Report, error) {
+	reports := make([]*Report, 0, len(presets))
+	for _, preset := range presets {
+		report, err := Evaluate(preset.Name, preset.Config, samples)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", preset.Name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// parseBackground converts any CSS background color into channel values
+func parseBackground(value string) ([3]float64, error) {
+	hex, err := captcha.ParseColor(value)
+	if err != nil {
+		return [3]float64{}, err
+	}
+
+	var r, g, b uint8
+	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
+	return [3]float64{float64(r), float64(g), float64(b)}, nil
+}
@@ -0,0 +1,78 @@
+package ocreval
+
+import (
+	"testing"
+
+	"svg-math-captcha/captcha"
+	"svg-math-captcha/internal/randsource"
+)
+
+func TestSolverReadsCleanCaptchas(t *testing.T) {
+	config := captcha.DefaultConfig()
+	config.Noise = 0
+
+	report, err := Evaluate("clean", config, 20)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if report.Samples != 20 || report.Solved > report.Recognized {
+		t.Errorf("Inconsistent report: %+v", report)
+	}
+	// Without countermeasures the solver must succeed, otherwise it cannot detect regressions
+	if report.SolveRate < 0.5 {
+		t.Errorf("Expected clean captchas to be solved at least half the time, got %.2f", report.SolveRate)
+	}
+}
+
+func TestHardenedResistsSolver(t *testing.T) {
+	var clean, hardened *captcha.Config
+	for _, preset := range Presets() {
+		switch preset.Name {
+		case "clean":
+			clean = preset.Config
+		case "hardened":
+			hardened = preset.Config
+		}
+	}
+
+	// A fixed seed keeps the solve rates, and so the test, from depending on the draw
+	restore := randsource.Set(randsource.NewSeeded(1))
+	defer restore()
+
+	reports, err := EvaluatePresets([]Preset{{"clean", clean}, {"hardened", hardened}}, 20)
+	if err != nil {
+		t.Fatalf("EvaluatePresets failed: %v", err)
+	}
+	if reports[1].SolveRate > 0.2 || reports[1].SolveRate >= reports[0].SolveRate {
+		t.Errorf("Hardened solve rate %.2f should be low and below clean %.2f", reports[1].SolveRate, reports[0].SolveRate)
+	}
+}
+
+func TestEvaluateValidation(t *testing.T) {
+	if _, err := Evaluate("empty", captcha.DefaultConfig(), 0); err == nil {
+		t.Error("Expected error for zero samples")
+	}
+
+	config := captcha.DefaultConfig()
+	config.Background = "not-a-color"
+	if _, err := Evaluate("invalid", config, 1); err == nil {
+		t.Error("Expected error for invalid background color")
+	}
+}
+
+// BenchmarkPresets reports the solve rate of each built-in preset as a custom metric
+func BenchmarkPresets(b *testing.B) {
+	for _, preset := range Presets() {
+		b.Run(preset.Name, func(b *testing.B) {
+			solved := 0
+			for i := 0; i < b.N; i++ {
+				report, err := Evaluate(preset.Name, preset.Config, 10)
+				if err != nil {
+					b.Fatal(err)
+				}
+				solved += report.Solved
+			}
+			b.ReportMetric(float64(solved)/float64(10*b.N), "solve-rate")
+		})
+	}
+}
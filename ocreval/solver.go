@@ -0,0 +1,376 @@
+package ocreval
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"svg-math-captcha/captcha"
+)
+
+// solverAlphabet lists the characters the solver can recognize
+const solverAlphabet = "0123456789+-="
+
+// Glyphs are normalized to a fixed grid before matching
+const (
+	gridWidth  = 12
+	gridHeight = 16
+)
+
+// templateRotations are the glyph rotations, in degrees, rendered for each template
+var templateRotations = []float64{-12, -6, 0, 6, 12}
+
+// expressionPattern matches a recognized "a+b=" or "a-b=" sequence
+var expressionPattern = regexp.MustCompile(`^(\d+)([+-])(\d+)=?$`)
+
+// glyphTemplate is a normalized ink grid for one rendered character
+type glyphTemplate struct {
+	char   rune
+	grid   []float64
+	height int // Unrotated ink height in pixels, used to reject undersized decoys
+}
+
+// candidate is a classified glyph segment found in one color layer
+type candidate struct {
+	x0, x1   int
+	char     rune
+	distance float64
+}
+
+// Solver is a naive OCR attacker. It segments ink by vertical projection, either over the
+// whole image or per color layer, and classifies each segment by template matching.
+type Solver struct {
+	templates   []glyphTemplate
+	heights     map[rune]int
+	scale       float64
+	threshold   float64
+	maxDistance float64
+}
+
+// NewSolver renders clean templates of every digit and operator at the configured font size
+func NewSolver(config *captcha.Config, scale float64) (*Solver, error) {
+	s := &Solver{
+		heights:     make(map[rune]int),
+		scale:       scale,
+		threshold:   90,
+		maxDistance: 0.2 * gridWidth * gridHeight,
+	}
+
+	for _, char := range solverAlphabet {
+		for _, rotation := range templateRotations {
+			size := config.FontSize * 2
+			svg := fmt.Sprintf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">`+
+				`<rect x="0" y="0" width="%d" height="%d" fill="#ffffff"></rect>`+
+				`<text x="%d" y="%d" fill="#000000" font-size="%d" transform="rotate(%.1f %d %d)">%c</text></svg>`,
+				size, size, size, size, size/4, size*3/4, config.FontSize, rotation, size/2, size/2, char)
+
+			img, err := captcha.Rasterize(svg, scale)
+			if err != nil {
+				return nil, err
+			}
+
+			width, height := img.Bounds().Dx(), img.Bounds().Dy()
+			mask := inkMask(img, [3]float64{255, 255, 255}, s.threshold)
+			grid, inkHeight, ok := normalizeSegment(mask, width, height, 0, width)
+			if !ok {
+				return nil, fmt.Errorf("template for %q is empty", char)
+			}
+			s.templates = append(s.templates, glyphTemplate{char: char, grid: grid, height: inkHeight})
+			if rotation == 0 {
+				s.heights[char] = inkHeight
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Recognize returns the characters read from a rasterized captcha, left to right.
+// It prefers the first strategy whose reading forms an expression.
+func (s *Solver) Recognize(img *image.RGBA, background [3]float64) string {
+	readings := s.readings(img, background)
+	for _, reading := range readings {
+		if expressionPattern.MatchString(reading) {
+			return reading
+		}
+	}
+	return readings[0]
+}
+
+// Solve recognizes the expression and returns the computed answer
+func (s *Solver) Solve(img *image.RGBA, background [3]float64) (string, bool) {
+	match := expressionPattern.FindStringSubmatch(s.Recognize(img, background))
+	if match == nil {
+		return "", false
+	}
+
+	a, _ := strconv.Atoi(match[1])
+	b, _ := strconv.Atoi(match[3])
+	if match[2] == "-" {
+		return strconv.Itoa(a - b), true
+	}
+	return strconv.Itoa(a + b), true
+}
+
+// readings runs both attack strategies: the whole ink mask, which suits clean images, and
+// per-color layers, which separate glyphs from differently colored noise
+func (s *Solver) readings(img *image.RGBA, background [3]float64) []string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	minWidth := int(2 * s.scale)
+
+	var whole strings.Builder
+	mask := inkMask(img, background, s.threshold)
+	for _, span := range projectionSegments(mask, width, height, minWidth) {
+		if grid, _, ok := normalizeSegment(mask, width, height, span[0], span[1]); ok {
+			char, _ := s.classify(grid)
+			whole.WriteRune(char)
+		}
+	}
+
+	var found []candidate
+	for _, mask := range colorLayers(img, background, s.threshold, int(2*s.scale*s.scale)) {
+		for _, span := range projectionSegments(mask, width, height, minWidth) {
+			grid, inkHeight, ok := normalizeSegment(mask, width, height, span[0], span[1])
+			if !ok {
+				continue
+			}
+			char, distance := s.classify(grid)
+			if distance > s.maxDistance || float64(inkHeight) < 0.7*float64(s.heights[char]) {
+				continue // noise fragment or undersized decoy
+			}
+			found = append(found, candidate{x0: span[0], x1: span[1], char: char, distance: distance})
+		}
+	}
+
+	// Order by position and keep the better match where candidates from different layers overlap
+	sort.Slice(found, func(i, j int) bool { return found[i].x0 < found[j].x0 })
+	var kept []candidate
+	for _, c := range found {
+		if n := len(kept); n > 0 && c.x0 < kept[n-1].x1 && (kept[n-1].x1-c.x0)*2 > min(c.x1-c.x0, kept[n-1].x1-kept[n-1].x0) {
+			if c.distance < kept[n-1].distance {
+				kept[n-1] = c
+			}
+			continue
+		}
+		kept = append(kept, c)
+	}
+
+	var layered strings.Builder
+	for _, c := range kept {
+		layered.WriteRune(c.char)
+	}
+
+	return []string{whole.String(), layered.String()}
+}
+
+// classify returns the template character with the smallest squared distance
+func (s *Solver) classify(grid []float64) (rune, float64) {
+	best, bestDistance := '?', math.Inf(1)
+	for _, t := range s.templates {
+		distance := 0.0
+		for i, v := range grid {
+			d := v - t.grid[i]
+			distance += d * d
+		}
+		if distance < bestDistance {
+			best, bestDistance = t.char, distance
+		}
+	}
+	return best, bestDistance
+}
+
+// colorLayers groups ink pixels by the color they were painted with. Frequent colors become
+// seeds, and every ink pixel joins the seed whose blend toward the background explains it,
+// so anti-aliased edges stay with their glyph.
+func colorLayers(img *image.RGBA, background [3]float64, threshold float64, minPixels int) [][]bool {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ink := inkMask(img, background, threshold)
+
+	pixel := func(i int) [3]float64 {
+		c := img.RGBAAt(bounds.Min.X+i%width, bounds.Min.Y+i/width)
+		return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	}
+
+	counts := make(map[[3]float64]int)
+	for i, v := range ink {
+		if v {
+			counts[pixel(i)]++
+		}
+	}
+
+	// Strongest colors first so that blends of a seed never become seeds themselves
+	var candidates [][3]float64
+	for c, count := range counts {
+		if count >= minPixels {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		di, dj := colorDistance(candidates[i], background), colorDistance(candidates[j], background)
+		if di != dj {
+			return di > dj
+		}
+		return counts[candidates[i]] > counts[candidates[j]]
+	})
+
+	var seeds [][3]float64
+	for _, c := range candidates {
+		blended := false
+		for _, seed := range seeds {
+			if t, residual := blendFactor(c, seed, background); t <= 1 && residual < layerTolerance {
+				blended = true
+				break
+			}
+		}
+		if !blended {
+			seeds = append(seeds, c)
+		}
+	}
+
+	layers := make([][]bool, len(seeds))
+	for i := range layers {
+		layers[i] = make([]bool, width*height)
+	}
+	for i, v := range ink {
+		if !v {
+			continue
+		}
+		c := pixel(i)
+		best, bestResidual := -1, float64(layerTolerance)
+		for j, seed := range seeds {
+			if t, residual := blendFactor(c, seed, background); t >= 0.3 && t <= 1.2 && residual < bestResidual {
+				best, bestResidual = j, residual
+			}
+		}
+		if best >= 0 {
+			layers[best][i] = true
+		}
+	}
+	return layers
+}
+
+// layerTolerance is the largest distance, in RGB units, between a pixel and a seed's blend line
+const layerTolerance = 24
+
+// blendFactor projects c onto the line from background to seed. It returns the blend
+// factor (0 at the background, 1 at the seed) and the distance from the line.
+func blendFactor(c, seed, background [3]float64) (float64, float64) {
+	var dot, norm float64
+	for k := 0; k < 3; k++ {
+		dot += (c[k] - background[k]) * (seed[k] - background[k])
+		norm += (seed[k] - background[k]) * (seed[k] - background[k])
+	}
+	if norm == 0 {
+		return 0, math.Inf(1)
+	}
+
+	t := dot / norm
+	var residual float64
+	for k := 0; k < 3; k++ {
+		d := c[k] - (background[k] + t*(seed[k]-background[k]))
+		residual += d * d
+	}
+	return t, math.Sqrt(residual)
+}
+
+// colorDistance returns the Euclidean distance between two colors
+func colorDistance(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
+
+// projectionSegments splits a mask into column spans separated by empty columns,
+// dropping spans narrower than minWidth
+func projectionSegments(mask []bool, width, height, minWidth int) [][2]int {
+	var spans [][2]int
+	start := -1
+	for x := 0; x <= width; x++ {
+		hasInk := false
+		if x < width {
+			for y := 0; y < height && !hasInk; y++ {
+				hasInk = mask[y*width+x]
+			}
+		}
+
+		switch {
+		case hasInk && start < 0:
+			start = x
+		case !hasInk && start >= 0:
+			if x-start >= minWidth {
+				spans = append(spans, [2]int{start, x})
+			}
+			start = -1
+		}
+	}
+	return spans
+}
+
+// inkMask marks pixels whose color differs from the background by more than threshold
+func inkMask(img *image.RGBA, background [3]float64, threshold float64) []bool {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	mask := make([]bool, width*height)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			diff := math.Abs(float64(c.R)-background[0]) +
+				math.Abs(float64(c.G)-background[1]) +
+				math.Abs(float64(c.B)-background[2])
+			mask[y*width+x] = diff > threshold
+		}
+	}
+
+	return mask
+}
+
+// normalizeSegment crops the ink in columns [x0, x1), resamples it onto the matching grid
+// and returns the ink height. The glyph keeps its aspect ratio so that thin characters
+// such as "1" and "-" stay distinct.
+func normalizeSegment(mask []bool, width, height, x0, x1 int) ([]float64, int, bool) {
+	minX, minY, maxX, maxY := width, height, -1, -1
+	for y := 0; y < height; y++ {
+		for x := x0; x < x1; x++ {
+			if mask[y*width+x] {
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+	}
+	if maxX < 0 {
+		return nil, 0, false
+	}
+
+	cropWidth := float64(maxX - minX + 1)
+	cropHeight := float64(maxY - minY + 1)
+	scale := math.Max(cropWidth/gridWidth, cropHeight/gridHeight)
+	offsetX := (gridWidth - cropWidth/scale) / 2
+	offsetY := (gridHeight - cropHeight/scale) / 2
+
+	grid := make([]float64, gridWidth*gridHeight)
+	counts := make([]float64, gridWidth*gridHeight)
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			gx := int(float64(x-minX)/scale + offsetX)
+			gy := int(float64(y-minY)/scale + offsetY)
+			if gx < 0 || gx >= gridWidth || gy < 0 || gy >= gridHeight {
+				continue
+			}
+			counts[gy*gridWidth+gx]++
+			if mask[y*width+x] {
+				grid[gy*gridWidth+gx]++
+			}
+		}
+	}
+	for i := range grid {
+		if counts[i] > 0 {
+			grid[i] /= counts[i]
+		}
+	}
+
+	return grid, maxY - minY + 1, true
+}
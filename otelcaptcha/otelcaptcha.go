@@ -0,0 +1,82 @@
+// Package otelcaptcha reports captcha spans to OpenTelemetry. It lives in its own module so the
+// captcha package stays free of dependencies.
+package otelcaptcha
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"svg-math-captcha/captcha"
+)
+
+// Tracer adapts an OpenTelemetry tracer to captcha.Tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer wraps tracer, for example otel.Tracer("svg-math-captcha")
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+// Start starts an OpenTelemetry span as a child of any span in ctx
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...captcha.Attribute) (context.Context, captcha.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(convertAttributes(attrs)...))
+	return ctx, Span{span: span}
+}
+
+// Span adapts an OpenTelemetry span to captcha.Span
+type Span struct {
+	span trace.Span
+}
+
+// IsRecording reports whether the span records attributes and errors
+func (s Span) IsRecording() bool {
+	return s.span.IsRecording()
+}
+
+// SetAttributes sets attributes on the span
+func (s Span) SetAttributes(attrs ...captcha.Attribute) {
+	s.span.SetAttributes(convertAttributes(attrs)...)
+}
+
+// RecordError records err as an exception event and marks the span as failed
+func (s Span) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span
+func (s Span) End() {
+	s.span.End()
+}
+
+// convertAttributes converts captcha attributes to OpenTelemetry key-values
+func convertAttributes(attrs []captcha.Attribute) []attribute.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	converted := make([]attribute.KeyValue, len(attrs))
+	for i, attr := range attrs {
+		switch value := attr.Value.(type) {
+		case string:
+			converted[i] = attribute.String(attr.Key, value)
+		case int:
+			converted[i] = attribute.Int(attr.Key, value)
+		case int64:
+			converted[i] = attribute.Int64(attr.Key, value)
+		case float64:
+			converted[i] = attribute.Float64(attr.Key, value)
+		case bool:
+			converted[i] = attribute.Bool(attr.Key, value)
+		default:
+			converted[i] = attribute.String(attr.Key, fmt.Sprint(value))
+		}
+	}
+	return converted
+}
@@ -0,0 +1,75 @@
+package otelcaptcha
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"svg-math-captcha/captcha"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider.Tracer("svg-math-captcha"))
+
+	generator := captcha.NewCaptchaGenerator(captcha.DefaultConfig(), captcha.WithTracer(tracer))
+	service := captcha.NewService(generator, nil)
+
+	ctx, parent := provider.Tracer("test").Start(t.Context(), "http.request")
+	if _, err := service.IssueContext(ctx); err != nil {
+		t.Fatalf("Failed to issue captcha: %v", err)
+	}
+	parent.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{captcha.SpanGenerate, captcha.SpanExpression, captcha.SpanRender, captcha.SpanNoise, captcha.SpanStoreSet} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Expected span %s, got %d spans", name, len(spans))
+		}
+	}
+
+	generate := spans[captcha.SpanGenerate]
+	if generate.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the generate span under the request span")
+	}
+	if spans[captcha.SpanNoise].Parent().SpanID() != spans[captcha.SpanRender].SpanContext().SpanID() {
+		t.Error("Expected the noise span under the render span")
+	}
+
+	found := false
+	for _, attr := range generate.Attributes() {
+		if attr == attribute.Int("captcha.width", captcha.DefaultConfig().Width) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected width attribute, got %v", generate.Attributes())
+	}
+}
+
+func TestTracerRecordsErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	generator := captcha.NewCaptchaGenerator(nil, captcha.WithTracer(NewTracer(provider.Tracer("svg-math-captcha"))))
+
+	invalid := captcha.DefaultConfig()
+	invalid.Width = -1
+	if _, err := generator.CreateMathExprWithOptions(invalid); err == nil {
+		t.Fatal("Expected invalid options to fail")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error {
+		t.Fatalf("Expected one failed span, got %v", spans)
+	}
+	if len(spans[0].Events()) != 1 || spans[0].Events()[0].Name != "exception" {
+		t.Errorf("Expected an exception event, got %v", spans[0].Events())
+	}
+}